@@ -11,6 +11,25 @@ type Config struct {
 	SecretKey                string `mapstructure:"SECRET_KEY"`
 	ExpirationToken          int    `mapstructure:"EXPIRATION_TOKEN"`
 	LimitRequestsByToken     int64  `mapstructure:"LIMIT_REQUESTS_BY_TOKEN"`
+	StoreType                string `mapstructure:"STORE_TYPE"`
+	RedisMode                string `mapstructure:"REDIS_MODE"`
+	RedisMasterName          string `mapstructure:"REDIS_MASTER_NAME"`
+	RedisPassword            string `mapstructure:"REDIS_PASSWORD"`
+	RedisMigrateKeys         bool   `mapstructure:"REDIS_MIGRATE_KEYS"`
+	RateLimitHeaders         string `mapstructure:"RATE_LIMIT_HEADERS"`
+	TrustedProxies           string `mapstructure:"TRUSTED_PROXIES"`
+	IPv4Prefix               int    `mapstructure:"IPV4_PREFIX"`
+	IPv6Prefix               int    `mapstructure:"IPV6_PREFIX"`
+	CIDRLimits               string `mapstructure:"CIDR_LIMITS"`
+	RoutePolicies            string `mapstructure:"ROUTE_POLICIES"`
+	GlobalLimit              string `mapstructure:"GLOBAL_LIMIT"`
+	RequestCostHeader        string `mapstructure:"REQUEST_COST_HEADER"`
+	DryRun                   bool   `mapstructure:"DRY_RUN"`
+	RejectionStatus          int    `mapstructure:"REJECTION_STATUS"`
+	RejectionLanguage        string `mapstructure:"REJECTION_LANGUAGE"`
+	RejectionMessage         string `mapstructure:"REJECTION_MESSAGE"`
+	AdminTokens              string `mapstructure:"ADMIN_TOKENS"`
+	AdminJWTSecret           string `mapstructure:"ADMIN_JWT_SECRET"`
 }
 
 func LoadConfig() (Config, error) {
@@ -65,3 +84,8 @@ func GetLimitRequestsByToken() int64 {
 	config, _ := LoadConfig()
 	return config.LimitRequestsByToken
 }
+
+func GetStoreType() string {
+	config, _ := LoadConfig()
+	return config.StoreType
+}
@@ -24,9 +24,305 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
+        "/access-rules": {
+            "get": {
+                "security": [
+                    {
+                        "AdminAuth": []
+                    }
+                ],
+                "description": "list the allow and deny list rules",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "access rules"
+                ],
+                "summary": "List access rules",
+                "responses": {
+                    "200": {
+                        "description": "Successfully retrieved the access rules",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/middleware.AccessRule"
+                            }
+                        }
+                    },
+                    "401": {
+                        "description": "Missing or invalid admin credentials",
+                        "schema": {
+                            "$ref": "#/definitions/middleware.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/middleware.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "security": [
+                    {
+                        "AdminAuth": []
+                    }
+                ],
+                "description": "put an exact key, an IP CIDR or a key prefix on the allow list, where it is never limited, or on the deny list, where it is always refused",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "access rules"
+                ],
+                "summary": "Add an access rule",
+                "parameters": [
+                    {
+                        "description": "Access rule",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/middleware.AccessRule"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Successfully added the access rule",
+                        "schema": {
+                            "$ref": "#/definitions/middleware.AccessRule"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid access rule",
+                        "schema": {
+                            "$ref": "#/definitions/middleware.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Missing or invalid admin credentials",
+                        "schema": {
+                            "$ref": "#/definitions/middleware.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Admin credentials without the write role",
+                        "schema": {
+                            "$ref": "#/definitions/middleware.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/middleware.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "AdminAuth": []
+                    }
+                ],
+                "description": "remove a rule from the allow or deny list",
+                "tags": [
+                    "access rules"
+                ],
+                "summary": "Delete an access rule",
+                "parameters": [
+                    {
+                        "enum": [
+                            "allow",
+                            "deny"
+                        ],
+                        "type": "string",
+                        "description": "List of the rule",
+                        "name": "list",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "enum": [
+                            "key",
+                            "cidr",
+                            "prefix"
+                        ],
+                        "type": "string",
+                        "description": "Match of the rule",
+                        "name": "match",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Value of the rule",
+                        "name": "value",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "Successfully deleted the access rule"
+                    },
+                    "400": {
+                        "description": "Invalid access rule",
+                        "schema": {
+                            "$ref": "#/definitions/middleware.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Missing or invalid admin credentials",
+                        "schema": {
+                            "$ref": "#/definitions/middleware.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Admin credentials without the write role",
+                        "schema": {
+                            "$ref": "#/definitions/middleware.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Access rule not found",
+                        "schema": {
+                            "$ref": "#/definitions/middleware.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/middleware.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
         "/get-all-rate-limiter": {
             "get": {
-                "description": "get all rate limiter settings",
+                "security": [
+                    {
+                        "AdminAuth": []
+                    }
+                ],
+                "description": "list rate limiter settings one page at a time, pass the returned next_cursor to get the next page",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "rate limiter"
+                ],
+                "summary": "List rate limiter settings",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Cursor returned by the previous page",
+                        "name": "cursor",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Number of settings per page (default 100, max 1000)",
+                        "name": "page_size",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Successfully retrieved a page of rate limiter settings",
+                        "schema": {
+                            "$ref": "#/definitions/middleware.LimitDataPage"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid cursor or page size",
+                        "schema": {
+                            "$ref": "#/definitions/middleware.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Missing or invalid admin credentials",
+                        "schema": {
+                            "$ref": "#/definitions/middleware.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/middleware.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/home": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "get index",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "home"
+                ],
+                "summary": "Welcome to the rate limited index page!",
+                "responses": {
+                    "200": {
+                        "description": "Welcome to the rate limited index page!",
+                        "schema": {
+                            "$ref": "#/definitions/server.IndexResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/token": {
+            "get": {
+                "description": "get authToken",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "token"
+                ],
+                "summary": "Generates a new auth token",
+                "responses": {
+                    "200": {
+                        "description": "token detail",
+                        "schema": {
+                            "$ref": "#/definitions/server.AuthTokenResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/update-rate-limiter/{key}": {
+            "put": {
+                "security": [
+                    {
+                        "AdminAuth": []
+                    }
+                ],
+                "description": "update the settings present in the body, for a specific key (ip or token) given in the path, the key and id are kept",
                 "consumes": [
                     "application/json"
                 ],
@@ -36,15 +332,713 @@ const docTemplate = `{
                 "tags": [
                     "rate limiter"
                 ],
-                "summary": "Get all rate limiter settings",
+                "summary": "Update rate limiter settings",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Key whose settings are updated",
+                        "name": "key",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Update rate limiter settings",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/middleware.LimitDataInput"
+                        }
+                    }
+                ],
                 "responses": {
                     "200": {
-                        "description": "Successfully retrieved all rate limiter settings",
+                        "description": "Successfully updated rate limiter settings",
+                        "schema": {
+                            "$ref": "#/definitions/middleware.LimitData"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request body or settings",
+                        "schema": {
+                            "$ref": "#/definitions/middleware.ValidationErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Missing or invalid admin credentials",
+                        "schema": {
+                            "$ref": "#/definitions/middleware.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Admin credentials without the write role",
+                        "schema": {
+                            "$ref": "#/definitions/middleware.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Key without settings",
+                        "schema": {
+                            "$ref": "#/definitions/middleware.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/middleware.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/keys/blocked": {
+            "get": {
+                "security": [
+                    {
+                        "AdminAuth": []
+                    }
+                ],
+                "description": "list the keys blocked right now, with when they were blocked, when the block ends and the policy that blocked them or the reason and actor of a ban, one page at a time, pass the returned next_cursor to get the next page",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "keys"
+                ],
+                "summary": "List blocked keys",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Cursor returned by the previous page",
+                        "name": "cursor",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Number of blocks per page (default 100, max 1000)",
+                        "name": "page_size",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Successfully retrieved a page of blocks",
+                        "schema": {
+                            "$ref": "#/definitions/middleware.BlockedPage"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid cursor or page size",
+                        "schema": {
+                            "$ref": "#/definitions/middleware.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Missing or invalid admin credentials",
+                        "schema": {
+                            "$ref": "#/definitions/middleware.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/middleware.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/keys/reset": {
+            "post": {
+                "security": [
+                    {
+                        "AdminAuth": []
+                    }
+                ],
+                "description": "delete the counters and blocks of every key matching a glob pattern, bans excepted, where * matches any text, ? any character, [abc] one of the characters and \\ escapes the next one",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "keys"
+                ],
+                "summary": "Reset the keys matching a pattern",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Glob pattern of the keys, such as user:*",
+                        "name": "pattern",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Number of keys reset",
+                        "schema": {
+                            "$ref": "#/definitions/middleware.BulkResult"
+                        }
+                    },
+                    "400": {
+                        "description": "Missing pattern",
+                        "schema": {
+                            "$ref": "#/definitions/middleware.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Missing or invalid admin credentials",
+                        "schema": {
+                            "$ref": "#/definitions/middleware.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Admin credentials without the write role",
+                        "schema": {
+                            "$ref": "#/definitions/middleware.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/middleware.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/keys/unblock": {
+            "post": {
+                "security": [
+                    {
+                        "AdminAuth": []
+                    }
+                ],
+                "description": "lift the block of every key matching a glob pattern, bans excepted, with the same syntax as the bulk reset",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "keys"
+                ],
+                "summary": "Unblock the keys matching a pattern",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Glob pattern of the keys, such as user:*",
+                        "name": "pattern",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Number of keys that were blocked",
+                        "schema": {
+                            "$ref": "#/definitions/middleware.BulkResult"
+                        }
+                    },
+                    "400": {
+                        "description": "Missing pattern",
+                        "schema": {
+                            "$ref": "#/definitions/middleware.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Missing or invalid admin credentials",
+                        "schema": {
+                            "$ref": "#/definitions/middleware.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Admin credentials without the write role",
+                        "schema": {
+                            "$ref": "#/definitions/middleware.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/middleware.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/keys/{key}/ban": {
+            "post": {
+                "security": [
+                    {
+                        "AdminAuth": []
+                    }
+                ],
+                "description": "block a key or an IP by hand for a duration in seconds, or for good when it is zero, recording why and by whom, its requests are refused with 403 and the reason banned, and resets and unblocks leave it in place until it is revoked or expires",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "keys"
+                ],
+                "summary": "Ban a key",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Key or IP",
+                        "name": "key",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Ban",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/middleware.BanRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Successfully banned the key",
+                        "schema": {
+                            "$ref": "#/definitions/middleware.BlockInfo"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid ban",
+                        "schema": {
+                            "$ref": "#/definitions/middleware.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Missing or invalid admin credentials",
+                        "schema": {
+                            "$ref": "#/definitions/middleware.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Admin credentials without the write role",
+                        "schema": {
+                            "$ref": "#/definitions/middleware.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Missing key",
+                        "schema": {
+                            "$ref": "#/definitions/middleware.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/middleware.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "AdminAuth": []
+                    }
+                ],
+                "description": "lift the ban of a key or an IP, a block set by a limit is left in place",
+                "tags": [
+                    "keys"
+                ],
+                "summary": "Revoke the ban of a key",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Key or IP",
+                        "name": "key",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "Successfully revoked the ban"
+                    },
+                    "401": {
+                        "description": "Missing or invalid admin credentials",
+                        "schema": {
+                            "$ref": "#/definitions/middleware.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Admin credentials without the write role",
+                        "schema": {
+                            "$ref": "#/definitions/middleware.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Key not banned",
+                        "schema": {
+                            "$ref": "#/definitions/middleware.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/middleware.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/keys/{key}/reset": {
+            "post": {
+                "security": [
+                    {
+                        "AdminAuth": []
+                    }
+                ],
+                "description": "delete the counters and the block of a key, as well as those the route policies and the global cap keep for it, so its next request starts with a full quota, its limit and its ban are kept",
+                "tags": [
+                    "keys"
+                ],
+                "summary": "Reset a key",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Limited key",
+                        "name": "key",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "Successfully reset the key"
+                    },
+                    "401": {
+                        "description": "Missing or invalid admin credentials",
+                        "schema": {
+                            "$ref": "#/definitions/middleware.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Admin credentials without the write role",
+                        "schema": {
+                            "$ref": "#/definitions/middleware.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Missing key",
+                        "schema": {
+                            "$ref": "#/definitions/middleware.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/middleware.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/keys/{key}/unblock": {
+            "post": {
+                "security": [
+                    {
+                        "AdminAuth": []
+                    }
+                ],
+                "description": "lift the block of a key, as well as those the route policies and the global cap set on it, its counters are kept, so a key still over its limit is blocked again on its next request",
+                "tags": [
+                    "keys"
+                ],
+                "summary": "Unblock a key",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Limited key",
+                        "name": "key",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "Successfully unblocked the key"
+                    },
+                    "401": {
+                        "description": "Missing or invalid admin credentials",
+                        "schema": {
+                            "$ref": "#/definitions/middleware.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Admin credentials without the write role",
+                        "schema": {
+                            "$ref": "#/definitions/middleware.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Key not blocked",
+                        "schema": {
+                            "$ref": "#/definitions/middleware.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Key banned, revoke the ban instead",
+                        "schema": {
+                            "$ref": "#/definitions/middleware.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/middleware.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/limits": {
+            "get": {
+                "security": [
+                    {
+                        "AdminAuth": []
+                    }
+                ],
+                "description": "list the limit data one page at a time, pass the returned next_cursor to get the next page",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "limits"
+                ],
+                "summary": "List limits",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Cursor returned by the previous page",
+                        "name": "cursor",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Number of limits per page (default 100, max 1000)",
+                        "name": "page_size",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Successfully retrieved a page of limits",
+                        "schema": {
+                            "$ref": "#/definitions/middleware.LimitDataPage"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid cursor or page size",
+                        "schema": {
+                            "$ref": "#/definitions/middleware.ErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Missing or invalid admin credentials",
+                        "schema": {
+                            "$ref": "#/definitions/middleware.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/middleware.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "security": [
+                    {
+                        "AdminAuth": []
+                    }
+                ],
+                "description": "create the limit data of a key, the id is generated",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "limits"
+                ],
+                "summary": "Create a limit",
+                "parameters": [
+                    {
+                        "description": "Limit, without id",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/middleware.LimitData"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Successfully created the limit",
+                        "schema": {
+                            "$ref": "#/definitions/middleware.LimitData"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid limit",
+                        "schema": {
+                            "$ref": "#/definitions/middleware.ValidationErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Missing or invalid admin credentials",
+                        "schema": {
+                            "$ref": "#/definitions/middleware.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Admin credentials without the write role",
+                        "schema": {
+                            "$ref": "#/definitions/middleware.ErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "The key already has a limit",
+                        "schema": {
+                            "$ref": "#/definitions/middleware.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/middleware.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/limits/{ref}": {
+            "get": {
+                "security": [
+                    {
+                        "AdminAuth": []
+                    }
+                ],
+                "description": "get the limit data with the given id, or else of the given key",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "limits"
+                ],
+                "summary": "Get a limit",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Id or key of the limit",
+                        "name": "ref",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Successfully retrieved the limit",
+                        "schema": {
+                            "$ref": "#/definitions/middleware.LimitData"
+                        }
+                    },
+                    "401": {
+                        "description": "Missing or invalid admin credentials",
+                        "schema": {
+                            "$ref": "#/definitions/middleware.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Limit not found",
+                        "schema": {
+                            "$ref": "#/definitions/middleware.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/middleware.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "security": [
+                    {
+                        "AdminAuth": []
+                    }
+                ],
+                "description": "replace every setting of the limit with the given id or key, the key and id are kept",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "limits"
+                ],
+                "summary": "Replace a limit",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Id or key of the limit",
+                        "name": "ref",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Limit",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/middleware.LimitData"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Successfully replaced the limit",
+                        "schema": {
+                            "$ref": "#/definitions/middleware.LimitData"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid limit",
                         "schema": {
-                            "type": "array",
-                            "items": {
-                                "$ref": "#/definitions/middleware.LimitData"
-                            }
+                            "$ref": "#/definitions/middleware.ValidationErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Missing or invalid admin credentials",
+                        "schema": {
+                            "$ref": "#/definitions/middleware.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Admin credentials without the write role",
+                        "schema": {
+                            "$ref": "#/definitions/middleware.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Limit not found",
+                        "schema": {
+                            "$ref": "#/definitions/middleware.ErrorResponse"
                         }
                     },
                     "500": {
@@ -54,67 +1048,64 @@ const docTemplate = `{
                         }
                     }
                 }
-            }
-        },
-        "/home": {
-            "get": {
+            },
+            "delete": {
                 "security": [
                     {
-                        "ApiKeyAuth": []
+                        "AdminAuth": []
                     }
                 ],
-                "description": "get index",
-                "consumes": [
-                    "application/json"
-                ],
-                "produces": [
-                    "application/json"
-                ],
+                "description": "delete the limit with the given id or key, its key goes back to the default limit",
                 "tags": [
-                    "home"
+                    "limits"
                 ],
-                "summary": "Welcome to the rate limited index page!",
-                "responses": {
-                    "200": {
-                        "description": "Welcome to the rate limited index page!",
-                        "schema": {
-                            "$ref": "#/definitions/server.IndexResponse"
-                        }
+                "summary": "Delete a limit",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Id or key of the limit",
+                        "name": "ref",
+                        "in": "path",
+                        "required": true
                     }
-                }
-            }
-        },
-        "/token": {
-            "get": {
-                "description": "get authToken",
-                "consumes": [
-                    "application/json"
                 ],
-                "produces": [
-                    "application/json"
-                ],
-                "tags": [
-                    "token"
-                ],
-                "summary": "Generates a new auth token",
                 "responses": {
-                    "200": {
-                        "description": "token detail",
+                    "204": {
+                        "description": "Successfully deleted the limit"
+                    },
+                    "401": {
+                        "description": "Missing or invalid admin credentials",
                         "schema": {
-                            "$ref": "#/definitions/server.AuthTokenResponse"
+                            "$ref": "#/definitions/middleware.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Admin credentials without the write role",
+                        "schema": {
+                            "$ref": "#/definitions/middleware.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Limit not found",
+                        "schema": {
+                            "$ref": "#/definitions/middleware.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/middleware.ErrorResponse"
                         }
                     }
                 }
-            }
-        },
-        "/update-rate-limiter/": {
-            "put": {
+            },
+            "patch": {
                 "security": [
                     {
-                        "ApiKeyAuth": []
+                        "AdminAuth": []
                     }
                 ],
-                "description": "update rate limiter settings for a specific key (ip or token)",
+                "description": "update the settings present in the body, of the limit with the given id or key",
                 "consumes": [
                     "application/json"
                 ],
@@ -122,12 +1113,19 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "rate limiter"
+                    "limits"
                 ],
-                "summary": "Update rate limiter settings",
+                "summary": "Update a limit",
                 "parameters": [
                     {
-                        "description": "Update rate limiter settings",
+                        "type": "string",
+                        "description": "Id or key of the limit",
+                        "name": "ref",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Settings to update",
                         "name": "body",
                         "in": "body",
                         "required": true,
@@ -138,13 +1136,31 @@ const docTemplate = `{
                 ],
                 "responses": {
                     "200": {
-                        "description": "Successfully updated rate limiter settings",
+                        "description": "Successfully updated the limit",
                         "schema": {
-                            "$ref": "#/definitions/middleware.LimitDataInput"
+                            "$ref": "#/definitions/middleware.LimitData"
                         }
                     },
                     "400": {
-                        "description": "Invalid request body",
+                        "description": "Invalid limit",
+                        "schema": {
+                            "$ref": "#/definitions/middleware.ValidationErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Missing or invalid admin credentials",
+                        "schema": {
+                            "$ref": "#/definitions/middleware.ErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Admin credentials without the write role",
+                        "schema": {
+                            "$ref": "#/definitions/middleware.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Limit not found",
                         "schema": {
                             "$ref": "#/definitions/middleware.ErrorResponse"
                         }
@@ -160,6 +1176,103 @@ const docTemplate = `{
         }
     },
     "definitions": {
+        "middleware.AccessRule": {
+            "type": "object",
+            "properties": {
+                "list": {
+                    "type": "string",
+                    "enum": [
+                        "allow",
+                        "deny"
+                    ]
+                },
+                "match": {
+                    "type": "string",
+                    "enum": [
+                        "key",
+                        "cidr",
+                        "prefix"
+                    ]
+                },
+                "value": {
+                    "type": "string"
+                }
+            }
+        },
+        "middleware.BanRequest": {
+            "type": "object",
+            "properties": {
+                "actor": {
+                    "type": "string",
+                    "example": "trust-and-safety@example.com"
+                },
+                "duration": {
+                    "type": "integer",
+                    "example": 86400
+                },
+                "reason": {
+                    "type": "string",
+                    "example": "scraping the catalog"
+                }
+            }
+        },
+        "middleware.BlockInfo": {
+            "type": "object",
+            "properties": {
+                "actor": {
+                    "type": "string"
+                },
+                "blocked_at": {
+                    "type": "string"
+                },
+                "expires_at": {
+                    "type": "string"
+                },
+                "key": {
+                    "type": "string"
+                },
+                "policy": {
+                    "description": "Policy is the limit the key went over: the id of its limit data, or the\nalgorithm when the limit data has no id.",
+                    "type": "string"
+                },
+                "reason": {
+                    "description": "Reason and Actor say why and by whom a ban was set.",
+                    "type": "string"
+                },
+                "type": {
+                    "type": "string",
+                    "enum": [
+                        "limit",
+                        "ban"
+                    ]
+                }
+            }
+        },
+        "middleware.BlockedPage": {
+            "type": "object",
+            "properties": {
+                "items": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/ratelimiter.BlockInfo"
+                    }
+                },
+                "next_cursor": {
+                    "type": "string"
+                }
+            }
+        },
+        "middleware.BulkResult": {
+            "type": "object",
+            "properties": {
+                "count": {
+                    "type": "integer"
+                },
+                "pattern": {
+                    "type": "string"
+                }
+            }
+        },
         "middleware.ErrorResponse": {
             "type": "object",
             "properties": {
@@ -168,22 +1281,61 @@ const docTemplate = `{
                 }
             }
         },
+        "middleware.FieldError": {
+            "type": "object",
+            "properties": {
+                "field": {
+                    "type": "string"
+                },
+                "message": {
+                    "type": "string"
+                }
+            }
+        },
         "middleware.LimitData": {
             "description": "Struct to store rate limiter data",
             "type": "object",
             "properties": {
+                "algorithm": {
+                    "type": "string"
+                },
                 "block_duration": {
                     "type": "integer"
                 },
+                "capacity": {
+                    "type": "integer"
+                },
+                "dry_run": {
+                    "description": "DryRun makes the limit be counted and evaluated without refusing the\nrequests it would have limited.",
+                    "type": "boolean"
+                },
                 "id": {
                     "type": "string"
                 },
                 "key": {
                     "type": "string"
                 },
+                "leak_per_second": {
+                    "type": "number"
+                },
+                "lease_seconds": {
+                    "type": "integer"
+                },
+                "max_in_flight": {
+                    "type": "integer"
+                },
+                "max_queue": {
+                    "type": "integer"
+                },
                 "max_requests": {
                     "type": "integer"
                 },
+                "max_wait": {
+                    "type": "integer"
+                },
+                "refill_per_second": {
+                    "type": "number"
+                },
                 "seconds": {
                     "type": "integer"
                 }
@@ -193,12 +1345,156 @@ const docTemplate = `{
             "description": "Struct to store rate limiter data for Swagger documentation",
             "type": "object",
             "properties": {
+                "algorithm": {
+                    "type": "string",
+                    "enum": [
+                        "fixed_window",
+                        "sliding_window",
+                        "sliding_window_log",
+                        "token_bucket",
+                        "gcra",
+                        "leaky_bucket"
+                    ]
+                },
+                "block_duration": {
+                    "type": "integer"
+                },
+                "capacity": {
+                    "type": "integer"
+                },
+                "dry_run": {
+                    "type": "boolean"
+                },
+                "leak_per_second": {
+                    "type": "number"
+                },
+                "lease_seconds": {
+                    "type": "integer"
+                },
+                "max_in_flight": {
+                    "type": "integer"
+                },
+                "max_queue": {
+                    "type": "integer"
+                },
+                "max_requests": {
+                    "type": "integer"
+                },
+                "max_wait": {
+                    "type": "integer"
+                },
+                "refill_per_second": {
+                    "type": "number"
+                },
+                "seconds": {
+                    "type": "integer"
+                }
+            }
+        },
+        "middleware.LimitDataPage": {
+            "type": "object",
+            "properties": {
+                "items": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/ratelimiter.LimitData"
+                    }
+                },
+                "next_cursor": {
+                    "type": "string"
+                }
+            }
+        },
+        "middleware.ValidationErrorResponse": {
+            "type": "object",
+            "properties": {
+                "errors": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/middleware.FieldError"
+                    }
+                },
+                "message": {
+                    "type": "string"
+                }
+            }
+        },
+        "ratelimiter.BlockInfo": {
+            "type": "object",
+            "properties": {
+                "actor": {
+                    "type": "string"
+                },
+                "blocked_at": {
+                    "type": "string"
+                },
+                "expires_at": {
+                    "type": "string"
+                },
+                "key": {
+                    "type": "string"
+                },
+                "policy": {
+                    "description": "Policy is the limit the key went over: the id of its limit data, or the\nalgorithm when the limit data has no id.",
+                    "type": "string"
+                },
+                "reason": {
+                    "description": "Reason and Actor say why and by whom a ban was set.",
+                    "type": "string"
+                },
+                "type": {
+                    "type": "string",
+                    "enum": [
+                        "limit",
+                        "ban"
+                    ]
+                }
+            }
+        },
+        "ratelimiter.LimitData": {
+            "description": "Struct to store rate limiter data",
+            "type": "object",
+            "properties": {
+                "algorithm": {
+                    "type": "string"
+                },
                 "block_duration": {
                     "type": "integer"
                 },
+                "capacity": {
+                    "type": "integer"
+                },
+                "dry_run": {
+                    "description": "DryRun makes the limit be counted and evaluated without refusing the\nrequests it would have limited.",
+                    "type": "boolean"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "key": {
+                    "type": "string"
+                },
+                "leak_per_second": {
+                    "type": "number"
+                },
+                "lease_seconds": {
+                    "type": "integer"
+                },
+                "max_in_flight": {
+                    "type": "integer"
+                },
+                "max_queue": {
+                    "type": "integer"
+                },
                 "max_requests": {
                     "type": "integer"
                 },
+                "max_wait": {
+                    "type": "integer"
+                },
+                "refill_per_second": {
+                    "type": "number"
+                },
                 "seconds": {
                     "type": "integer"
                 }
@@ -222,6 +1518,12 @@ const docTemplate = `{
         }
     },
     "securityDefinitions": {
+        "AdminAuth": {
+            "description": "Admin token or JWT as \"Bearer \u003ctoken\u003e\"",
+            "type": "apiKey",
+            "name": "Authorization",
+            "in": "header"
+        },
         "ApiKeyAuth": {
             "type": "apiKey",
             "name": "API_KEY",
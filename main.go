@@ -8,6 +8,7 @@ import (
 	"ratelimiter/middleware"
 	"ratelimiter/pkg/ratelimiter"
 	"ratelimiter/server"
+	"strings"
 )
 
 // @title           Rate Limiter API Example
@@ -29,30 +30,122 @@ import (
 // @in header
 // @name API_KEY
 // @type apiKey
+//
+// @securityDefinitions.apikey AdminAuth
+// @in header
+// @name Authorization
+// @description Admin token or JWT as "Bearer <token>"
 func main() {
-	_, err := configs.LoadConfig()
+	config, err := configs.LoadConfig()
 	if err != nil {
 		log.Fatalf("Failed to load config: %v", err)
 	}
-	store := ConnectToRedis()
-	if err := store.Ping(); err != nil {
-		log.Fatalf("Failed to connect to Redis: %v", err)
-	}
-	log.Println("Connected to Redis successfully")
+	store := NewStore(config)
 
 	rateLimiter := ratelimiter.NewRateLimiter(store)
-	rateLimiterMiddleware := middleware.NewRateLimiterMiddleware(rateLimiter)
+	rateLimiterMiddleware := middleware.NewRateLimiterMiddleware(rateLimiter, MiddlewareOptions(config)...)
 
-	s := server.NewServer(rateLimiterMiddleware)
+	s := server.NewServer(rateLimiterMiddleware, NewAdminAuth(config))
 	log.Println("Starting the server...")
 	s.Start()
 	log.Println("Server started successfully")
 }
 
-func ConnectToRedis() *ratelimiter.RedisStore {
+// MiddlewareOptions turns the middleware settings of the config into options,
+// stopping the program when one of them cannot be parsed.
+func MiddlewareOptions(config configs.Config) []middleware.Option {
+	var opts []middleware.Option
+	if config.RateLimitHeaders == middleware.HeadersLegacy {
+		opts = append(opts, middleware.WithLegacyHeaders())
+	}
+	trustedProxies, err := middleware.ParseTrustedProxies(config.TrustedProxies)
+	if err != nil {
+		log.Fatalf("Failed to parse TRUSTED_PROXIES: %v", err)
+	}
+	opts = append(opts, middleware.WithTrustedProxies(trustedProxies))
+	cidrLimits, err := middleware.ParseCIDRLimits(config.CIDRLimits)
+	if err != nil {
+		log.Fatalf("Failed to parse CIDR_LIMITS: %v", err)
+	}
+	opts = append(opts, middleware.WithIPPrefixes(config.IPv4Prefix, config.IPv6Prefix), middleware.WithCIDRLimits(cidrLimits))
+	routePolicies, err := middleware.ParseRoutePolicies(config.RoutePolicies, int64(config.BlockDuration))
+	if err != nil {
+		log.Fatalf("Failed to parse ROUTE_POLICIES: %v", err)
+	}
+	opts = append(opts, middleware.WithRoutePolicies(routePolicies...))
+	if config.GlobalLimit != "" {
+		globalLimit, err := middleware.ParseQuota(config.GlobalLimit, int64(config.BlockDuration))
+		if err != nil {
+			log.Fatalf("Failed to parse GLOBAL_LIMIT: %v", err)
+		}
+		opts = append(opts, middleware.WithGlobalLimit(globalLimit))
+	}
+	if config.RequestCostHeader != "" {
+		opts = append(opts, middleware.WithCostFunc(middleware.HeaderCost(config.RequestCostHeader)))
+	}
+	if config.DryRun {
+		opts = append(opts, middleware.WithDryRun())
+	}
+	var messages map[string]map[string]string
+	if config.RejectionMessage != "" {
+		messages = map[string]map[string]string{
+			config.RejectionLanguage: {middleware.ReasonRateLimited: config.RejectionMessage},
+		}
+	}
+	rejectionResponder, err := middleware.NewRejectionResponder(config.RejectionStatus, config.RejectionLanguage, messages)
+	if err != nil {
+		log.Fatalf("Failed to configure the rejection response: %v", err)
+	}
+	opts = append(opts, middleware.WithRejectionHandler(rejectionResponder))
+	return opts
+}
+
+// NewAdminAuth builds the authentication of the management endpoints from the
+// config.
+func NewAdminAuth(config configs.Config) *middleware.AdminAuth {
+	tokens, err := middleware.ParseAdminTokens(config.AdminTokens)
+	if err != nil {
+		log.Fatalf("Failed to parse ADMIN_TOKENS: %v", err)
+	}
+	if len(tokens) == 0 && config.AdminJWTSecret == "" {
+		log.Println("No ADMIN_TOKENS or ADMIN_JWT_SECRET set, the management endpoints are disabled")
+	}
+	return middleware.NewAdminAuth(tokens, []byte(config.AdminJWTSecret))
+}
+
+func NewStore(config configs.Config) ratelimiter.Store {
+	if config.StoreType == "memory" {
+		log.Println("Using the in-memory store")
+		return ratelimiter.NewMemoryStore()
+	}
+
+	store, err := ConnectToRedis(config)
+	if err != nil {
+		log.Fatalf("Failed to configure Redis: %v", err)
+	}
+	if err := store.Ping(); err != nil {
+		log.Fatalf("Failed to connect to Redis: %v", err)
+	}
+	log.Println("Connected to Redis successfully")
+	if config.RedisMigrateKeys {
+		migrated, err := store.MigrateUntaggedKeys()
+		if err != nil {
+			log.Fatalf("Failed to migrate the untagged Redis keys: %v", err)
+		}
+		log.Printf("Migrated %d untagged Redis keys", migrated)
+	}
+	return store
+}
+
+func ConnectToRedis(config configs.Config) (*ratelimiter.RedisStore, error) {
 	redisAddress := os.Getenv("REDIS_ADDRESS")
 	if redisAddress == "" {
 		redisAddress = "localhost:6379"
 	}
-	return ratelimiter.NewRedisStore(redisAddress)
+	return ratelimiter.NewRedisStoreWithOptions(ratelimiter.RedisOptions{
+		Mode:       config.RedisMode,
+		Addrs:      strings.Split(redisAddress, ","),
+		MasterName: config.RedisMasterName,
+		Password:   config.RedisPassword,
+	})
 }
@@ -0,0 +1,124 @@
+package middleware
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"ratelimiter/pkg/ratelimiter"
+)
+
+type AccessRule = ratelimiter.AccessRule
+
+// checkAccess looks the key and the client IP up in the access lists. It
+// returns true when the request has been answered: refused because it is on
+// the deny list, or failed because the lists could not be read.
+func (m *RateLimiterMiddleware) checkAccess(w http.ResponseWriter, r *http.Request, key string) (string, bool) {
+	access, err := m.rateLimiter.CheckAccess(key, parseHost(m.clientIP(r)))
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return access, true
+	}
+	if access == ratelimiter.AccessDeny {
+		m.reject(w, r, Rejection{Status: http.StatusForbidden, Reason: ReasonAccessDenied})
+		return access, true
+	}
+	return access, false
+}
+
+// GetAccessRules godoc
+// @Summary List access rules
+// @Description list the allow and deny list rules
+// @Tags access rules
+// @Produce  json
+// @Success 200 {array} AccessRule "Successfully retrieved the access rules"
+// @Failure 401 {object} ErrorResponse "Missing or invalid admin credentials"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /access-rules [get]
+// @Security AdminAuth
+func (m *RateLimiterMiddleware) GetAccessRules(writer http.ResponseWriter, request *http.Request) {
+	rules, err := m.rateLimiter.GetAccessRules()
+	if err != nil {
+		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if rules == nil {
+		rules = []AccessRule{}
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(writer).Encode(rules)
+}
+
+// AddAccessRule godoc
+// @Summary Add an access rule
+// @Description put an exact key, an IP CIDR or a key prefix on the allow list, where it is never limited, or on the deny list, where it is always refused
+// @Tags access rules
+// @Accept  json
+// @Produce  json
+// @Param body body AccessRule true "Access rule"
+// @Success 201 {object} AccessRule "Successfully added the access rule"
+// @Failure 400 {object} ErrorResponse "Invalid access rule"
+// @Failure 401 {object} ErrorResponse "Missing or invalid admin credentials"
+// @Failure 403 {object} ErrorResponse "Admin credentials without the write role"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /access-rules [post]
+// @Security AdminAuth
+func (m *RateLimiterMiddleware) AddAccessRule(writer http.ResponseWriter, request *http.Request) {
+	var rule AccessRule
+	err := json.NewDecoder(request.Body).Decode(&rule)
+	if err != nil {
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	rule, err = m.rateLimiter.AddAccessRule(rule)
+	if errors.Is(err, ratelimiter.ErrInvalidAccessRule) {
+		writeErrorResponse(writer, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(writer).Encode(rule)
+}
+
+// DeleteAccessRule godoc
+// @Summary Delete an access rule
+// @Description remove a rule from the allow or deny list
+// @Tags access rules
+// @Param list query string true "List of the rule" Enums(allow, deny)
+// @Param match query string true "Match of the rule" Enums(key, cidr, prefix)
+// @Param value query string true "Value of the rule"
+// @Success 204 "Successfully deleted the access rule"
+// @Failure 400 {object} ErrorResponse "Invalid access rule"
+// @Failure 401 {object} ErrorResponse "Missing or invalid admin credentials"
+// @Failure 403 {object} ErrorResponse "Admin credentials without the write role"
+// @Failure 404 {object} ErrorResponse "Access rule not found"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /access-rules [delete]
+// @Security AdminAuth
+func (m *RateLimiterMiddleware) DeleteAccessRule(writer http.ResponseWriter, request *http.Request) {
+	query := request.URL.Query()
+	rule := AccessRule{List: query.Get("list"), Match: query.Get("match"), Value: query.Get("value")}
+
+	err := m.rateLimiter.RemoveAccessRule(rule)
+	if errors.Is(err, ratelimiter.ErrInvalidAccessRule) {
+		writeErrorResponse(writer, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err == ratelimiter.ErrNotFound {
+		http.Error(writer, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	writer.WriteHeader(http.StatusNoContent)
+}
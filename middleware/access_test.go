@@ -0,0 +1,94 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"ratelimiter/configs"
+	"ratelimiter/pkg/ratelimiter"
+	"strings"
+	"testing"
+)
+
+func TestAccessRules(t *testing.T) {
+	configs.LoadConfig()
+	store := ratelimiter.NewMemoryStore()
+	defer store.Close()
+	middleware := NewRateLimiterMiddleware(ratelimiter.NewRateLimiter(store),
+		WithRoutePolicies(RoutePolicy{Pattern: "/home", Limit: LimitData{Seconds: 60, MaxRequests: 1}}),
+	)
+	handler := middleware.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	}))
+	serve := func(remoteAddr string, apiKey string) int {
+		req := httptest.NewRequest("GET", "/home", nil)
+		req.RemoteAddr = remoteAddr
+		if apiKey != "" {
+			req.Header.Set("API_KEY", apiKey)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	for _, body := range []string{
+		`{"list": "allow", "match": "cidr", "value": "10.0.0.0/8"}`,
+		`{"list": "allow", "match": "key", "value": "health-checker"}`,
+		`{"list": "deny", "match": "cidr", "value": "203.0.113.0/24"}`,
+		`{"list": "deny", "match": "prefix", "value": "sk_revoked_"}`,
+	} {
+		rr := httptest.NewRecorder()
+		middleware.AddAccessRule(rr, httptest.NewRequest("POST", "/access-rules", strings.NewReader(body)))
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("adding %s returned wrong status code: got %v want %v", body, rr.Code, http.StatusCreated)
+		}
+	}
+
+	requests := []struct {
+		name       string
+		remoteAddr string
+		apiKey     string
+		want       int
+	}{
+		{"allowed CIDR", "10.1.2.3:1234", "", http.StatusOK},
+		{"allowed CIDR is not counted", "10.1.2.3:1234", "", http.StatusOK},
+		{"allowed key", "192.0.2.1:1234", "health-checker", http.StatusOK},
+		{"allowed key is not counted", "192.0.2.1:1234", "health-checker", http.StatusOK},
+		{"denied CIDR", "203.0.113.9:1234", "", http.StatusForbidden},
+		{"denied CIDR wins over an allowed key", "203.0.113.9:1234", "health-checker", http.StatusForbidden},
+		{"denied key prefix", "192.0.2.1:1234", "sk_revoked_123", http.StatusForbidden},
+		{"unlisted", "192.0.2.1:1234", "", http.StatusOK},
+		{"unlisted is counted", "192.0.2.1:1234", "", http.StatusTooManyRequests},
+	}
+	for _, request := range requests {
+		if status := serve(request.remoteAddr, request.apiKey); status != request.want {
+			t.Errorf("%s returned wrong status code: got %v want %v", request.name, status, request.want)
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	middleware.DeleteAccessRule(rr, httptest.NewRequest("DELETE", "/access-rules?list=deny&match=cidr&value=203.0.113.0/24", nil))
+	if rr.Code != http.StatusNoContent {
+		t.Errorf("delete returned wrong status code: got %v want %v", rr.Code, http.StatusNoContent)
+	}
+	if status := serve("203.0.113.9:1234", ""); status != http.StatusOK {
+		t.Errorf("removed deny rule returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	rr = httptest.NewRecorder()
+	middleware.DeleteAccessRule(rr, httptest.NewRequest("DELETE", "/access-rules?list=deny&match=cidr&value=203.0.113.0/24", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("second delete returned wrong status code: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+
+	rr = httptest.NewRecorder()
+	middleware.AddAccessRule(rr, httptest.NewRequest("POST", "/access-rules", strings.NewReader(`{"list": "allow", "match": "cidr", "value": "10.0.0.1"}`)))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("invalid rule returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+
+	rr = httptest.NewRecorder()
+	middleware.GetAccessRules(rr, httptest.NewRequest("GET", "/access-rules", nil))
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"value":"sk_revoked_"`) {
+		t.Errorf("list returned %v %s", rr.Code, rr.Body.String())
+	}
+}
@@ -0,0 +1,128 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// Admin roles. The write role can also read.
+const (
+	RoleRead  = "read"
+	RoleWrite = "write"
+)
+
+// JWT scopes granting the admin roles.
+const (
+	ScopeAdmin     = "admin"
+	ScopeAdminRead = "admin:read"
+)
+
+// AdminAuth authenticates the callers of the management endpoints, which are
+// separate from the clients being limited. A caller presents a bearer token in
+// the Authorization header: either one of the static admin tokens, or a JWT
+// signed with the admin secret whose scope claim holds admin, for the write
+// role, or admin:read, for the read role. JWTs must carry an exp claim.
+type AdminAuth struct {
+	tokens map[string]string
+	secret []byte
+}
+
+// NewAdminAuth returns an AdminAuth accepting the static tokens, mapped to
+// their role, and the JWTs signed with secret. JWTs are refused when secret
+// is empty.
+func NewAdminAuth(tokens map[string]string, secret []byte) *AdminAuth {
+	return &AdminAuth{tokens: tokens, secret: secret}
+}
+
+// ParseAdminTokens parses a comma separated list of token=role entries, where
+// role is read or write.
+func ParseAdminTokens(list string) (map[string]string, error) {
+	tokens := map[string]string{}
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		token, role, found := strings.Cut(entry, "=")
+		token, role = strings.TrimSpace(token), strings.TrimSpace(role)
+		if !found || token == "" {
+			return nil, fmt.Errorf("invalid admin token entry: want token=role")
+		}
+		if role != RoleRead && role != RoleWrite {
+			return nil, fmt.Errorf("invalid admin token role %q: want %s or %s", role, RoleRead, RoleWrite)
+		}
+		tokens[token] = role
+	}
+	return tokens, nil
+}
+
+// Require only lets the callers with the role through to next. Callers
+// without valid credentials get a 401 and callers with a role that is not
+// enough get a 403.
+func (a *AdminAuth) Require(role string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		callerRole, ok := a.role(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			writeErrorResponse(w, http.StatusUnauthorized, "Missing or invalid admin credentials")
+			return
+		}
+		if role == RoleWrite && callerRole != RoleWrite {
+			writeErrorResponse(w, http.StatusForbidden, "The admin credentials do not allow changes")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// role returns the role of the bearer token of the request.
+func (a *AdminAuth) role(r *http.Request) (string, bool) {
+	scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return "", false
+	}
+
+	for staticToken, role := range a.tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(staticToken)) == 1 {
+			return role, true
+		}
+	}
+	if len(a.secret) == 0 {
+		return "", false
+	}
+
+	claims := jwt.MapClaims{}
+	parsed, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return a.secret, nil
+	})
+	// MapClaims only checks exp when it is there, so a JWT without it would
+	// never expire.
+	if err != nil || !parsed.Valid || !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+		return "", false
+	}
+
+	scope, _ := claims["scope"].(string)
+	role := ""
+	for _, s := range strings.Fields(scope) {
+		switch s {
+		case ScopeAdmin:
+			return RoleWrite, true
+		case ScopeAdminRead:
+			role = RoleRead
+		}
+	}
+	return role, role != ""
+}
@@ -0,0 +1,84 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+func TestParseAdminTokens(t *testing.T) {
+	tokens, err := ParseAdminTokens("ops-token=write, dashboard-token=read")
+	if err != nil {
+		t.Fatalf("Failed to parse admin tokens: %v", err)
+	}
+	if len(tokens) != 2 || tokens["ops-token"] != RoleWrite || tokens["dashboard-token"] != RoleRead {
+		t.Errorf("admin tokens = %v", tokens)
+	}
+
+	for _, list := range []string{"ops-token", "ops-token=admin", "=write"} {
+		if _, err := ParseAdminTokens(list); err == nil {
+			t.Errorf("ParseAdminTokens(%q) returned no error", list)
+		}
+	}
+}
+
+func TestAdminAuth(t *testing.T) {
+	secret := []byte("admin-secret")
+	auth := NewAdminAuth(map[string]string{"ops-token": RoleWrite, "dashboard-token": RoleRead}, secret)
+	signed := func(secret []byte, claims jwt.MapClaims) string {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret)
+		if err != nil {
+			t.Fatalf("Failed to sign token: %v", err)
+		}
+		return token
+	}
+	expiresAt := time.Now().Add(time.Minute).Unix()
+
+	tests := []struct {
+		name          string
+		authorization string
+		readStatus    int
+		writeStatus   int
+	}{
+		{"no credentials", "", http.StatusUnauthorized, http.StatusUnauthorized},
+		{"unknown token", "Bearer guess", http.StatusUnauthorized, http.StatusUnauthorized},
+		{"write token", "Bearer ops-token", http.StatusOK, http.StatusOK},
+		{"read token", "Bearer dashboard-token", http.StatusOK, http.StatusForbidden},
+		{"token without the Bearer scheme", "ops-token", http.StatusUnauthorized, http.StatusUnauthorized},
+		{"admin scope", "Bearer " + signed(secret, jwt.MapClaims{"scope": "openid admin", "exp": expiresAt}), http.StatusOK, http.StatusOK},
+		{"admin:read scope", "Bearer " + signed(secret, jwt.MapClaims{"scope": "admin:read", "exp": expiresAt}), http.StatusOK, http.StatusForbidden},
+		{"no admin scope", "Bearer " + signed(secret, jwt.MapClaims{"exp": expiresAt}), http.StatusUnauthorized, http.StatusUnauthorized},
+		{"other secret", "Bearer " + signed([]byte("client-secret"), jwt.MapClaims{"scope": "admin"}), http.StatusUnauthorized, http.StatusUnauthorized},
+		{"no expiry", "Bearer " + signed(secret, jwt.MapClaims{"scope": "admin"}), http.StatusUnauthorized, http.StatusUnauthorized},
+		{"expired", "Bearer " + signed(secret, jwt.MapClaims{"scope": "admin", "exp": time.Now().Add(-time.Minute).Unix()}), http.StatusUnauthorized, http.StatusUnauthorized},
+	}
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	for _, test := range tests {
+		for role, want := range map[string]int{RoleRead: test.readStatus, RoleWrite: test.writeStatus} {
+			req := httptest.NewRequest("GET", "/get-all-rate-limiter", nil)
+			if test.authorization != "" {
+				req.Header.Set("Authorization", test.authorization)
+			}
+			rr := httptest.NewRecorder()
+			auth.Require(role, next).ServeHTTP(rr, req)
+			if rr.Code != want {
+				t.Errorf("%s with the %s role returned wrong status code: got %v want %v", test.name, role, rr.Code, want)
+			}
+		}
+	}
+
+	// Without a secret JWTs are refused.
+	req := httptest.NewRequest("GET", "/get-all-rate-limiter", nil)
+	req.Header.Set("Authorization", "Bearer "+signed(nil, jwt.MapClaims{"scope": "admin"}))
+	rr := httptest.NewRecorder()
+	NewAdminAuth(nil, nil).Require(RoleRead, next).ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("JWT without an admin secret returned wrong status code: got %v want %v", rr.Code, http.StatusUnauthorized)
+	}
+}
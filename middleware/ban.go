@@ -0,0 +1,124 @@
+package middleware
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"ratelimiter/pkg/ratelimiter"
+	"strconv"
+	"time"
+)
+
+// BanRequest is the body of a ban. Duration is in seconds, zero banning the
+// key for good.
+type BanRequest struct {
+	Reason   string `json:"reason" example:"scraping the catalog"`
+	Actor    string `json:"actor" example:"trust-and-safety@example.com"`
+	Duration int64  `json:"duration" example:"86400"`
+}
+
+// checkBan refuses the request with 403 when the key or the client IP is
+// banned, even when it is on the allow list. It reports whether it answered
+// the request.
+func (m *RateLimiterMiddleware) checkBan(w http.ResponseWriter, r *http.Request, key string) bool {
+	keys := []string{key}
+	if ip := parseHost(m.clientIP(r)); ip != nil && ip.String() != key {
+		keys = append(keys, ip.String())
+	}
+
+	for _, key := range keys {
+		ban, err := m.rateLimiter.GetBan(key)
+		if err == ratelimiter.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return true
+		}
+
+		rejection := Rejection{Status: http.StatusForbidden, Reason: ReasonBanned}
+		if ban.ExpiresAt != nil {
+			rejection.RetryAfter = time.Until(*ban.ExpiresAt)
+			w.Header().Set("Retry-After", strconv.FormatInt(seconds(rejection.RetryAfter), 10))
+		}
+		m.reject(w, r, rejection)
+		return true
+	}
+	return false
+}
+
+// BanKey godoc
+// @Summary Ban a key
+// @Description block a key or an IP by hand for a duration in seconds, or for good when it is zero, recording why and by whom, its requests are refused with 403 and the reason banned, and resets and unblocks leave it in place until it is revoked or expires
+// @Tags keys
+// @Accept  json
+// @Produce  json
+// @Param key path string true "Key or IP"
+// @Param body body BanRequest true "Ban"
+// @Success 201 {object} BlockInfo "Successfully banned the key"
+// @Failure 400 {object} ErrorResponse "Invalid ban"
+// @Failure 401 {object} ErrorResponse "Missing or invalid admin credentials"
+// @Failure 403 {object} ErrorResponse "Admin credentials without the write role"
+// @Failure 404 {object} ErrorResponse "Missing key"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /v1/keys/{key}/ban [post]
+// @Security AdminAuth
+func (m *RateLimiterMiddleware) BanKey(writer http.ResponseWriter, request *http.Request) {
+	key, ok := keyAction(request, "/ban")
+	if !ok {
+		http.Error(writer, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	var ban BanRequest
+	err := json.NewDecoder(request.Body).Decode(&ban)
+	if err != nil {
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	info, err := m.rateLimiter.Ban(key, time.Duration(ban.Duration)*time.Second, ban.Reason, ban.Actor)
+	if errors.Is(err, ratelimiter.ErrInvalidBan) {
+		writeErrorResponse(writer, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(writer).Encode(info)
+}
+
+// UnbanKey godoc
+// @Summary Revoke the ban of a key
+// @Description lift the ban of a key or an IP, a block set by a limit is left in place
+// @Tags keys
+// @Param key path string true "Key or IP"
+// @Success 204 "Successfully revoked the ban"
+// @Failure 401 {object} ErrorResponse "Missing or invalid admin credentials"
+// @Failure 403 {object} ErrorResponse "Admin credentials without the write role"
+// @Failure 404 {object} ErrorResponse "Key not banned"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /v1/keys/{key}/ban [delete]
+// @Security AdminAuth
+func (m *RateLimiterMiddleware) UnbanKey(writer http.ResponseWriter, request *http.Request) {
+	key, ok := keyAction(request, "/ban")
+	if !ok {
+		http.Error(writer, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	err := m.rateLimiter.Unban(key)
+	if err == ratelimiter.ErrNotFound {
+		writeErrorResponse(writer, http.StatusNotFound, "Key not banned")
+		return
+	}
+	if err != nil {
+		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	writer.WriteHeader(http.StatusNoContent)
+}
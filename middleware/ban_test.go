@@ -0,0 +1,82 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"ratelimiter/configs"
+	"ratelimiter/pkg/ratelimiter"
+	"strings"
+	"testing"
+)
+
+func TestBanKey(t *testing.T) {
+	configs.LoadConfig()
+	store := ratelimiter.NewMemoryStore()
+	defer store.Close()
+	limiter := ratelimiter.NewRateLimiter(store)
+	middleware := NewRateLimiterMiddleware(limiter, WithKeyExtractor(HeaderKey("X-Api-Key")))
+	handler := middleware.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	}))
+	request := func(apiKey string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/home", nil)
+		req.RemoteAddr = "192.0.2.4:1234"
+		req.Header.Set("X-Api-Key", apiKey)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+	call := func(handler http.HandlerFunc, method string, target string, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handler(rr, httptest.NewRequest(method, target, strings.NewReader(body)))
+		return rr
+	}
+
+	if rr := call(middleware.BanKey, "POST", "/v1/keys/client-1/ban", `{"reason": "abuse"}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("ban without an actor returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+
+	rr := call(middleware.BanKey, "POST", "/v1/keys/client-1/ban", `{"reason": "abuse", "actor": "alice", "duration": 3600}`)
+	var ban BlockInfo
+	_ = json.NewDecoder(rr.Body).Decode(&ban)
+	if rr.Code != http.StatusCreated || ban.Type != ratelimiter.BlockTypeBan || ban.Reason != "abuse" || ban.Actor != "alice" || ban.ExpiresAt == nil {
+		t.Errorf("ban returned %v %+v", rr.Code, ban)
+	}
+
+	// The ban wins over the allow list.
+	if _, err := limiter.AddAccessRule(ratelimiter.AccessRule{List: ratelimiter.AccessAllow, Match: ratelimiter.MatchKey, Value: "client-1"}); err != nil {
+		t.Fatalf("Failed to add the access rule: %v", err)
+	}
+	rr = request("client-1")
+	var response ErrorResponse
+	_ = json.NewDecoder(rr.Body).Decode(&response)
+	if rr.Code != http.StatusForbidden || response.Message != "Access suspended" {
+		t.Errorf("banned key returned %v %q", rr.Code, response.Message)
+	}
+	if retryAfter := rr.Header().Get("Retry-After"); retryAfter != "3600" && retryAfter != "3599" {
+		t.Errorf("banned key returned wrong Retry-After: got %q", retryAfter)
+	}
+
+	if rr := call(middleware.UnblockKey, "POST", "/v1/keys/client-1/unblock", ""); rr.Code != http.StatusConflict {
+		t.Errorf("unblock of a ban returned wrong status code: got %v want %v", rr.Code, http.StatusConflict)
+	}
+	if rr := call(middleware.UnbanKey, "DELETE", "/v1/keys/client-1/ban", ""); rr.Code != http.StatusNoContent {
+		t.Errorf("unban returned wrong status code: got %v want %v", rr.Code, http.StatusNoContent)
+	}
+	if rr := call(middleware.UnbanKey, "DELETE", "/v1/keys/client-1/ban", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("second unban returned wrong status code: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+	if rr := request("client-1"); rr.Code != http.StatusOK {
+		t.Errorf("unbanned key returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	// A permanent ban of the client IP refuses every key it sends.
+	if rr := call(middleware.BanKey, "POST", "/v1/keys/192.0.2.4/ban", `{"reason": "abuse", "actor": "alice"}`); rr.Code != http.StatusCreated {
+		t.Errorf("ban of an IP returned wrong status code: got %v want %v", rr.Code, http.StatusCreated)
+	}
+	rr = request("client-2")
+	if rr.Code != http.StatusForbidden || rr.Header().Get("Retry-After") != "" {
+		t.Errorf("banned IP returned %v with Retry-After %q", rr.Code, rr.Header().Get("Retry-After"))
+	}
+}
@@ -0,0 +1,255 @@
+package middleware
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultIPv4Prefix = 32
+	defaultIPv6Prefix = 64
+)
+
+// CIDRLimit gives every client in Network one shared quota of MaxRequests,
+// instead of a quota per client address.
+type CIDRLimit struct {
+	Network     *net.IPNet
+	MaxRequests int64
+}
+
+// ParseTrustedProxies parses a comma separated list of CIDRs or single IPs of
+// the proxies allowed to report the client address in forwarding headers.
+func ParseTrustedProxies(list string) ([]*net.IPNet, error) {
+	var proxies []*net.IPNet
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %v", entry, err)
+		}
+		proxies = append(proxies, network)
+	}
+	return proxies, nil
+}
+
+// WithTrustedProxies makes the middleware take the client address from the
+// Forwarded, X-Forwarded-For or X-Real-IP headers when the request comes from
+// one of the given networks. Without it those headers are ignored.
+func WithTrustedProxies(proxies []*net.IPNet) Option {
+	return func(m *RateLimiterMiddleware) {
+		m.trustedProxies = proxies
+	}
+}
+
+// ParseCIDRLimits parses a comma separated list of cidr=max_requests entries.
+func ParseCIDRLimits(list string) ([]CIDRLimit, error) {
+	var limits []CIDRLimit
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		cidr, value, found := strings.Cut(entry, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid CIDR limit %q: want cidr=max_requests", entry)
+		}
+		_, network, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR limit %q: %v", entry, err)
+		}
+		maxRequests, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if err != nil || maxRequests < 1 {
+			return nil, fmt.Errorf("invalid CIDR limit %q: max_requests must be a positive integer", entry)
+		}
+		limits = append(limits, CIDRLimit{Network: network, MaxRequests: maxRequests})
+	}
+	return limits, nil
+}
+
+// WithIPPrefixes sets how many leading bits of a client address make up its
+// key, so that every address in the same prefix shares one quota. IPv6 uses
+// /64 by default, since a single client usually owns a whole /64, and IPv4 the
+// full address. A value out of range keeps the default.
+func WithIPPrefixes(ipv4Bits int, ipv6Bits int) Option {
+	return func(m *RateLimiterMiddleware) {
+		if ipv4Bits > 0 && ipv4Bits <= 8*net.IPv4len {
+			m.ipv4Prefix = ipv4Bits
+		}
+		if ipv6Bits > 0 && ipv6Bits <= 8*net.IPv6len {
+			m.ipv6Prefix = ipv6Bits
+		}
+	}
+}
+
+// WithCIDRLimits makes the clients of each network share one quota, keyed by
+// the network, with its own default number of requests. When networks overlap
+// the most specific one applies.
+func WithCIDRLimits(limits []CIDRLimit) Option {
+	return func(m *RateLimiterMiddleware) {
+		m.cidrLimits = append([]CIDRLimit(nil), limits...)
+		sort.SliceStable(m.cidrLimits, func(i, j int) bool {
+			a, _ := m.cidrLimits[i].Network.Mask.Size()
+			b, _ := m.cidrLimits[j].Network.Mask.Size()
+			return a > b
+		})
+	}
+}
+
+// ipKey turns a client address into its rate limiter key: the network of its
+// CIDR limit if it has one, otherwise the address masked to the configured
+// prefix. A full length prefix keeps the plain address.
+func (m *RateLimiterMiddleware) ipKey(address string) string {
+	ip := parseHost(address)
+	if ip == nil {
+		return address
+	}
+
+	for _, limit := range m.cidrLimits {
+		if limit.Network.Contains(ip) {
+			return limit.Network.String()
+		}
+	}
+
+	bits, prefix := 8*net.IPv6len, m.ipv6Prefix
+	if len(ip) == net.IPv4len {
+		bits, prefix = 8*net.IPv4len, m.ipv4Prefix
+	}
+	if prefix == 0 || prefix >= bits {
+		return ip.String()
+	}
+	mask := net.CIDRMask(prefix, bits)
+	return (&net.IPNet{IP: ip.Mask(mask), Mask: mask}).String()
+}
+
+// cidrMaxRequests returns the default number of requests of a key that is the
+// network of a CIDR limit.
+func (m *RateLimiterMiddleware) cidrMaxRequests(key string) (int64, bool) {
+	for _, limit := range m.cidrLimits {
+		if limit.Network.String() == key {
+			return limit.MaxRequests, true
+		}
+	}
+	return 0, false
+}
+
+// clientIP returns the address of the client that made the request, without
+// its port. Forwarding headers are only believed when the peer is a trusted
+// proxy, and then the hops are walked from right to left, skipping trusted
+// proxies, so the first untrusted hop wins. Anything to the left of it was
+// written by the client and may be spoofed.
+func (m *RateLimiterMiddleware) clientIP(r *http.Request) string {
+	remote := parseHost(r.RemoteAddr)
+	if remote == nil {
+		return r.RemoteAddr
+	}
+	if !m.isTrustedProxy(remote) {
+		return remote.String()
+	}
+
+	hops := forwardedFor(r.Header)
+	if len(hops) == 0 {
+		hops = splitList(r.Header.Values("X-Forwarded-For"))
+	}
+	if len(hops) == 0 {
+		if ip := parseHost(r.Header.Get("X-Real-IP")); ip != nil {
+			return ip.String()
+		}
+		return remote.String()
+	}
+
+	client := remote
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := parseHost(hops[i])
+		if ip == nil {
+			// An obfuscated or garbled hop: the proxy that wrote it is the
+			// closest address we can vouch for.
+			break
+		}
+		client = ip
+		if !m.isTrustedProxy(ip) {
+			break
+		}
+	}
+	return client.String()
+}
+
+func (m *RateLimiterMiddleware) isTrustedProxy(ip net.IP) bool {
+	for _, network := range m.trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedFor returns the for= parameters of the RFC 7239 Forwarded headers,
+// from the first hop to the last.
+func forwardedFor(header http.Header) []string {
+	var hops []string
+	for _, element := range splitList(header.Values("Forwarded")) {
+		for _, pair := range strings.Split(element, ";") {
+			name, value, found := strings.Cut(strings.TrimSpace(pair), "=")
+			if found && strings.EqualFold(name, "for") {
+				hops = append(hops, strings.Trim(value, `"`))
+			}
+		}
+	}
+	return hops
+}
+
+// splitList splits comma separated header values into their trimmed items.
+func splitList(values []string) []string {
+	var items []string
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+	}
+	return items
+}
+
+// parseHost parses an address that may carry a port, with IPv6 addresses in
+// brackets when it does. It returns nil when there is no valid IP in it.
+func parseHost(address string) net.IP {
+	host := strings.TrimSpace(address)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	if i := strings.IndexByte(host, '%'); i >= 0 {
+		host = host[:i]
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4
+	}
+	return ip
+}
@@ -0,0 +1,242 @@
+package middleware
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseTrustedProxies(t *testing.T) {
+	proxies, err := ParseTrustedProxies("10.0.0.0/8, 192.0.2.10,2001:db8::/32,")
+	if err != nil {
+		t.Fatalf("Failed to parse trusted proxies: %v", err)
+	}
+	if len(proxies) != 3 {
+		t.Fatalf("got %d trusted proxies, want 3", len(proxies))
+	}
+	if got := proxies[1].String(); got != "192.0.2.10/32" {
+		t.Errorf("single IP parsed as %s, want 192.0.2.10/32", got)
+	}
+
+	for _, list := range []string{"10.0.0.0/33", "not-an-ip"} {
+		if _, err := ParseTrustedProxies(list); err == nil {
+			t.Errorf("ParseTrustedProxies(%q) returned no error", list)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	proxies, err := ParseTrustedProxies("10.0.0.0/8,2001:db8::/32")
+	if err != nil {
+		t.Fatalf("Failed to parse trusted proxies: %v", err)
+	}
+	middleware := &RateLimiterMiddleware{trustedProxies: proxies}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string][]string
+		want       string
+	}{
+		{
+			name:       "strips the port of a direct client",
+			remoteAddr: "198.51.100.7:52341",
+			want:       "198.51.100.7",
+		},
+		{
+			name:       "strips the port of a direct IPv6 client",
+			remoteAddr: "[2001:db9::1]:52341",
+			want:       "2001:db9::1",
+		},
+		{
+			name:       "ignores X-Forwarded-For from an untrusted peer",
+			remoteAddr: "198.51.100.7:52341",
+			headers:    map[string][]string{"X-Forwarded-For": {"203.0.113.1"}},
+			want:       "198.51.100.7",
+		},
+		{
+			name:       "ignores X-Real-IP from an untrusted peer",
+			remoteAddr: "198.51.100.7:52341",
+			headers:    map[string][]string{"X-Real-Ip": {"203.0.113.1"}},
+			want:       "198.51.100.7",
+		},
+		{
+			name:       "ignores Forwarded from an untrusted peer",
+			remoteAddr: "198.51.100.7:52341",
+			headers:    map[string][]string{"Forwarded": {"for=203.0.113.1"}},
+			want:       "198.51.100.7",
+		},
+		{
+			name:       "takes the client from a trusted proxy",
+			remoteAddr: "10.0.0.1:443",
+			headers:    map[string][]string{"X-Forwarded-For": {"203.0.113.1"}},
+			want:       "203.0.113.1",
+		},
+		{
+			name:       "skips every trusted hop",
+			remoteAddr: "10.0.0.1:443",
+			headers:    map[string][]string{"X-Forwarded-For": {"203.0.113.1, 10.0.0.3, 10.0.0.2"}},
+			want:       "203.0.113.1",
+		},
+		{
+			name:       "ignores addresses the client prepended",
+			remoteAddr: "10.0.0.1:443",
+			headers:    map[string][]string{"X-Forwarded-For": {"1.1.1.1, 10.0.0.9, 203.0.113.1"}},
+			want:       "203.0.113.1",
+		},
+		{
+			name:       "reads every X-Forwarded-For line",
+			remoteAddr: "10.0.0.1:443",
+			headers:    map[string][]string{"X-Forwarded-For": {"1.1.1.1", "203.0.113.1, 10.0.0.2"}},
+			want:       "203.0.113.1",
+		},
+		{
+			name:       "stops at a garbled hop",
+			remoteAddr: "10.0.0.1:443",
+			headers:    map[string][]string{"X-Forwarded-For": {"1.1.1.1, not-an-ip, 10.0.0.2"}},
+			want:       "10.0.0.2",
+		},
+		{
+			name:       "uses the leftmost hop when every hop is trusted",
+			remoteAddr: "10.0.0.1:443",
+			headers:    map[string][]string{"X-Forwarded-For": {"10.0.0.3, 10.0.0.2"}},
+			want:       "10.0.0.3",
+		},
+		{
+			name:       "strips the port of a forwarded hop",
+			remoteAddr: "10.0.0.1:443",
+			headers:    map[string][]string{"X-Forwarded-For": {"203.0.113.1:61000"}},
+			want:       "203.0.113.1",
+		},
+		{
+			name:       "falls back to X-Real-IP",
+			remoteAddr: "10.0.0.1:443",
+			headers:    map[string][]string{"X-Real-Ip": {"203.0.113.1"}},
+			want:       "203.0.113.1",
+		},
+		{
+			name:       "prefers Forwarded over X-Forwarded-For",
+			remoteAddr: "10.0.0.1:443",
+			headers: map[string][]string{
+				"Forwarded":       {`for=1.1.1.1, for="[2001:db9::7]:4711";proto=https, for=10.0.0.2`},
+				"X-Forwarded-For": {"203.0.113.1"},
+			},
+			want: "2001:db9::7",
+		},
+		{
+			name:       "stops at an obfuscated Forwarded hop",
+			remoteAddr: "[2001:db8::1]:443",
+			headers:    map[string][]string{"Forwarded": {"for=203.0.113.1, for=_hidden;by=10.0.0.2"}},
+			want:       "2001:db8::1",
+		},
+		{
+			name:       "falls back to the proxy without headers",
+			remoteAddr: "10.0.0.1:443",
+			want:       "10.0.0.1",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/home", nil)
+			req.RemoteAddr = test.remoteAddr
+			for name, values := range test.headers {
+				for _, value := range values {
+					req.Header.Add(name, value)
+				}
+			}
+
+			if got := middleware.clientIP(req); got != test.want {
+				t.Errorf("clientIP() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseCIDRLimits(t *testing.T) {
+	limits, err := ParseCIDRLimits("203.0.113.0/24=100, 2001:db8::/48=500")
+	if err != nil {
+		t.Fatalf("Failed to parse CIDR limits: %v", err)
+	}
+	if len(limits) != 2 || limits[0].Network.String() != "203.0.113.0/24" || limits[0].MaxRequests != 100 {
+		t.Errorf("got CIDR limits %+v", limits)
+	}
+
+	for _, list := range []string{"203.0.113.0/24", "203.0.113.0/24=0", "203.0.113.0/24=many", "203.0.113.1=10"} {
+		if _, err := ParseCIDRLimits(list); err == nil {
+			t.Errorf("ParseCIDRLimits(%q) returned no error", list)
+		}
+	}
+}
+
+func TestIPKey(t *testing.T) {
+	limits, err := ParseCIDRLimits("203.0.113.0/24=100,203.0.113.128/25=50")
+	if err != nil {
+		t.Fatalf("Failed to parse CIDR limits: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		options []Option
+		address string
+		want    string
+	}{
+		{
+			name:    "keeps a full IPv4 address by default",
+			address: "198.51.100.7",
+			want:    "198.51.100.7",
+		},
+		{
+			name:    "masks IPv6 to its /64 by default",
+			address: "2001:db8:1:2:a:b:c:d",
+			want:    "2001:db8:1:2::/64",
+		},
+		{
+			name:    "masks IPv4 to a configured /24",
+			options: []Option{WithIPPrefixes(24, 0)},
+			address: "198.51.100.7",
+			want:    "198.51.100.0/24",
+		},
+		{
+			name:    "keeps a full IPv6 address when asked to",
+			options: []Option{WithIPPrefixes(0, 128)},
+			address: "2001:db8:1:2:a:b:c:d",
+			want:    "2001:db8:1:2:a:b:c:d",
+		},
+		{
+			name:    "ignores an out of range prefix",
+			options: []Option{WithIPPrefixes(40, 200)},
+			address: "2001:db8:1:2:a:b:c:d",
+			want:    "2001:db8:1:2::/64",
+		},
+		{
+			name:    "uses the network of a CIDR limit",
+			options: []Option{WithCIDRLimits(limits)},
+			address: "203.0.113.7",
+			want:    "203.0.113.0/24",
+		},
+		{
+			name:    "prefers the most specific CIDR limit",
+			options: []Option{WithCIDRLimits(limits)},
+			address: "203.0.113.200",
+			want:    "203.0.113.128/25",
+		},
+		{
+			name:    "leaves an address that is not an IP alone",
+			address: "pipe",
+			want:    "pipe",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			middleware := &RateLimiterMiddleware{ipv4Prefix: defaultIPv4Prefix, ipv6Prefix: defaultIPv6Prefix}
+			for _, opt := range test.options {
+				opt(middleware)
+			}
+
+			if got := middleware.ipKey(test.address); got != test.want {
+				t.Errorf("ipKey(%q) = %q, want %q", test.address, got, test.want)
+			}
+		})
+	}
+}
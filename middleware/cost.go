@@ -0,0 +1,88 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// CostFunc returns how many units of the quota a request consumes. A result
+// below one means it has no opinion, and the route weight, or else one, is used.
+type CostFunc func(r *http.Request) int64
+
+// WithCostFunc makes the middleware charge each request the cost f returns
+// instead of one unit.
+func WithCostFunc(f CostFunc) Option {
+	return func(m *RateLimiterMiddleware) {
+		m.costFunc = f
+	}
+}
+
+// HeaderCost takes the cost from a request header holding a positive integer.
+// Only use it with a header set by a trusted gateway, as clients could
+// otherwise understate their cost.
+func HeaderCost(name string) CostFunc {
+	return func(r *http.Request) int64 {
+		cost, err := strconv.ParseInt(r.Header.Get(name), 10, 64)
+		if err != nil {
+			return 0
+		}
+		return cost
+	}
+}
+
+// JSONItemsCost charges one unit per item of a JSON array in the request body:
+// the body itself when field is empty, or else the named field of the body
+// object. Bodies over maxBody bytes are not inspected. The body is left intact
+// for the next handler.
+func JSONItemsCost(field string, maxBody int64) CostFunc {
+	return func(r *http.Request) int64 {
+		if r.Body == nil {
+			return 0
+		}
+
+		data, err := io.ReadAll(io.LimitReader(r.Body, maxBody+1))
+		r.Body = readCloser{io.MultiReader(bytes.NewReader(data), r.Body), r.Body}
+		if err != nil || int64(len(data)) > maxBody {
+			return 0
+		}
+
+		var items []json.RawMessage
+		if field == "" {
+			err = json.Unmarshal(data, &items)
+		} else {
+			var object map[string]json.RawMessage
+			err = json.Unmarshal(data, &object)
+			if err == nil {
+				err = json.Unmarshal(object[field], &items)
+			}
+		}
+		if err != nil {
+			return 0
+		}
+		return int64(len(items))
+	}
+}
+
+// readCloser reads the buffered start of a body before the rest of it, and
+// closes the original body.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// requestCost returns the cost of the request: the one the cost function
+// reports, else the weight of its route policy, else one.
+func (m *RateLimiterMiddleware) requestCost(r *http.Request) int64 {
+	if m.costFunc != nil {
+		if cost := m.costFunc(r); cost > 0 {
+			return cost
+		}
+	}
+	if policy, ok := m.routePolicy(r); ok && policy.Cost > 0 {
+		return policy.Cost
+	}
+	return 1
+}
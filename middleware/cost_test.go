@@ -0,0 +1,80 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"ratelimiter/configs"
+	"ratelimiter/pkg/ratelimiter"
+	"strings"
+	"testing"
+)
+
+func TestCostFuncs(t *testing.T) {
+	tests := []struct {
+		name string
+		cost CostFunc
+		body string
+		want int64
+	}{
+		{"header", HeaderCost("X-Cost"), "", 4},
+		{"missing header", HeaderCost("X-Other"), "", 0},
+		{"array body", JSONItemsCost("", 1024), `[1, 2, 3]`, 3},
+		{"array field", JSONItemsCost("items", 1024), `{"items": [{"id": 1}, {"id": 2}]}`, 2},
+		{"missing field", JSONItemsCost("items", 1024), `{"other": []}`, 0},
+		{"invalid json", JSONItemsCost("", 1024), `[1, 2`, 0},
+		{"body over the limit", JSONItemsCost("", 4), `[1, 2, 3]`, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/orders", strings.NewReader(test.body))
+			req.Header.Set("X-Cost", "4")
+
+			if got := test.cost(req); got != test.want {
+				t.Errorf("cost = %d, want %d", got, test.want)
+			}
+			body, err := io.ReadAll(req.Body)
+			if err != nil || string(body) != test.body {
+				t.Errorf("body after cost = %q, %v, want %q", body, err, test.body)
+			}
+		})
+	}
+}
+
+func TestRequestCost(t *testing.T) {
+	configs.LoadConfig()
+	store := ratelimiter.NewMemoryStore()
+	defer store.Close()
+	middleware := NewRateLimiterMiddleware(ratelimiter.NewRateLimiter(store),
+		WithRoutePolicies(RoutePolicy{Method: "POST", Pattern: "/orders/bulk", Limit: LimitData{Seconds: 60, MaxRequests: 5}, Cost: 3}),
+		WithCostFunc(HeaderCost("X-Cost")),
+	)
+
+	handler := middleware.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	}))
+
+	requests := []struct {
+		cost string
+		want int
+	}{
+		{"", http.StatusOK},
+		// 3 more units do not fit in the 2 left and must not consume them.
+		{"", http.StatusTooManyRequests},
+		{"2", http.StatusOK},
+		{"1", http.StatusTooManyRequests},
+	}
+	for i, request := range requests {
+		req := httptest.NewRequest("POST", "/orders/bulk", nil)
+		req.RemoteAddr = "192.0.2.5:1234"
+		if request.cost != "" {
+			req.Header.Set("X-Cost", request.cost)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if status := rr.Code; status != request.want {
+			t.Errorf("request %d returned wrong status code: got %v want %v", i, status, request.want)
+		}
+	}
+}
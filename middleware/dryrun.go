@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"expvar"
+	"log"
+	"net/http"
+)
+
+// DryRunHeader is set on the responses a dry-run limit would have refused.
+const DryRunHeader = "RateLimit-Dry-Run"
+
+// dryRunLimited counts the requests let through because their limit is in
+// dry-run mode. It is published on /debug/vars.
+var dryRunLimited = expvar.NewInt("ratelimiter_dry_run_limited")
+
+// WithDryRun puts every limit in dry-run mode: requests are still counted and
+// evaluated, but the ones that would have been limited are let through and
+// recorded instead. Single limits can be put in dry-run mode with the DryRun
+// field of their limit data.
+func WithDryRun() Option {
+	return func(m *RateLimiterMiddleware) {
+		m.dryRun = true
+	}
+}
+
+// isDryRun reports whether requests over the limit must be let through.
+func (m *RateLimiterMiddleware) isDryRun(limitData LimitData) bool {
+	return m.dryRun || limitData.DryRun
+}
+
+// recordDryRun records a request a dry-run limit would have refused for the
+// given reason, and marks its response with DryRunHeader.
+func (m *RateLimiterMiddleware) recordDryRun(w http.ResponseWriter, r *http.Request, limitData LimitData, reason string) {
+	dryRunLimited.Add(1)
+	w.Header().Set(DryRunHeader, "would-limit")
+	log.Printf("Dry run: would have limited %s %s for key %s (%s)", r.Method, r.URL.Path, limitData.Key, reason)
+}
@@ -0,0 +1,105 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"ratelimiter/configs"
+	"ratelimiter/pkg/ratelimiter"
+	"testing"
+)
+
+func TestDryRun(t *testing.T) {
+	configs.LoadConfig()
+	serve := func(handler http.Handler, target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", target, nil)
+		req.RemoteAddr = "192.0.2.6:1234"
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+	newHandler := func(rateLimiter *ratelimiter.RateLimiter, opts ...Option) http.Handler {
+		middleware := NewRateLimiterMiddleware(rateLimiter, opts...)
+		return middleware.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("OK"))
+		}))
+	}
+
+	t.Run("global", func(t *testing.T) {
+		store := ratelimiter.NewMemoryStore()
+		defer store.Close()
+		handler := newHandler(ratelimiter.NewRateLimiter(store),
+			WithDryRun(),
+			WithRoutePolicies(RoutePolicy{Pattern: "/orders", Limit: LimitData{Seconds: 60, MaxRequests: 1}}),
+		)
+
+		before := dryRunLimited.Value()
+		rr := serve(handler, "/orders")
+		if rr.Code != http.StatusOK || rr.Header().Get(DryRunHeader) != "" {
+			t.Errorf("first request returned %v with %s %q", rr.Code, DryRunHeader, rr.Header().Get(DryRunHeader))
+		}
+		rr = serve(handler, "/orders")
+		if rr.Code != http.StatusOK || rr.Header().Get(DryRunHeader) != "would-limit" {
+			t.Errorf("request over the limit returned %v with %s %q", rr.Code, DryRunHeader, rr.Header().Get(DryRunHeader))
+		}
+		if rr.Header().Get("Retry-After") != "" {
+			t.Errorf("dry run response has Retry-After %q", rr.Header().Get("Retry-After"))
+		}
+		if got := dryRunLimited.Value() - before; got != 1 {
+			t.Errorf("dry run counter went up by %d, want 1", got)
+		}
+	})
+
+	t.Run("per limit data", func(t *testing.T) {
+		store := ratelimiter.NewMemoryStore()
+		defer store.Close()
+		rateLimiter := ratelimiter.NewRateLimiter(store)
+		err := rateLimiter.SetLimitData("192.0.2.6", LimitData{Key: "192.0.2.6", Seconds: 60, MaxRequests: 1, DryRun: true})
+		if err != nil {
+			t.Fatalf("Failed to set limit data: %v", err)
+		}
+		handler := newHandler(rateLimiter,
+			WithRoutePolicies(RoutePolicy{Pattern: "/orders", Limit: LimitData{Seconds: 60, MaxRequests: 1}}),
+		)
+
+		for i := 0; i < 3; i++ {
+			if rr := serve(handler, "/home"); rr.Code != http.StatusOK {
+				t.Errorf("dry run request %d returned wrong status code: got %v want %v", i, rr.Code, http.StatusOK)
+			}
+		}
+		serve(handler, "/orders")
+		if rr := serve(handler, "/orders"); rr.Code != http.StatusTooManyRequests || rr.Header().Get(DryRunHeader) != "" {
+			t.Errorf("enforced limit returned %v with %s %q", rr.Code, DryRunHeader, rr.Header().Get(DryRunHeader))
+		}
+	})
+}
+
+func TestDryRunDoesNotBlock(t *testing.T) {
+	configs.LoadConfig()
+	store := ratelimiter.NewMemoryStore()
+	defer store.Close()
+	rateLimiter := ratelimiter.NewRateLimiter(store)
+	policy := RoutePolicy{Pattern: "/orders", Limit: LimitData{Seconds: 60, MaxRequests: 1, BlockDuration: 60}}
+	serve := func(handler http.Handler) int {
+		req := httptest.NewRequest("GET", "/orders", nil)
+		req.RemoteAddr = "192.0.2.7:1234"
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	dryRun := NewRateLimiterMiddleware(rateLimiter, WithDryRun(), WithRoutePolicies(policy)).Middleware(next)
+	for i := 0; i < 3; i++ {
+		if code := serve(dryRun); code != http.StatusOK {
+			t.Errorf("dry run request %d returned wrong status code: got %v want %v", i, code, http.StatusOK)
+		}
+	}
+
+	page, err := rateLimiter.ListBlocked("", ratelimiter.DefaultPageSize)
+	if err != nil {
+		t.Fatalf("Failed to list blocked keys: %v", err)
+	}
+	if len(page.Items) != 0 {
+		t.Errorf("dry run blocked %d keys: %+v", len(page.Items), page.Items)
+	}
+}
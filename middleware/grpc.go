@@ -0,0 +1,202 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// UnaryServerInterceptor limits gRPC unary calls with the same key
+// extractor, route policies, access lists and limits as the HTTP middleware.
+// A call is evaluated as a POST to its full method name, such as
+// /package.Service/Method, with its metadata as the request headers and its
+// peer as the remote address, so route policies can match services and the
+// default key is the api_key metadata or else the peer IP. The quota is sent
+// in the response headers, and refused calls fail with ResourceExhausted or
+// PermissionDenied with the quota and a RetryInfo in the trailers.
+func (m *RateLimiterMiddleware) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		var resp interface{}
+		err := m.interceptCall(ctx, info.FullMethod,
+			func(md metadata.MD) { _ = grpc.SetHeader(ctx, md) },
+			func(md metadata.MD) { grpc.SetTrailer(ctx, md) },
+			func() error {
+				var err error
+				resp, err = handler(ctx, req)
+				return err
+			},
+		)
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor limits gRPC streams like UnaryServerInterceptor
+// limits unary calls. Each stream counts as one request when it is opened.
+func (m *RateLimiterMiddleware) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return m.interceptCall(ss.Context(), info.FullMethod,
+			func(md metadata.MD) { _ = ss.SetHeader(md) },
+			ss.SetTrailer,
+			func() error {
+				return handler(srv, ss)
+			},
+		)
+	}
+}
+
+// interceptCall runs the call through the middleware, sending the quota
+// headers it sets with setHeader when the call is allowed and with
+// setTrailer when it is refused. A panic of the middleware fails the call
+// with Internal instead of taking the server down; a panic of the handler is
+// left to the server.
+func (m *RateLimiterMiddleware) interceptCall(ctx context.Context, fullMethod string, setHeader func(metadata.MD), setTrailer func(metadata.MD), call func() error) (err error) {
+	r, err := grpcRequest(ctx, fullMethod)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+
+	response := &grpcResponse{header: http.Header{}}
+	called := false
+	var callErr error
+	defer func() {
+		if p := recover(); p != nil {
+			if called {
+				panic(p)
+			}
+			log.Printf("Rate limiter panicked on %s: %v", fullMethod, p)
+			err = status.Error(codes.Internal, http.StatusText(http.StatusInternalServerError))
+		}
+	}()
+	m.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		if md := headerMetadata(response.header); len(md) > 0 {
+			setHeader(md)
+		}
+		callErr = call()
+	})).ServeHTTP(response, r)
+	if called {
+		return callErr
+	}
+
+	if md := headerMetadata(response.header); len(md) > 0 {
+		setTrailer(md)
+	}
+	return response.err(ctx)
+}
+
+// grpcRequest builds the HTTP request a gRPC call is evaluated as.
+func grpcRequest(ctx context.Context, fullMethod string) (*http.Request, error) {
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, fullMethod, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	for key, values := range md {
+		if strings.HasPrefix(key, ":") || strings.HasSuffix(key, "-bin") {
+			continue
+		}
+		for _, value := range values {
+			r.Header.Add(key, value)
+		}
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		r.RemoteAddr = p.Addr.String()
+	}
+	return r, nil
+}
+
+// headerMetadata turns the headers the middleware set into gRPC metadata,
+// whose keys are lowercase.
+func headerMetadata(header http.Header) metadata.MD {
+	md := metadata.MD{}
+	for key, values := range header {
+		key = strings.ToLower(key)
+		if key == "content-type" || key == "content-language" {
+			continue
+		}
+		md.Append(key, values...)
+	}
+	return md
+}
+
+// grpcResponse records what the middleware writes for a gRPC call, so it can
+// be turned into a status.
+type grpcResponse struct {
+	header    http.Header
+	status    int
+	body      bytes.Buffer
+	rejection *Rejection
+	message   string
+}
+
+func (w *grpcResponse) Header() http.Header {
+	return w.header
+}
+
+func (w *grpcResponse) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *grpcResponse) Write(b []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.body.Write(b)
+}
+
+// reject records the rejection of the call instead of writing it.
+func (w *grpcResponse) reject(rejection Rejection, message string) {
+	w.rejection = &rejection
+	w.message = message
+	w.WriteHeader(rejection.Status)
+}
+
+// err returns the status of a call the middleware did not let through.
+func (w *grpcResponse) err(ctx context.Context) error {
+	if w.rejection == nil {
+		if ctx.Err() != nil {
+			return status.FromContextError(ctx.Err()).Err()
+		}
+		code := codes.Internal
+		if w.status == http.StatusServiceUnavailable {
+			code = codes.Unavailable
+		}
+		return status.Error(code, strings.TrimSpace(w.body.String()))
+	}
+
+	code := codes.ResourceExhausted
+	switch w.rejection.Reason {
+	case ReasonAccessDenied, ReasonBanned:
+		code = codes.PermissionDenied
+	}
+	st := status.New(code, w.message)
+	if w.rejection.RetryAfter > 0 {
+		retryAfter := time.Duration(seconds(w.rejection.RetryAfter)) * time.Second
+		if detailed, err := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(retryAfter)}); err == nil {
+			st = detailed
+		}
+	}
+	return st.Err()
+}
+
+// rejectionMessage returns the message the rejection handler would write for
+// the rejection, or the status text when it is not a RejectionResponder.
+func (m *RateLimiterMiddleware) rejectionMessage(r *http.Request, rejection Rejection) string {
+	responder, ok := m.rejectionHandler.(*RejectionResponder)
+	if !ok {
+		return http.StatusText(rejection.Status)
+	}
+	return responder.message(responder.negotiateLanguage(r.Header.Get("Accept-Language")), rejection)
+}
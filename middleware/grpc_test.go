@@ -0,0 +1,148 @@
+package middleware
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"ratelimiter/configs"
+	"ratelimiter/pkg/ratelimiter"
+	"testing"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func newGRPCClient(t *testing.T, middleware *RateLimiterMiddleware) healthpb.HealthClient {
+	listener := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer(
+		grpc.UnaryInterceptor(middleware.UnaryServerInterceptor()),
+		grpc.StreamInterceptor(middleware.StreamServerInterceptor()),
+	)
+	healthpb.RegisterHealthServer(server, health.NewServer())
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("Failed to dial the bufconn server: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return healthpb.NewHealthClient(conn)
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	configs.LoadConfig()
+	store := ratelimiter.NewMemoryStore()
+	defer store.Close()
+	middleware := NewRateLimiterMiddleware(ratelimiter.NewRateLimiter(store),
+		WithRoutePolicies(RoutePolicy{Pattern: "/grpc.health.v1.Health/*", Limit: LimitData{Seconds: 60, MaxRequests: 2, BlockDuration: 30}}),
+	)
+	client := newGRPCClient(t, middleware)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "api_key", "grpc-client")
+
+	for i := 0; i < 2; i++ {
+		var header metadata.MD
+		_, err := client.Check(ctx, &healthpb.HealthCheckRequest{}, grpc.Header(&header))
+		if err != nil {
+			t.Fatalf("call %d failed: %v", i, err)
+		}
+		if got := header.Get("ratelimit-limit"); len(got) != 1 || got[0] != "2" {
+			t.Errorf("call %d ratelimit-limit header = %v, want [2]", i, got)
+		}
+	}
+
+	var trailer metadata.MD
+	_, err := client.Check(ctx, &healthpb.HealthCheckRequest{}, grpc.Trailer(&trailer))
+	st := status.Convert(err)
+	if st.Code() != codes.ResourceExhausted {
+		t.Fatalf("limited call returned %v, want %v", st.Code(), codes.ResourceExhausted)
+	}
+	if got := trailer.Get("retry-after"); len(got) != 1 || got[0] != "30" {
+		t.Errorf("retry-after trailer = %v, want [30]", got)
+	}
+	if got := trailer.Get("ratelimit-remaining"); len(got) != 1 || got[0] != "0" {
+		t.Errorf("ratelimit-remaining trailer = %v, want [0]", got)
+	}
+	var retryInfo *errdetails.RetryInfo
+	for _, detail := range st.Details() {
+		if info, ok := detail.(*errdetails.RetryInfo); ok {
+			retryInfo = info
+		}
+	}
+	if retryInfo == nil || retryInfo.RetryDelay.AsDuration().Seconds() != 30 {
+		t.Errorf("retry info = %v, want a 30s delay", retryInfo)
+	}
+
+	// Another api key has its own quota.
+	other := metadata.AppendToOutgoingContext(context.Background(), "api_key", "other-client")
+	if _, err := client.Check(other, &healthpb.HealthCheckRequest{}); err != nil {
+		t.Errorf("call with another key failed: %v", err)
+	}
+}
+
+func TestStreamServerInterceptor(t *testing.T) {
+	configs.LoadConfig()
+	store := ratelimiter.NewMemoryStore()
+	defer store.Close()
+	rateLimiter := ratelimiter.NewRateLimiter(store)
+	middleware := NewRateLimiterMiddleware(rateLimiter,
+		WithRoutePolicies(RoutePolicy{Pattern: "/grpc.health.v1.Health/Watch", Limit: LimitData{Seconds: 60, MaxRequests: 1}}),
+	)
+	client := newGRPCClient(t, middleware)
+	ctx, cancel := context.WithCancel(metadata.AppendToOutgoingContext(context.Background(), "api_key", "grpc-client"))
+	defer cancel()
+
+	stream, err := client.Watch(ctx, &healthpb.HealthCheckRequest{})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	if err != nil {
+		t.Fatalf("first stream failed: %v", err)
+	}
+
+	stream, err = client.Watch(ctx, &healthpb.HealthCheckRequest{})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("limited stream returned %v, want %v", status.Code(err), codes.ResourceExhausted)
+	}
+
+	if _, err := rateLimiter.AddAccessRule(ratelimiter.AccessRule{List: ratelimiter.AccessDeny, Match: ratelimiter.MatchKey, Value: "blocked-client"}); err != nil {
+		t.Fatalf("Failed to add the access rule: %v", err)
+	}
+	denied := metadata.AppendToOutgoingContext(context.Background(), "api_key", "blocked-client")
+	if _, err := client.Check(denied, &healthpb.HealthCheckRequest{}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("denied call returned %v, want %v", status.Code(err), codes.PermissionDenied)
+	}
+}
+
+func TestInterceptorRecoversFromPanics(t *testing.T) {
+	configs.LoadConfig()
+	store := ratelimiter.NewMemoryStore()
+	defer store.Close()
+	middleware := NewRateLimiterMiddleware(ratelimiter.NewRateLimiter(store),
+		WithKeyExtractor(KeyFunc(func(r *http.Request) (string, bool) {
+			panic("broken extractor")
+		})),
+	)
+	client := newGRPCClient(t, middleware)
+
+	for i := 0; i < 2; i++ {
+		if _, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{}); status.Code(err) != codes.Internal {
+			t.Errorf("call %d returned %v, want %v", i, status.Code(err), codes.Internal)
+		}
+	}
+}
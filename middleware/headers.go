@@ -0,0 +1,39 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"ratelimiter/pkg/ratelimiter"
+	"strconv"
+	"time"
+)
+
+// HeadersLegacy selects the X-RateLimit-* header names many clients still read
+// instead of the RateLimit-* fields of draft-ietf-httpapi-ratelimit-headers.
+const HeadersLegacy = "legacy"
+
+// setRateLimitHeaders reports the quota left for the request. Limited requests
+// also get a Retry-After with the time left until they would be allowed again.
+func (m *RateLimiterMiddleware) setRateLimitHeaders(w http.ResponseWriter, limitData LimitData, decision ratelimiter.Decision) {
+	header := w.Header()
+	if m.legacyHeaders {
+		reset := time.Now().Add(decision.ResetAfter)
+		header.Set("X-RateLimit-Limit", strconv.FormatInt(decision.Limit, 10))
+		header.Set("X-RateLimit-Remaining", strconv.FormatInt(decision.Remaining, 10))
+		header.Set("X-RateLimit-Reset", strconv.FormatInt(reset.Add(time.Second-1).Unix(), 10))
+	} else {
+		header.Set("RateLimit-Limit", strconv.FormatInt(decision.Limit, 10))
+		header.Set("RateLimit-Remaining", strconv.FormatInt(decision.Remaining, 10))
+		header.Set("RateLimit-Reset", strconv.FormatInt(seconds(decision.ResetAfter), 10))
+		header.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", decision.Limit, seconds(limitData.Window())))
+	}
+
+	if decision.Limited && decision.RetryAfter > 0 {
+		header.Set("Retry-After", strconv.FormatInt(seconds(decision.RetryAfter), 10))
+	}
+}
+
+// seconds rounds a duration up to whole seconds, so clients never retry early.
+func seconds(d time.Duration) int64 {
+	return int64((d + time.Second - 1) / time.Second)
+}
@@ -0,0 +1,150 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// KeyExtractor picks the rate limiter key of a request. It returns false when
+// the request does not carry what it looks for.
+type KeyExtractor interface {
+	Key(r *http.Request) (string, bool)
+}
+
+// KeyFunc adapts an ordinary function to a KeyExtractor.
+type KeyFunc func(r *http.Request) (string, bool)
+
+func (f KeyFunc) Key(r *http.Request) (string, bool) {
+	return f(r)
+}
+
+type middlewareContextKey struct{}
+
+// WithKeyExtractor replaces the default "API_KEY header, else client IP" key.
+// Requests the extractor finds no key for are limited by client IP.
+func WithKeyExtractor(extractor KeyExtractor) Option {
+	return func(m *RateLimiterMiddleware) {
+		m.keyExtractor = extractor
+	}
+}
+
+// HeaderKey uses the value of a request header.
+func HeaderKey(name string) KeyFunc {
+	return func(r *http.Request) (string, bool) {
+		value := r.Header.Get(name)
+		return value, value != ""
+	}
+}
+
+// QueryKey uses the value of a query parameter.
+func QueryKey(param string) KeyFunc {
+	return func(r *http.Request) (string, bool) {
+		value := r.URL.Query().Get(param)
+		return value, value != ""
+	}
+}
+
+// CookieKey uses the value of a cookie.
+func CookieKey(name string) KeyFunc {
+	return func(r *http.Request) (string, bool) {
+		cookie, err := r.Cookie(name)
+		if err != nil || cookie.Value == "" {
+			return "", false
+		}
+		return cookie.Value, true
+	}
+}
+
+// JWTClaimKey uses a claim, such as sub or tenant_id, of the HMAC signed JWT
+// sent in a header, with or without a Bearer prefix. Tokens that fail to
+// verify against secret, or lack the claim, yield no key.
+func JWTClaimKey(header string, claim string, secret []byte) KeyFunc {
+	return func(r *http.Request) (string, bool) {
+		raw := strings.TrimSpace(strings.TrimPrefix(r.Header.Get(header), "Bearer "))
+		if raw == "" {
+			return "", false
+		}
+
+		claims := jwt.MapClaims{}
+		token, err := jwt.ParseWithClaims(raw, claims, func(token *jwt.Token) (interface{}, error) {
+			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+			}
+			return secret, nil
+		})
+		if err != nil || !token.Valid {
+			return "", false
+		}
+
+		value, ok := claims[claim]
+		if !ok || value == nil {
+			return "", false
+		}
+		key := fmt.Sprint(value)
+		return key, key != ""
+	}
+}
+
+// ClientIPKey uses the client address, resolved through the trusted proxies
+// and masked to the prefixes the middleware is configured with.
+func ClientIPKey() KeyFunc {
+	return func(r *http.Request) (string, bool) {
+		m, ok := r.Context().Value(middlewareContextKey{}).(*RateLimiterMiddleware)
+		if !ok {
+			m = &RateLimiterMiddleware{}
+		}
+		key := m.ipKey(m.clientIP(r))
+		return key, key != ""
+	}
+}
+
+// RouteKey uses the request path.
+func RouteKey() KeyFunc {
+	return func(r *http.Request) (string, bool) {
+		return r.URL.Path, r.URL.Path != ""
+	}
+}
+
+// MethodKey uses the request method.
+func MethodKey() KeyFunc {
+	return func(r *http.Request) (string, bool) {
+		return r.Method, r.Method != ""
+	}
+}
+
+// CompositeKey joins the keys of every extractor with "|", for instance to
+// limit each tenant per route. It finds no key when any extractor finds none.
+func CompositeKey(extractors ...KeyExtractor) KeyFunc {
+	return func(r *http.Request) (string, bool) {
+		parts := make([]string, 0, len(extractors))
+		for _, extractor := range extractors {
+			part, ok := extractor.Key(r)
+			if !ok {
+				return "", false
+			}
+			parts = append(parts, part)
+		}
+		return strings.Join(parts, "|"), len(parts) > 0
+	}
+}
+
+// FirstKey uses the key of the first extractor that finds one.
+func FirstKey(extractors ...KeyExtractor) KeyFunc {
+	return func(r *http.Request) (string, bool) {
+		for _, extractor := range extractors {
+			if key, ok := extractor.Key(r); ok {
+				return key, true
+			}
+		}
+		return "", false
+	}
+}
+
+// defaultKeyExtractor is the historical key: the API_KEY header, else the
+// client IP.
+func defaultKeyExtractor() KeyExtractor {
+	return FirstKey(HeaderKey("API_KEY"), ClientIPKey())
+}
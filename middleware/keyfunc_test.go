@@ -0,0 +1,79 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+func TestKeyExtractors(t *testing.T) {
+	secret := []byte("secret")
+	signed := func(claims jwt.MapClaims, key []byte) string {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(key)
+		if err != nil {
+			t.Fatalf("Failed to sign token: %v", err)
+		}
+		return token
+	}
+
+	newRequest := func() *http.Request {
+		req := httptest.NewRequest("POST", "/orders?client=web", nil)
+		req.RemoteAddr = "198.51.100.7:4321"
+		req.Header.Set("X-Tenant", "acme")
+		req.Header.Set("Authorization", "Bearer "+signed(jwt.MapClaims{"sub": "user-1", "tenant_id": 42}, secret))
+		req.AddCookie(&http.Cookie{Name: "session", Value: "s3"})
+		return req
+	}
+
+	tests := []struct {
+		name      string
+		extractor KeyExtractor
+		want      string
+		wantOK    bool
+	}{
+		{"header", HeaderKey("X-Tenant"), "acme", true},
+		{"missing header", HeaderKey("X-Missing"), "", false},
+		{"query", QueryKey("client"), "web", true},
+		{"cookie", CookieKey("session"), "s3", true},
+		{"missing cookie", CookieKey("other"), "", false},
+		{"jwt sub claim", JWTClaimKey("Authorization", "sub", secret), "user-1", true},
+		{"jwt numeric claim", JWTClaimKey("Authorization", "tenant_id", secret), "42", true},
+		{"jwt missing claim", JWTClaimKey("Authorization", "org", secret), "", false},
+		{"jwt with another secret", JWTClaimKey("Authorization", "sub", []byte("other")), "", false},
+		{"client ip", ClientIPKey(), "198.51.100.7", true},
+		{"route", RouteKey(), "/orders", true},
+		{"method", MethodKey(), "POST", true},
+		{"tenant and route", CompositeKey(HeaderKey("X-Tenant"), RouteKey()), "acme|/orders", true},
+		{"composite with a missing part", CompositeKey(HeaderKey("X-Missing"), RouteKey()), "", false},
+		{"first that finds a key", FirstKey(HeaderKey("X-Missing"), CookieKey("session")), "s3", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, ok := test.extractor.Key(newRequest())
+			if got != test.want || ok != test.wantOK {
+				t.Errorf("Key() = %q, %v, want %q, %v", got, ok, test.want, test.wantOK)
+			}
+		})
+	}
+}
+
+func TestGetKeyWithKeyExtractor(t *testing.T) {
+	middleware := &RateLimiterMiddleware{ipv6Prefix: defaultIPv6Prefix}
+	WithKeyExtractor(CompositeKey(HeaderKey("X-Tenant"), MethodKey()))(middleware)
+
+	req := httptest.NewRequest("GET", "/home", nil)
+	req.RemoteAddr = "[2001:db8::1]:4321"
+	req.Header.Set("X-Tenant", "acme")
+	if got := middleware.getKey(req); got != "acme|GET" {
+		t.Errorf("getKey() = %q, want %q", got, "acme|GET")
+	}
+
+	// Without a tenant the request falls back to its masked client IP.
+	req.Header.Del("X-Tenant")
+	if got := middleware.getKey(req); got != "2001:db8::/64" {
+		t.Errorf("getKey() = %q, want %q", got, "2001:db8::/64")
+	}
+}
@@ -0,0 +1,200 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"ratelimiter/pkg/ratelimiter"
+	"strings"
+)
+
+// KeysPath is the prefix of the endpoints acting on the state of limited keys.
+const KeysPath = "/v1/keys"
+
+// BulkResult is the outcome of a reset or unblock of every key matching a
+// pattern.
+type BulkResult struct {
+	Pattern string `json:"pattern"`
+	Count   int64  `json:"count"`
+}
+
+type BlockInfo = ratelimiter.BlockInfo
+type BlockedPage = ratelimiter.BlockedPage
+
+// ListBlocked godoc
+// @Summary List blocked keys
+// @Description list the keys blocked right now, with when they were blocked, when the block ends and the policy that blocked them or the reason and actor of a ban, one page at a time, pass the returned next_cursor to get the next page
+// @Tags keys
+// @Produce  json
+// @Param cursor query string false "Cursor returned by the previous page"
+// @Param page_size query int false "Number of blocks per page (default 100, max 1000)"
+// @Success 200 {object} BlockedPage "Successfully retrieved a page of blocks"
+// @Failure 400 {object} ErrorResponse "Invalid cursor or page size"
+// @Failure 401 {object} ErrorResponse "Missing or invalid admin credentials"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /v1/keys/blocked [get]
+// @Security AdminAuth
+func (m *RateLimiterMiddleware) ListBlocked(writer http.ResponseWriter, request *http.Request) {
+	pageSize, ok := parsePageSize(request)
+	if !ok {
+		writeErrorResponse(writer, http.StatusBadRequest, "Invalid page size")
+		return
+	}
+
+	page, err := m.rateLimiter.ListBlocked(request.URL.Query().Get("cursor"), pageSize)
+	if err == ratelimiter.ErrInvalidCursor {
+		writeErrorResponse(writer, http.StatusBadRequest, "Invalid cursor")
+		return
+	}
+	if err != nil {
+		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(writer).Encode(page)
+}
+
+// ResetKey godoc
+// @Summary Reset a key
+// @Description delete the counters and the block of a key, as well as those the route policies and the global cap keep for it, so its next request starts with a full quota, its limit and its ban are kept
+// @Tags keys
+// @Param key path string true "Limited key"
+// @Success 204 "Successfully reset the key"
+// @Failure 401 {object} ErrorResponse "Missing or invalid admin credentials"
+// @Failure 403 {object} ErrorResponse "Admin credentials without the write role"
+// @Failure 404 {object} ErrorResponse "Missing key"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /v1/keys/{key}/reset [post]
+// @Security AdminAuth
+func (m *RateLimiterMiddleware) ResetKey(writer http.ResponseWriter, request *http.Request) {
+	key, ok := keyAction(request, "/reset")
+	if !ok {
+		http.Error(writer, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	for _, stateKey := range m.stateKeys(key) {
+		if err := m.rateLimiter.Reset(stateKey); err != nil {
+			http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+	}
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+// UnblockKey godoc
+// @Summary Unblock a key
+// @Description lift the block of a key, as well as those the route policies and the global cap set on it, its counters are kept, so a key still over its limit is blocked again on its next request
+// @Tags keys
+// @Param key path string true "Limited key"
+// @Success 204 "Successfully unblocked the key"
+// @Failure 401 {object} ErrorResponse "Missing or invalid admin credentials"
+// @Failure 403 {object} ErrorResponse "Admin credentials without the write role"
+// @Failure 404 {object} ErrorResponse "Key not blocked"
+// @Failure 409 {object} ErrorResponse "Key banned, revoke the ban instead"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /v1/keys/{key}/unblock [post]
+// @Security AdminAuth
+func (m *RateLimiterMiddleware) UnblockKey(writer http.ResponseWriter, request *http.Request) {
+	key, ok := keyAction(request, "/unblock")
+	if !ok {
+		http.Error(writer, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	unblocked := false
+	for _, stateKey := range m.stateKeys(key) {
+		wasBlocked, err := m.rateLimiter.Unblock(stateKey)
+		if err == ratelimiter.ErrBanned {
+			writeErrorResponse(writer, http.StatusConflict, "Key banned, revoke the ban instead")
+			return
+		}
+		if err != nil {
+			http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		unblocked = unblocked || wasBlocked
+	}
+	if !unblocked {
+		writeErrorResponse(writer, http.StatusNotFound, "Key not blocked")
+		return
+	}
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+// ResetMatching godoc
+// @Summary Reset the keys matching a pattern
+// @Description delete the counters and blocks of every key matching a glob pattern, bans excepted, where * matches any text, ? any character, [abc] one of the characters and \ escapes the next one
+// @Tags keys
+// @Produce  json
+// @Param pattern query string true "Glob pattern of the keys, such as user:*"
+// @Success 200 {object} BulkResult "Number of keys reset"
+// @Failure 400 {object} ErrorResponse "Missing pattern"
+// @Failure 401 {object} ErrorResponse "Missing or invalid admin credentials"
+// @Failure 403 {object} ErrorResponse "Admin credentials without the write role"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /v1/keys/reset [post]
+// @Security AdminAuth
+func (m *RateLimiterMiddleware) ResetMatching(writer http.ResponseWriter, request *http.Request) {
+	m.bulkKeyAction(writer, request, m.rateLimiter.ResetMatching)
+}
+
+// UnblockMatching godoc
+// @Summary Unblock the keys matching a pattern
+// @Description lift the block of every key matching a glob pattern, bans excepted, with the same syntax as the bulk reset
+// @Tags keys
+// @Produce  json
+// @Param pattern query string true "Glob pattern of the keys, such as user:*"
+// @Success 200 {object} BulkResult "Number of keys that were blocked"
+// @Failure 400 {object} ErrorResponse "Missing pattern"
+// @Failure 401 {object} ErrorResponse "Missing or invalid admin credentials"
+// @Failure 403 {object} ErrorResponse "Admin credentials without the write role"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /v1/keys/unblock [post]
+// @Security AdminAuth
+func (m *RateLimiterMiddleware) UnblockMatching(writer http.ResponseWriter, request *http.Request) {
+	m.bulkKeyAction(writer, request, m.rateLimiter.UnblockMatching)
+}
+
+// stateKeys returns the keys the requests of a client are counted under: its
+// own key, the key of each route policy and the key of the global cap.
+func (m *RateLimiterMiddleware) stateKeys(key string) []string {
+	keys := []string{key}
+	for _, policy := range m.routePolicies {
+		keys = append(keys, policy.key(key))
+	}
+	if m.globalLimit != nil {
+		keys = append(keys, "global:"+key)
+	}
+	return keys
+}
+
+// keyAction returns the key of a /v1/keys/{key}{action} path.
+func keyAction(request *http.Request, action string) (string, bool) {
+	key, found := strings.CutPrefix(request.URL.Path, KeysPath+"/")
+	if !found {
+		return "", false
+	}
+	key, found = strings.CutSuffix(key, action)
+	return key, found && key != ""
+}
+
+// bulkKeyAction runs action on the pattern query parameter and writes how
+// many keys it changed.
+func (m *RateLimiterMiddleware) bulkKeyAction(writer http.ResponseWriter, request *http.Request, action func(pattern string) (int64, error)) {
+	pattern := request.URL.Query().Get("pattern")
+	count, err := action(pattern)
+	if err == ratelimiter.ErrInvalidPattern {
+		writeErrorResponse(writer, http.StatusBadRequest, "The pattern query parameter is required")
+		return
+	}
+	if err != nil {
+		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(writer).Encode(BulkResult{Pattern: pattern, Count: count})
+}
@@ -0,0 +1,173 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"ratelimiter/configs"
+	"ratelimiter/pkg/ratelimiter"
+	"testing"
+	"time"
+)
+
+func TestResetAndUnblockKeys(t *testing.T) {
+	store := ratelimiter.NewMemoryStore()
+	defer store.Close()
+	limiter := ratelimiter.NewRateLimiter(store)
+	middleware := NewRateLimiterMiddleware(limiter)
+	call := func(handler http.HandlerFunc, target string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handler(rr, httptest.NewRequest("POST", target, nil))
+		return rr
+	}
+
+	for _, key := range []string{"user:1", "user:2", "192.0.2.0/24"} {
+		if err := limiter.Block(key, time.Minute); err != nil {
+			t.Fatalf("Failed to block %s: %v", key, err)
+		}
+	}
+
+	if rr := call(middleware.UnblockKey, "/v1/keys/192.0.2.0/24/unblock"); rr.Code != http.StatusNoContent {
+		t.Errorf("unblock returned wrong status code: got %v want %v", rr.Code, http.StatusNoContent)
+	}
+	if rr := call(middleware.UnblockKey, "/v1/keys/192.0.2.0/24/unblock"); rr.Code != http.StatusNotFound {
+		t.Errorf("second unblock returned wrong status code: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+
+	rr := call(middleware.UnblockMatching, "/v1/keys/unblock?pattern=user:*")
+	var result BulkResult
+	_ = json.NewDecoder(rr.Body).Decode(&result)
+	if rr.Code != http.StatusOK || result.Count != 2 || result.Pattern != "user:*" {
+		t.Errorf("bulk unblock returned %v %+v", rr.Code, result)
+	}
+	if blocked, _ := limiter.IsBlocked("user:1"); blocked {
+		t.Errorf("user:1 is still blocked after the bulk unblock")
+	}
+
+	if rr := call(middleware.ResetMatching, "/v1/keys/reset"); rr.Code != http.StatusBadRequest {
+		t.Errorf("bulk reset without a pattern returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+
+	if _, err := store.CheckAndIncrement("user:1", 1, 1, time.Minute, 0); err != nil {
+		t.Fatalf("Failed to count a request: %v", err)
+	}
+	if rr := call(middleware.ResetKey, "/v1/keys/user:1/reset"); rr.Code != http.StatusNoContent {
+		t.Errorf("reset returned wrong status code: got %v want %v", rr.Code, http.StatusNoContent)
+	}
+	if decision, _ := store.CheckAndIncrement("user:1", 1, 1, time.Minute, 0); decision.Limited {
+		t.Errorf("user:1 is still limited after the reset")
+	}
+	if rr := call(middleware.ResetKey, "/v1/keys//reset"); rr.Code != http.StatusNotFound {
+		t.Errorf("reset without a key returned wrong status code: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+}
+
+func TestListBlocked(t *testing.T) {
+	configs.LoadConfig()
+	store := ratelimiter.NewMemoryStore()
+	defer store.Close()
+	middleware := NewRateLimiterMiddleware(ratelimiter.NewRateLimiter(store), WithRoutePolicies(RoutePolicy{
+		Method:  "POST",
+		Pattern: "/orders",
+		Limit:   LimitData{Seconds: 60, MaxRequests: 1, BlockDuration: 30},
+	}))
+	handler := middleware.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	}))
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest("POST", "/orders", nil)
+		req.RemoteAddr = "192.0.2.4:1234"
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	rr := httptest.NewRecorder()
+	middleware.ListBlocked(rr, httptest.NewRequest("GET", "/v1/keys/blocked", nil))
+	var page BlockedPage
+	_ = json.NewDecoder(rr.Body).Decode(&page)
+	if rr.Code != http.StatusOK || len(page.Items) != 1 {
+		t.Fatalf("list returned %v %+v", rr.Code, page)
+	}
+	block := page.Items[0]
+	if block.Key != "route:POST /orders:192.0.2.4" || block.Policy != "route:POST /orders" || block.BlockedAt == nil || block.ExpiresAt == nil {
+		t.Errorf("list returned the block %+v", block)
+	}
+
+	rr = httptest.NewRecorder()
+	middleware.ListBlocked(rr, httptest.NewRequest("GET", "/v1/keys/blocked?page_size=0", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("list with page_size=0 returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+}
+
+func TestResetKeyResetsRoutesAndGlobalCap(t *testing.T) {
+	configs.LoadConfig()
+	store := ratelimiter.NewMemoryStore()
+	defer store.Close()
+	middleware := NewRateLimiterMiddleware(ratelimiter.NewRateLimiter(store), WithRoutePolicies(RoutePolicy{
+		Method:  "POST",
+		Pattern: "/orders",
+		Limit:   LimitData{Seconds: 60, MaxRequests: 1, BlockDuration: 30},
+	}), WithGlobalLimit(LimitData{Seconds: 60, MaxRequests: 2}))
+	handler := middleware.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	}))
+	serve := func(target string) int {
+		req := httptest.NewRequest("POST", target, nil)
+		req.RemoteAddr = "192.0.2.5:1234"
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	// The first order uses up the route, the second is blocked by it and the
+	// third request uses up the global cap.
+	for _, target := range []string{"/orders", "/orders", "/home", "/home"} {
+		serve(target)
+	}
+	if status := serve("/home"); status != http.StatusTooManyRequests {
+		t.Fatalf("POST /home before the reset returned wrong status code: got %v want %v", status, http.StatusTooManyRequests)
+	}
+
+	rr := httptest.NewRecorder()
+	middleware.ResetKey(rr, httptest.NewRequest("POST", "/v1/keys/192.0.2.5/reset", nil))
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("reset returned wrong status code: got %v want %v", rr.Code, http.StatusNoContent)
+	}
+	for _, target := range []string{"/orders", "/home"} {
+		if status := serve(target); status != http.StatusOK {
+			t.Errorf("POST %s after the reset returned wrong status code: got %v want %v", target, status, http.StatusOK)
+		}
+	}
+}
+
+func TestUnblockKeyUnblocksRoutes(t *testing.T) {
+	configs.LoadConfig()
+	store := ratelimiter.NewMemoryStore()
+	defer store.Close()
+	limiter := ratelimiter.NewRateLimiter(store)
+	middleware := NewRateLimiterMiddleware(limiter, WithRoutePolicies(RoutePolicy{
+		Method:  "POST",
+		Pattern: "/orders",
+		Limit:   LimitData{Seconds: 60, MaxRequests: 1, BlockDuration: 30},
+	}))
+	handler := middleware.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	}))
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest("POST", "/orders", nil)
+		req.RemoteAddr = "192.0.2.6:1234"
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	if blocked, _ := limiter.IsBlocked("route:POST /orders:192.0.2.6"); !blocked {
+		t.Fatalf("the route policy did not block 192.0.2.6")
+	}
+
+	rr := httptest.NewRecorder()
+	middleware.UnblockKey(rr, httptest.NewRequest("POST", "/v1/keys/192.0.2.6/unblock", nil))
+	if rr.Code != http.StatusNoContent {
+		t.Errorf("unblock returned wrong status code: got %v want %v", rr.Code, http.StatusNoContent)
+	}
+	if blocked, _ := limiter.IsBlocked("route:POST /orders:192.0.2.6"); blocked {
+		t.Errorf("the route block of 192.0.2.6 is still there after the unblock")
+	}
+}
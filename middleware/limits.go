@@ -0,0 +1,265 @@
+package middleware
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"ratelimiter/pkg/ratelimiter"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// LimitsPath is the collection of the limit data resource.
+const LimitsPath = "/v1/limits"
+
+type FieldError = ratelimiter.FieldError
+
+// ValidationErrorResponse is the body of a 400 caused by invalid fields.
+type ValidationErrorResponse struct {
+	Message string       `json:"message"`
+	Errors  []FieldError `json:"errors"`
+}
+
+// ListLimits godoc
+// @Summary List limits
+// @Description list the limit data one page at a time, pass the returned next_cursor to get the next page
+// @Tags limits
+// @Produce  json
+// @Param cursor query string false "Cursor returned by the previous page"
+// @Param page_size query int false "Number of limits per page (default 100, max 1000)"
+// @Success 200 {object} LimitDataPage "Successfully retrieved a page of limits"
+// @Failure 400 {object} ErrorResponse "Invalid cursor or page size"
+// @Failure 401 {object} ErrorResponse "Missing or invalid admin credentials"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /v1/limits [get]
+// @Security AdminAuth
+func (m *RateLimiterMiddleware) ListLimits(writer http.ResponseWriter, request *http.Request) {
+	m.GetAllRateLimiter(writer, request)
+}
+
+// GetLimit godoc
+// @Summary Get a limit
+// @Description get the limit data with the given id, or else of the given key
+// @Tags limits
+// @Produce  json
+// @Param ref path string true "Id or key of the limit"
+// @Success 200 {object} LimitData "Successfully retrieved the limit"
+// @Failure 401 {object} ErrorResponse "Missing or invalid admin credentials"
+// @Failure 404 {object} ErrorResponse "Limit not found"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /v1/limits/{ref} [get]
+// @Security AdminAuth
+func (m *RateLimiterMiddleware) GetLimit(writer http.ResponseWriter, request *http.Request) {
+	limitData, err := m.findLimit(request)
+	if err != nil {
+		writeLimitError(writer, err)
+		return
+	}
+	writeLimit(writer, http.StatusOK, limitData)
+}
+
+// CreateLimit godoc
+// @Summary Create a limit
+// @Description create the limit data of a key, the id is generated
+// @Tags limits
+// @Accept  json
+// @Produce  json
+// @Param body body LimitData true "Limit, without id"
+// @Success 201 {object} LimitData "Successfully created the limit"
+// @Failure 400 {object} ValidationErrorResponse "Invalid limit"
+// @Failure 401 {object} ErrorResponse "Missing or invalid admin credentials"
+// @Failure 403 {object} ErrorResponse "Admin credentials without the write role"
+// @Failure 409 {object} ErrorResponse "The key already has a limit"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /v1/limits [post]
+// @Security AdminAuth
+func (m *RateLimiterMiddleware) CreateLimit(writer http.ResponseWriter, request *http.Request) {
+	var limitData LimitData
+	if err := decodeLimitBody(request, &limitData); err != nil {
+		writeLimitError(writer, err)
+		return
+	}
+	limitData.Id = uuid.New().String()
+	if err := limitData.Validate(); err != nil {
+		writeLimitError(writer, err)
+		return
+	}
+
+	err := m.rateLimiter.CreateLimitData(limitData.Key, limitData)
+	if err == ratelimiter.ErrExists {
+		writeErrorResponse(writer, http.StatusConflict, "The key already has a limit")
+		return
+	}
+	if err != nil {
+		writeLimitError(writer, err)
+		return
+	}
+	writer.Header().Set("Location", LimitsPath+"/"+limitData.Id)
+	writeLimit(writer, http.StatusCreated, limitData)
+}
+
+// ReplaceLimit godoc
+// @Summary Replace a limit
+// @Description replace every setting of the limit with the given id or key, the key and id are kept
+// @Tags limits
+// @Accept  json
+// @Produce  json
+// @Param ref path string true "Id or key of the limit"
+// @Param body body LimitData true "Limit"
+// @Success 200 {object} LimitData "Successfully replaced the limit"
+// @Failure 400 {object} ValidationErrorResponse "Invalid limit"
+// @Failure 401 {object} ErrorResponse "Missing or invalid admin credentials"
+// @Failure 403 {object} ErrorResponse "Admin credentials without the write role"
+// @Failure 404 {object} ErrorResponse "Limit not found"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /v1/limits/{ref} [put]
+// @Security AdminAuth
+func (m *RateLimiterMiddleware) ReplaceLimit(writer http.ResponseWriter, request *http.Request) {
+	existing, err := m.findLimit(request)
+	if err != nil {
+		writeLimitError(writer, err)
+		return
+	}
+
+	var limitData LimitData
+	if err := decodeLimitBody(request, &limitData); err != nil {
+		writeLimitError(writer, err)
+		return
+	}
+	if limitData.Key != "" && limitData.Key != existing.Key {
+		writeLimitError(writer, keyChangedError())
+		return
+	}
+	limitData.Key, limitData.Id = existing.Key, existing.Id
+	m.saveLimit(writer, limitData)
+}
+
+// PatchLimit godoc
+// @Summary Update a limit
+// @Description update the settings present in the body, of the limit with the given id or key
+// @Tags limits
+// @Accept  json
+// @Produce  json
+// @Param ref path string true "Id or key of the limit"
+// @Param body body LimitDataInput true "Settings to update"
+// @Success 200 {object} LimitData "Successfully updated the limit"
+// @Failure 400 {object} ValidationErrorResponse "Invalid limit"
+// @Failure 401 {object} ErrorResponse "Missing or invalid admin credentials"
+// @Failure 403 {object} ErrorResponse "Admin credentials without the write role"
+// @Failure 404 {object} ErrorResponse "Limit not found"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /v1/limits/{ref} [patch]
+// @Security AdminAuth
+func (m *RateLimiterMiddleware) PatchLimit(writer http.ResponseWriter, request *http.Request) {
+	existing, err := m.findLimit(request)
+	if err != nil {
+		writeLimitError(writer, err)
+		return
+	}
+
+	var input ratelimiter.LimitDataInput
+	if err := decodeLimitBody(request, &input); err != nil {
+		writeLimitError(writer, err)
+		return
+	}
+	if input.Key != "" && input.Key != existing.Key {
+		writeLimitError(writer, keyChangedError())
+		return
+	}
+	m.saveLimit(writer, existing.Merge(input))
+}
+
+// DeleteLimit godoc
+// @Summary Delete a limit
+// @Description delete the limit with the given id or key, its key goes back to the default limit
+// @Tags limits
+// @Param ref path string true "Id or key of the limit"
+// @Success 204 "Successfully deleted the limit"
+// @Failure 401 {object} ErrorResponse "Missing or invalid admin credentials"
+// @Failure 403 {object} ErrorResponse "Admin credentials without the write role"
+// @Failure 404 {object} ErrorResponse "Limit not found"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /v1/limits/{ref} [delete]
+// @Security AdminAuth
+func (m *RateLimiterMiddleware) DeleteLimit(writer http.ResponseWriter, request *http.Request) {
+	limitData, err := m.findLimit(request)
+	if err != nil {
+		writeLimitError(writer, err)
+		return
+	}
+	if err := m.rateLimiter.DeleteLimitData(limitData.Key); err != nil {
+		writeLimitError(writer, err)
+		return
+	}
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+// findLimit returns the limit data the path refers to, by id or else by key.
+func (m *RateLimiterMiddleware) findLimit(request *http.Request) (LimitData, error) {
+	ref := strings.TrimPrefix(request.URL.Path, LimitsPath+"/")
+	if ref == "" || ref == request.URL.Path {
+		return LimitData{}, ratelimiter.ErrNotFound
+	}
+
+	limitData, err := m.rateLimiter.GetLimitDataByID(ref)
+	if err != ratelimiter.ErrNotFound {
+		return limitData, err
+	}
+	limitData, err = m.rateLimiter.GetLimitData(ref)
+	limitData.Key = ref
+	return limitData, err
+}
+
+// saveLimit validates and stores the limit data and writes it back.
+func (m *RateLimiterMiddleware) saveLimit(writer http.ResponseWriter, limitData LimitData) {
+	if err := limitData.Validate(); err != nil {
+		writeLimitError(writer, err)
+		return
+	}
+	if err := m.rateLimiter.SetLimitData(limitData.Key, limitData); err != nil {
+		writeLimitError(writer, err)
+		return
+	}
+	writeLimit(writer, http.StatusOK, limitData)
+}
+
+// decodeLimitBody decodes a JSON body, reporting the fields holding a value
+// of the wrong type as a validation error.
+func decodeLimitBody(request *http.Request, v interface{}) error {
+	err := json.NewDecoder(request.Body).Decode(v)
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return &ratelimiter.ValidationError{Fields: []FieldError{{Field: typeErr.Field, Message: "must be a " + typeErr.Type.String()}}}
+	}
+	if err != nil {
+		return &ratelimiter.ValidationError{Fields: []FieldError{{Field: "body", Message: "must be a JSON object"}}}
+	}
+	return nil
+}
+
+func keyChangedError() error {
+	return &ratelimiter.ValidationError{Fields: []FieldError{{Field: "key", Message: "cannot be changed"}}}
+}
+
+func writeLimit(writer http.ResponseWriter, status int, limitData LimitData) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	_ = json.NewEncoder(writer).Encode(limitData)
+}
+
+// writeLimitError writes a 400 with the invalid fields of a validation error,
+// a 404 for ErrNotFound and a 500 for anything else.
+func writeLimitError(writer http.ResponseWriter, err error) {
+	var validationErr *ratelimiter.ValidationError
+	switch {
+	case errors.As(err, &validationErr):
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(writer).Encode(ValidationErrorResponse{Message: validationErr.Error(), Errors: validationErr.Fields})
+	case err == ratelimiter.ErrNotFound:
+		writeErrorResponse(writer, http.StatusNotFound, "Limit not found")
+	default:
+		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,143 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"ratelimiter/configs"
+	"ratelimiter/pkg/ratelimiter"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestLimitsResource(t *testing.T) {
+	configs.LoadConfig()
+	store := ratelimiter.NewMemoryStore()
+	defer store.Close()
+	middleware := NewRateLimiterMiddleware(ratelimiter.NewRateLimiter(store))
+	call := func(handler http.HandlerFunc, method string, target string, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handler(rr, httptest.NewRequest(method, target, strings.NewReader(body)))
+		return rr
+	}
+
+	rr := call(middleware.CreateLimit, "POST", "/v1/limits", `{"key": "192.0.2.0/24", "seconds": 60, "max_requests": 100, "id": "chosen"}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("create returned wrong status code: got %v want %v: %s", rr.Code, http.StatusCreated, rr.Body.String())
+	}
+	var created LimitData
+	if err := json.NewDecoder(rr.Body).Decode(&created); err != nil {
+		t.Fatalf("Failed to decode the created limit: %v", err)
+	}
+	if created.Id == "" || created.Id == "chosen" || rr.Header().Get("Location") != "/v1/limits/"+created.Id {
+		t.Errorf("create returned id %q and Location %q", created.Id, rr.Header().Get("Location"))
+	}
+
+	if rr := call(middleware.CreateLimit, "POST", "/v1/limits", `{"key": "192.0.2.0/24", "seconds": 60, "max_requests": 1}`); rr.Code != http.StatusConflict {
+		t.Errorf("second create returned wrong status code: got %v want %v", rr.Code, http.StatusConflict)
+	}
+
+	for _, target := range []string{"/v1/limits/" + created.Id, "/v1/limits/192.0.2.0/24"} {
+		rr := call(middleware.GetLimit, "GET", target, "")
+		var got LimitData
+		_ = json.NewDecoder(rr.Body).Decode(&got)
+		if rr.Code != http.StatusOK || got.Id != created.Id || got.Key != "192.0.2.0/24" {
+			t.Errorf("get %s returned %v %+v", target, rr.Code, got)
+		}
+	}
+
+	rr = call(middleware.PatchLimit, "PATCH", "/v1/limits/"+created.Id, `{"max_requests": 200, "dry_run": true}`)
+	var patched LimitData
+	_ = json.NewDecoder(rr.Body).Decode(&patched)
+	if rr.Code != http.StatusOK || patched.MaxRequests != 200 || patched.Seconds != 60 || !patched.DryRun {
+		t.Errorf("patch returned %v %+v", rr.Code, patched)
+	}
+
+	rr = call(middleware.ReplaceLimit, "PUT", "/v1/limits/"+created.Id, `{"algorithm": "token_bucket", "capacity": 10, "refill_per_second": 1}`)
+	var replaced LimitData
+	_ = json.NewDecoder(rr.Body).Decode(&replaced)
+	if rr.Code != http.StatusOK || replaced.Id != created.Id || replaced.Key != "192.0.2.0/24" || replaced.MaxRequests != 0 || replaced.Capacity != 10 {
+		t.Errorf("replace returned %v %+v", rr.Code, replaced)
+	}
+
+	invalid := []struct {
+		name    string
+		handler http.HandlerFunc
+		method  string
+		target  string
+		body    string
+		field   string
+	}{
+		{"create without key", middleware.CreateLimit, "POST", "/v1/limits", `{"seconds": 60, "max_requests": 1}`, "key"},
+		{"create with a wrong type", middleware.CreateLimit, "POST", "/v1/limits", `{"key": "a", "seconds": "60"}`, "seconds"},
+		{"replace with a negative value", middleware.ReplaceLimit, "PUT", "/v1/limits/" + created.Id, `{"seconds": 60, "max_requests": -1}`, "max_requests"},
+		{"patch changing the key", middleware.PatchLimit, "PATCH", "/v1/limits/" + created.Id, `{"key": "other"}`, "key"},
+		{"patch with an unknown algorithm", middleware.PatchLimit, "PATCH", "/v1/limits/" + created.Id, `{"algorithm": "fastest"}`, "algorithm"},
+	}
+	for _, test := range invalid {
+		rr := call(test.handler, test.method, test.target, test.body)
+		var response ValidationErrorResponse
+		_ = json.NewDecoder(rr.Body).Decode(&response)
+		if rr.Code != http.StatusBadRequest || len(response.Errors) == 0 || response.Errors[0].Field != test.field {
+			t.Errorf("%s returned %v %+v, want a 400 on %s", test.name, rr.Code, response, test.field)
+		}
+	}
+
+	if rr := call(middleware.DeleteLimit, "DELETE", "/v1/limits/"+created.Id, ""); rr.Code != http.StatusNoContent {
+		t.Errorf("delete returned wrong status code: got %v want %v", rr.Code, http.StatusNoContent)
+	}
+	for _, target := range []string{"/v1/limits/" + created.Id, "/v1/limits/192.0.2.0/24"} {
+		if rr := call(middleware.GetLimit, "GET", target, ""); rr.Code != http.StatusNotFound {
+			t.Errorf("get %s after delete returned wrong status code: got %v want %v", target, rr.Code, http.StatusNotFound)
+		}
+	}
+}
+
+func TestCreateLimitConcurrent(t *testing.T) {
+	configs.LoadConfig()
+	store := ratelimiter.NewMemoryStore()
+	defer store.Close()
+	middleware := NewRateLimiterMiddleware(ratelimiter.NewRateLimiter(store))
+
+	var wg sync.WaitGroup
+	var created int32
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rr := httptest.NewRecorder()
+			middleware.CreateLimit(rr, httptest.NewRequest("POST", "/v1/limits", strings.NewReader(`{"key": "192.0.2.1", "seconds": 60, "max_requests": 1}`)))
+			if rr.Code == http.StatusCreated {
+				atomic.AddInt32(&created, 1)
+			} else if rr.Code != http.StatusConflict {
+				t.Errorf("create returned wrong status code: got %v want %v", rr.Code, http.StatusConflict)
+			}
+		}()
+	}
+	wg.Wait()
+	if created != 1 {
+		t.Errorf("%d creates of the same key succeeded, want 1", created)
+	}
+}
+
+func TestDefaultLimitHasNoID(t *testing.T) {
+	configs.LoadConfig()
+	store := ratelimiter.NewMemoryStore()
+	defer store.Close()
+	rateLimiter := ratelimiter.NewRateLimiter(store)
+	handler := NewRateLimiterMiddleware(rateLimiter).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	req := httptest.NewRequest("GET", "/home", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	limitData, err := rateLimiter.GetLimitData("192.0.2.1")
+	if err != nil {
+		t.Fatalf("Failed to get the default limit: %v", err)
+	}
+	if limitData.Id != "" {
+		t.Errorf("the default limit was created with id %q, want none", limitData.Id)
+	}
+}
@@ -1,12 +1,17 @@
 package middleware
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"github.com/dgrijalva/jwt-go"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"ratelimiter/configs"
 	"ratelimiter/pkg/ratelimiter"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 )
@@ -119,6 +124,313 @@ func TestRateLimiter(t *testing.T) {
 	})
 }
 
+func TestRateLimiterMemoryStore(t *testing.T) {
+	configs.LoadConfig()
+	store := ratelimiter.NewMemoryStore()
+	defer store.Close()
+	middleware := NewRateLimiterMiddleware(ratelimiter.NewRateLimiter(store))
+
+	handler := middleware.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	}))
+
+	for i := 0; i < int(configs.GetLimitRequestsDefaultByIP()); i++ {
+		req := httptest.NewRequest("GET", "/home", nil)
+		req.RemoteAddr = "192.0.2.2:1234"
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if status := rr.Code; status != http.StatusOK {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/home", nil)
+	req.RemoteAddr = "192.0.2.2:1234"
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusTooManyRequests {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusTooManyRequests)
+	}
+}
+
+func TestCIDRLimit(t *testing.T) {
+	configs.LoadConfig()
+	store := ratelimiter.NewMemoryStore()
+	defer store.Close()
+	limits, err := ParseCIDRLimits("203.0.113.0/24=2")
+	if err != nil {
+		t.Fatalf("Failed to parse CIDR limits: %v", err)
+	}
+	middleware := NewRateLimiterMiddleware(ratelimiter.NewRateLimiter(store), WithCIDRLimits(limits))
+
+	handler := middleware.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	}))
+
+	want := []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests}
+	for i, remoteAddr := range []string{"203.0.113.1:1234", "203.0.113.2:1234", "203.0.113.3:1234"} {
+		req := httptest.NewRequest("GET", "/home", nil)
+		req.RemoteAddr = remoteAddr
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if status := rr.Code; status != want[i] {
+			t.Errorf("handler returned wrong status code for %s: got %v want %v", remoteAddr, status, want[i])
+		}
+	}
+}
+
+func TestRateLimitHeaders(t *testing.T) {
+	configs.LoadConfig()
+	limitData := ratelimiter.LimitData{Key: "192.0.2.3", Seconds: 10, MaxRequests: 2, BlockDuration: 30}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	})
+	serve := func(handler http.Handler) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/home", nil)
+		req.RemoteAddr = limitData.Key + ":1234"
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("sends the IETF fields and Retry-After", func(t *testing.T) {
+		store := ratelimiter.NewMemoryStore()
+		defer store.Close()
+		rateLimiter := ratelimiter.NewRateLimiter(store)
+		rateLimiter.SetLimitData(limitData.Key, limitData)
+		handler := NewRateLimiterMiddleware(rateLimiter).Middleware(next)
+
+		rr := serve(handler)
+		want := map[string]string{
+			"RateLimit-Limit":     "2",
+			"RateLimit-Remaining": "1",
+			"RateLimit-Reset":     "10",
+			"RateLimit-Policy":    "2;w=10",
+		}
+		for name, value := range want {
+			if got := rr.Header().Get(name); got != value {
+				t.Errorf("handler returned wrong %s header: got %q want %q", name, got, value)
+			}
+		}
+		if got := rr.Header().Get("Retry-After"); got != "" {
+			t.Errorf("handler returned a Retry-After on an allowed request: %q", got)
+		}
+
+		serve(handler)
+		rr = serve(handler)
+		if status := rr.Code; status != http.StatusTooManyRequests {
+			t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusTooManyRequests)
+		}
+		if got := rr.Header().Get("Retry-After"); got != "30" {
+			t.Errorf("handler returned wrong Retry-After header: got %q want %q", got, "30")
+		}
+		if got := rr.Header().Get("RateLimit-Remaining"); got != "0" {
+			t.Errorf("handler returned wrong RateLimit-Remaining header: got %q want %q", got, "0")
+		}
+	})
+
+	t.Run("sends the legacy fields when asked to", func(t *testing.T) {
+		store := ratelimiter.NewMemoryStore()
+		defer store.Close()
+		rateLimiter := ratelimiter.NewRateLimiter(store)
+		rateLimiter.SetLimitData(limitData.Key, limitData)
+		handler := NewRateLimiterMiddleware(rateLimiter, WithLegacyHeaders()).Middleware(next)
+
+		rr := serve(handler)
+		if got := rr.Header().Get("X-RateLimit-Remaining"); got != "1" {
+			t.Errorf("handler returned wrong X-RateLimit-Remaining header: got %q want %q", got, "1")
+		}
+		reset, err := strconv.ParseInt(rr.Header().Get("X-RateLimit-Reset"), 10, 64)
+		if err != nil || reset < time.Now().Unix() {
+			t.Errorf("handler returned wrong X-RateLimit-Reset header: %q", rr.Header().Get("X-RateLimit-Reset"))
+		}
+		if got := rr.Header().Get("RateLimit-Limit"); got != "" {
+			t.Errorf("handler returned an IETF header in legacy mode: %q", got)
+		}
+	})
+}
+
+func TestGetAllRateLimiter(t *testing.T) {
+	configs.LoadConfig()
+	store := ratelimiter.NewMemoryStore()
+	defer store.Close()
+	rateLimiter := ratelimiter.NewRateLimiter(store)
+	middleware := NewRateLimiterMiddleware(rateLimiter)
+
+	for _, key := range []string{"a", "b", "c"} {
+		rateLimiter.SetLimitData(key, ratelimiter.LimitData{Key: key})
+	}
+
+	t.Run("returns a page and its next cursor", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/get-all-rate-limiter?page_size=2", nil)
+		rr := httptest.NewRecorder()
+		middleware.GetAllRateLimiter(rr, req)
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+		}
+
+		var page LimitDataPage
+		if err := json.NewDecoder(rr.Body).Decode(&page); err != nil {
+			t.Fatalf("Failed to decode page: %v", err)
+		}
+		if len(page.Items) != 2 || page.NextCursor != "b" {
+			t.Errorf("handler returned wrong page: got %+v", page)
+		}
+	})
+
+	t.Run("rejects an invalid page size", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/get-all-rate-limiter?page_size=0", nil)
+		rr := httptest.NewRecorder()
+		middleware.GetAllRateLimiter(rr, req)
+		if status := rr.Code; status != http.StatusBadRequest {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+		}
+	})
+}
+
+func TestUpdateRateLimiter(t *testing.T) {
+	configs.LoadConfig()
+	store := ratelimiter.NewMemoryStore()
+	defer store.Close()
+	rateLimiter := ratelimiter.NewRateLimiter(store)
+	middleware := NewRateLimiterMiddleware(rateLimiter)
+	rateLimiter.SetLimitData("192.0.2.0/24", ratelimiter.LimitData{Key: "192.0.2.0/24", Seconds: 10, MaxRequests: 5})
+
+	// The key comes from the path, not from the API_KEY of the caller.
+	req := httptest.NewRequest("PUT", "/update-rate-limiter/192.0.2.0/24", strings.NewReader(`{"max_requests": 50}`))
+	req.Header.Set("API_KEY", "caller")
+	rr := httptest.NewRecorder()
+	middleware.UpdateRateLimiter(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	limitData, err := rateLimiter.GetLimitData("192.0.2.0/24")
+	if err != nil || limitData.MaxRequests != 50 || limitData.Seconds != 10 {
+		t.Errorf("updated limit data = %+v, %v", limitData, err)
+	}
+	if _, err := rateLimiter.GetLimitData("caller"); err != ratelimiter.ErrNotFound {
+		t.Errorf("limit data of the caller = %v, want %v", err, ratelimiter.ErrNotFound)
+	}
+
+	// The body can neither move the limit to another key nor make it invalid.
+	for _, body := range []string{
+		`{"key": "other", "max_requests": 1}`,
+		`{"algorithm": "gcra", "seconds": -1}`,
+		`{"max_requests": "many"}`,
+	} {
+		rr := httptest.NewRecorder()
+		middleware.UpdateRateLimiter(rr, httptest.NewRequest("PUT", "/update-rate-limiter/192.0.2.0/24", strings.NewReader(body)))
+		if status := rr.Code; status != http.StatusBadRequest {
+			t.Errorf("%s returned wrong status code: got %v want %v", body, status, http.StatusBadRequest)
+		}
+	}
+	if _, err := rateLimiter.GetLimitData("other"); err != ratelimiter.ErrNotFound {
+		t.Errorf("limit data of the key in the body = %v, want %v", err, ratelimiter.ErrNotFound)
+	}
+
+	for target, want := range map[string]int{
+		"/update-rate-limiter/":        http.StatusBadRequest,
+		"/update-rate-limiter/missing": http.StatusNotFound,
+	} {
+		rr := httptest.NewRecorder()
+		middleware.UpdateRateLimiter(rr, httptest.NewRequest("PUT", target, strings.NewReader(`{}`)))
+		if status := rr.Code; status != want {
+			t.Errorf("%s returned wrong status code: got %v want %v", target, status, want)
+		}
+	}
+}
+
+func TestWaitInQueue(t *testing.T) {
+	middleware := &RateLimiterMiddleware{}
+
+	t.Run("waits for the slot", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/home", nil)
+		start := time.Now()
+		if !middleware.waitInQueue(req, 50*time.Millisecond) {
+			t.Errorf("waitInQueue returned false for a live request")
+		}
+		if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+			t.Errorf("waitInQueue returned after %v, want at least 50ms", elapsed)
+		}
+	})
+
+	t.Run("stops when the request is cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		req := httptest.NewRequest("GET", "/home", nil).WithContext(ctx)
+		if middleware.waitInQueue(req, time.Minute) {
+			t.Errorf("waitInQueue returned true for a cancelled request")
+		}
+	})
+}
+
+func TestCancelledQueuedRequest(t *testing.T) {
+	configs.LoadConfig()
+	store := ratelimiter.NewMemoryStore()
+	defer store.Close()
+	rateLimiter := ratelimiter.NewRateLimiter(store)
+	limitData := LimitData{Key: "192.0.2.8", Algorithm: ratelimiter.AlgorithmLeakyBucket, LeakPerSecond: 1, MaxQueue: 10}
+	if err := rateLimiter.SetLimitData(limitData.Key, limitData); err != nil {
+		t.Fatalf("Failed to set limit data: %v", err)
+	}
+	handler := NewRateLimiterMiddleware(rateLimiter).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	serve := func(ctx context.Context) int {
+		req := httptest.NewRequest("GET", "/home", nil).WithContext(ctx)
+		req.RemoteAddr = "192.0.2.8:1234"
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	if status := serve(context.Background()); status != http.StatusOK {
+		t.Errorf("first request returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if status := serve(ctx); status != http.StatusServiceUnavailable {
+		t.Errorf("cancelled request returned wrong status code: got %v want %v", status, http.StatusServiceUnavailable)
+	}
+
+	// The cancelled request gave its slot back, so the next one takes it.
+	decision, err := rateLimiter.Evaluate(limitData.Key, limitData)
+	if err != nil {
+		t.Fatalf("Failed to evaluate: %v", err)
+	}
+	if decision.Delay > time.Second {
+		t.Errorf("next request waits %v, want at most 1s", decision.Delay)
+	}
+}
+
+// unreadableLimitStore fails to read any limit data, as a Redis outage would.
+type unreadableLimitStore struct {
+	*ratelimiter.MemoryStore
+}
+
+func (s unreadableLimitStore) GetInfoLimitData(key string) (ratelimiter.LimitData, error) {
+	return ratelimiter.LimitData{}, errors.New("connection refused")
+}
+
+func TestLimitDataStoreError(t *testing.T) {
+	configs.LoadConfig()
+	store := ratelimiter.NewMemoryStore()
+	defer store.Close()
+	rateLimiter := ratelimiter.NewRateLimiter(unreadableLimitStore{store})
+	handler := NewRateLimiterMiddleware(rateLimiter).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	}))
+
+	req := httptest.NewRequest("GET", "/home", nil)
+	req.RemoteAddr = "192.0.2.7:1234"
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusInternalServerError)
+	}
+	if _, err := store.GetInfoLimitData("192.0.2.7"); err == nil {
+		t.Errorf("the defaults were saved over the limit data that could not be read")
+	}
+}
+
 func GetRemoteAddr() string {
 	redisAddress := os.Getenv("REDIS_ADDRESS")
 	if redisAddress == "" {
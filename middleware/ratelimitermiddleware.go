@@ -1,13 +1,17 @@
 package middleware
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/dgrijalva/jwt-go"
-	"github.com/google/uuid"
+	"log"
+	"net"
 	"net/http"
 	"ratelimiter/configs"
 	"ratelimiter/pkg/ratelimiter"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
@@ -16,103 +20,228 @@ import (
 // @Summary Struct to store rate limiter data for Swagger documentation
 // @Description Struct to store rate limiter data for Swagger documentation
 type LimitDataInput struct {
-	Seconds       int64 `json:"seconds"`
-	BlockDuration int64 `json:"block_duration"`
-	MaxRequests   int64 `json:"max_requests"`
+	Seconds         int64   `json:"seconds"`
+	BlockDuration   int64   `json:"block_duration"`
+	MaxRequests     int64   `json:"max_requests"`
+	Algorithm       string  `json:"algorithm" enums:"fixed_window,sliding_window,sliding_window_log,token_bucket,gcra,leaky_bucket"`
+	Capacity        int64   `json:"capacity"`
+	RefillPerSecond float64 `json:"refill_per_second"`
+	LeakPerSecond   float64 `json:"leak_per_second"`
+	MaxQueue        int64   `json:"max_queue"`
+	MaxWait         int64   `json:"max_wait"`
+	MaxInFlight     int64   `json:"max_in_flight"`
+	LeaseSeconds    int64   `json:"lease_seconds"`
+	DryRun          bool    `json:"dry_run"`
 }
 
 type LimitData = ratelimiter.LimitData
 
+type LimitDataPage = ratelimiter.LimitDataPage
+
+const maxPageSize = 1000
+
 type RateLimiterMiddleware struct {
 	rateLimiter                *ratelimiter.RateLimiter
 	defaultLimitByIp           int64
 	defaultRequestLimitInSec   int64
 	defaultRequestLimitByToken int64
 	defaultBlockDuration       time.Duration
+	legacyHeaders              bool
+	trustedProxies             []*net.IPNet
+	ipv4Prefix                 int
+	ipv6Prefix                 int
+	cidrLimits                 []CIDRLimit
+	keyExtractor               KeyExtractor
+	routePolicies              []RoutePolicy
+	globalLimit                *LimitData
+	costFunc                   CostFunc
+	dryRun                     bool
+	rejectionHandler           RejectionHandler
 	mutexes                    sync.Map
 }
 
+// Option customizes a RateLimiterMiddleware.
+type Option func(*RateLimiterMiddleware)
+
+// WithLegacyHeaders makes the middleware report the quota with the
+// X-RateLimit-Limit, X-RateLimit-Remaining and X-RateLimit-Reset headers,
+// where the reset is a Unix timestamp, instead of the RateLimit-* fields.
+func WithLegacyHeaders() Option {
+	return func(m *RateLimiterMiddleware) {
+		m.legacyHeaders = true
+	}
+}
+
 type ErrorResponse struct {
 	Message string `json:"message"`
 }
 
-func NewRateLimiterMiddleware(rateLimiter *ratelimiter.RateLimiter) *RateLimiterMiddleware {
+func NewRateLimiterMiddleware(rateLimiter *ratelimiter.RateLimiter, opts ...Option) *RateLimiterMiddleware {
 	defaultLimitRequestsIp := configs.GetLimitRequestsDefaultByIP()
 	defaultRequestLimitInSec := configs.GetRequestLimitInSec()
 	defaultBlockDuration := time.Duration(configs.GetBlockDuration()) * time.Second
-	return &RateLimiterMiddleware{
+	m := &RateLimiterMiddleware{
 		rateLimiter:                rateLimiter,
 		defaultLimitByIp:           defaultLimitRequestsIp,
 		defaultRequestLimitInSec:   defaultRequestLimitInSec,
 		defaultRequestLimitByToken: configs.GetLimitRequestsByToken(),
 		defaultBlockDuration:       defaultBlockDuration,
+		ipv4Prefix:                 defaultIPv4Prefix,
+		ipv6Prefix:                 defaultIPv6Prefix,
+		keyExtractor:               defaultKeyExtractor(),
+		rejectionHandler:           defaultRejectionResponder(),
 	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
 }
 
 func (m *RateLimiterMiddleware) Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		key := m.getKey(r)
-		mutex := m.getMutex(key)
-		mutex.Lock()
-		defer mutex.Unlock()
+		access, answered := m.checkAccess(w, r, key)
+		if answered || m.checkBan(w, r, key) {
+			return
+		}
+		if access == ratelimiter.AccessAllow {
+			next.ServeHTTP(w, r)
+			return
+		}
 
-		if m.isRequestLimited(key, w) {
+		cost := m.requestCost(r)
+		limitData, decision, refund, limited := m.evaluate(r, key, cost, w)
+		if limited {
 			return
 		}
 
+		// Dry-run limits do not hold requests back, so they are not queued. A
+		// request that gives up on its slot hands it back to the queue.
+		if decision.Delay > 0 && !m.isDryRun(limitData) && !m.waitInQueue(r, decision.Delay) {
+			refund()
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+
+		lease, err := m.rateLimiter.Acquire(limitData.Key, limitData)
+		if err != nil {
+			refund()
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		if lease == nil {
+			if !m.isDryRun(limitData) {
+				refund()
+				m.reject(w, r, Rejection{Status: http.StatusTooManyRequests, Reason: ReasonConcurrency})
+				return
+			}
+			m.recordDryRun(w, r, limitData, "concurrency limit")
+		} else {
+			defer lease.Release()
+		}
+
 		next.ServeHTTP(w, r)
 	})
 }
 
+// evaluate runs the limit check for key while holding the key's mutex, so the
+// request is only queued or served once the check is done.
+func (m *RateLimiterMiddleware) evaluate(r *http.Request, key string, cost int64, w http.ResponseWriter) (LimitData, ratelimiter.Decision, func(), bool) {
+	mutex := m.getMutex(key)
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	return m.isRequestLimited(r, key, cost, w)
+}
+
+// waitInQueue holds a leaky bucket request until its slot comes up. It returns
+// false when the request context is done before that.
+func (m *RateLimiterMiddleware) waitInQueue(r *http.Request, delay time.Duration) bool {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-r.Context().Done():
+		return false
+	}
+}
+
 // UpdateRateLimiter godoc
 // @Summary Update rate limiter settings
-// @Description update rate limiter settings for a specific key (ip or token)
+// @Description update the settings present in the body, for a specific key (ip or token) given in the path, the key and id are kept
 // @Tags rate limiter
 // @Accept  json
 // @Produce  json
+// @Param key path string true "Key whose settings are updated"
 // @Param body body LimitDataInput true "Update rate limiter settings"
-// @Success 200 {object} LimitDataInput "Successfully updated rate limiter settings"
-// @Failure 400 {object} ErrorResponse "Invalid request body"
+// @Success 200 {object} LimitData "Successfully updated rate limiter settings"
+// @Failure 400 {object} ValidationErrorResponse "Invalid request body or settings"
+// @Failure 401 {object} ErrorResponse "Missing or invalid admin credentials"
+// @Failure 403 {object} ErrorResponse "Admin credentials without the write role"
+// @Failure 404 {object} ErrorResponse "Key without settings"
 // @Failure 500 {object} ErrorResponse "Internal server error"
-// @Router /update-rate-limiter/ [put]
-// @Security ApiKeyAuth
+// @Router /update-rate-limiter/{key} [put]
+// @Security AdminAuth
 func (m *RateLimiterMiddleware) UpdateRateLimiter(writer http.ResponseWriter, request *http.Request) {
-	key := m.getKey(request)
-	limitData, err := m.rateLimiter.GetLimitData(key)
-	if err != nil {
-		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	key := strings.TrimPrefix(request.URL.Path, "/update-rate-limiter/")
+	if key == "" || key == request.URL.Path {
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 		return
 	}
 
-	decoder := json.NewDecoder(request.Body)
-	err = decoder.Decode(&limitData)
-	if err != nil {
-		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+	existing, err := m.rateLimiter.GetLimitData(key)
+	if err == ratelimiter.ErrNotFound {
+		http.Error(writer, http.StatusText(http.StatusNotFound), http.StatusNotFound)
 		return
 	}
-
-	err = m.rateLimiter.SetLimitData(key, limitData)
 	if err != nil {
 		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
 
-	writer.Header().Set("Content-Type", "application/json")
-	writer.WriteHeader(http.StatusOK)
-	_ = json.NewEncoder(writer).Encode(limitData)
+	// Same path as PatchLimit: the body can neither move the limit to another
+	// key nor replace its id.
+	var input ratelimiter.LimitDataInput
+	if err := decodeLimitBody(request, &input); err != nil {
+		writeLimitError(writer, err)
+		return
+	}
+	if input.Key != "" && input.Key != key {
+		writeLimitError(writer, keyChangedError())
+		return
+	}
+	existing.Key = key
+	m.saveLimit(writer, existing.Merge(input))
 }
 
 // GetAllRateLimiter godoc
-// @Summary Get all rate limiter settings
-// @Description get all rate limiter settings
+// @Summary List rate limiter settings
+// @Description list rate limiter settings one page at a time, pass the returned next_cursor to get the next page
 // @Tags rate limiter
 // @Accept  json
 // @Produce  json
-// @Success 200 {array} LimitData "Successfully retrieved all rate limiter settings"
+// @Param cursor query string false "Cursor returned by the previous page"
+// @Param page_size query int false "Number of settings per page (default 100, max 1000)"
+// @Success 200 {object} LimitDataPage "Successfully retrieved a page of rate limiter settings"
+// @Failure 400 {object} ErrorResponse "Invalid cursor or page size"
+// @Failure 401 {object} ErrorResponse "Missing or invalid admin credentials"
 // @Failure 500 {object} ErrorResponse "Internal server error"
 // @Router /get-all-rate-limiter [get]
+// @Security AdminAuth
 func (m *RateLimiterMiddleware) GetAllRateLimiter(writer http.ResponseWriter, request *http.Request) {
-	limitData, err := m.rateLimiter.GetAllLimitData()
+	pageSize, ok := parsePageSize(request)
+	if !ok {
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	page, err := m.rateLimiter.ListLimitData(request.URL.Query().Get("cursor"), pageSize)
+	if err == ratelimiter.ErrInvalidCursor {
+		http.Error(writer, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
@@ -120,14 +249,33 @@ func (m *RateLimiterMiddleware) GetAllRateLimiter(writer http.ResponseWriter, re
 
 	writer.Header().Set("Content-Type", "application/json")
 	writer.WriteHeader(http.StatusOK)
-	_ = json.NewEncoder(writer).Encode(limitData)
+	_ = json.NewEncoder(writer).Encode(page)
+}
+
+// parsePageSize returns the page_size query parameter, or the default page
+// size when it is missing. It is false when the page size is out of range.
+func parsePageSize(request *http.Request) (int64, bool) {
+	value := request.URL.Query().Get("page_size")
+	if value == "" {
+		return ratelimiter.DefaultPageSize, true
+	}
+	size, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || size < 1 || size > maxPageSize {
+		return 0, false
+	}
+	return size, true
 }
 
+// getKey runs the key extractor with the middleware reachable from the
+// request, which ClientIPKey needs, and falls back to the client IP.
 func (m *RateLimiterMiddleware) getKey(r *http.Request) string {
-	key := r.Header.Get("API_KEY")
-	if key == "" {
-		key = r.RemoteAddr
+	r = r.WithContext(context.WithValue(r.Context(), middlewareContextKey{}, m))
+	if m.keyExtractor != nil {
+		if key, ok := m.keyExtractor.Key(r); ok {
+			return key
+		}
 	}
+	key, _ := ClientIPKey().Key(r)
 	return key
 }
 
@@ -136,79 +284,157 @@ func (m *RateLimiterMiddleware) getMutex(key string) *sync.Mutex {
 	return mutex.(*sync.Mutex)
 }
 
-func (m *RateLimiterMiddleware) isRequestLimited(key string, w http.ResponseWriter) bool {
+// getLimitData returns the limit of the route policy matching the request, or
+// else the limit data of the key, which is created with the defaults on the
+// first request. The defaults get no id, so the clients seen do not each
+// leave an id index entry behind. A store failure other than ErrNotFound is
+// returned instead of applying the defaults. The returned Key is the key the
+// limit is counted under.
+func (m *RateLimiterMiddleware) getLimitData(r *http.Request, key string) (LimitData, error) {
+	if policy, ok := m.routePolicy(r); ok {
+		limitData := policy.Limit
+		limitData.Key = policy.key(key)
+		// The id names the policy in the blocks it sets.
+		if limitData.Id == "" {
+			limitData.Id = policy.name()
+		}
+		return limitData, nil
+	}
+
 	limitData, err := m.rateLimiter.GetLimitData(key)
-	if err != nil || limitData.Seconds == 0 {
+	if err != nil && err != ratelimiter.ErrNotFound {
+		return limitData, err
+	}
+	if err == ratelimiter.ErrNotFound || !limitData.IsConfigured() {
 		maxReq := m.defaultLimitByIp
 		if m.isToken(key) {
 			maxReq = m.defaultRequestLimitByToken
+		} else if cidrMaxReq, ok := m.cidrMaxRequests(key); ok {
+			maxReq = cidrMaxReq
 		}
 		limitData = ratelimiter.LimitData{
 			Key:           key,
 			Seconds:       m.defaultRequestLimitInSec,
 			MaxRequests:   maxReq,
 			BlockDuration: int64(m.defaultBlockDuration.Seconds()),
-			Id:            uuid.New().String(),
 		}
 		err = m.rateLimiter.SetLimitData(key, limitData)
 		if err != nil {
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-			return true
+			return limitData, err
 		}
 	}
+	limitData.Key = key
+	return limitData, nil
+}
 
-	limited, err := m.rateLimiter.Limit(key, limitData.MaxRequests, limitData.Seconds, limitData.BlockDuration)
+// isRequestLimited counts the request against the global cap and its limit.
+// For a request they allow it also returns a refund, which gives back what
+// the request was charged when it is refused later on, such as by the
+// concurrency limit.
+func (m *RateLimiterMiddleware) isRequestLimited(r *http.Request, key string, cost int64, w http.ResponseWriter) (LimitData, ratelimiter.Decision, func(), bool) {
+	limitData, err := m.getLimitData(r, key)
 	if err != nil {
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-		return true
+		return limitData, ratelimiter.Decision{}, nil, true
 	}
 
-	if limited {
-		w.WriteHeader(http.StatusTooManyRequests)
-		m.writeErrorResponse(w, "You have reached the maximum number of requests or actions allowed within a certain time frame")
-		return true
+	// The global cap is evaluated first, so a request it refuses does not
+	// spend the quota of the route. What it charged is refunded when the route
+	// limit refuses the request instead.
+	var globalData LimitData
+	var globalDecision ratelimiter.Decision
+	if m.globalLimit != nil {
+		globalData = *m.globalLimit
+		globalData.Key = "global:" + key
+		if globalData.Id == "" {
+			globalData.Id = "global"
+		}
+		globalDecision, err = m.rateLimiter.EvaluateCost(globalData.Key, globalData, cost, m.isDryRun(globalData))
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return limitData, ratelimiter.Decision{}, nil, true
+		}
+		if globalDecision.Limited && m.isDryRun(globalData) {
+			m.recordDryRun(w, r, globalData, "global limit")
+			globalDecision.Limited = false
+		}
+		if globalDecision.Limited {
+			return limitData, globalDecision, nil, m.rateLimited(w, r, globalData, globalDecision)
+		}
 	}
-
-	return false
-}
-
-func (m *RateLimiterMiddleware) getTotalReqLimit(key string, w http.ResponseWriter) int64 {
-	totalReqLimit := m.defaultLimitByIp
-	if key != "" {
-		totalReqLimit = configs.GetLimitRequestsByToken()
-		if !m.isTokenValid(key, w) {
-			return 0
+	refundGlobal := func() {
+		if m.globalLimit == nil {
+			return
+		}
+		if err := m.rateLimiter.Refund(globalData.Key, globalData, globalDecision); err != nil {
+			log.Printf("Failed to refund the global limit of key %s: %v", key, err)
 		}
 	}
-	return totalReqLimit
-}
 
-func (m *RateLimiterMiddleware) isTokenValid(key string, w http.ResponseWriter) bool {
-	token, err := jwt.ParseWithClaims(key, &jwt.StandardClaims{}, func(token *jwt.Token) (interface{}, error) {
-		return []byte(configs.GetSecretKey()), nil
-	})
+	decision, err := m.rateLimiter.EvaluateCost(limitData.Key, limitData, cost, m.isDryRun(limitData))
+	if err != nil {
+		refundGlobal()
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return limitData, ratelimiter.Decision{}, nil, true
+	}
+	if decision.Limited && m.isDryRun(limitData) {
+		m.recordDryRun(w, r, limitData, "request limit")
+		decision.Limited = false
+	}
+	if decision.Limited {
+		refundGlobal()
+		return limitData, decision, nil, m.rateLimited(w, r, limitData, decision)
+	}
+	refund := func() {
+		refundGlobal()
+		if err := m.rateLimiter.Refund(limitData.Key, limitData, decision); err != nil {
+			log.Printf("Failed to refund the limit of key %s: %v", key, err)
+		}
+	}
 
-	if err != nil || !token.Valid {
-		w.WriteHeader(http.StatusUnauthorized)
-		m.writeErrorResponse(w, "Invalid token")
-		return false
+	// The global cap is reported instead of the route limit when it has less
+	// left.
+	if m.globalLimit != nil && globalDecision.Remaining < decision.Remaining {
+		return limitData, decision, refund, m.rateLimited(w, r, globalData, globalDecision)
 	}
+	return limitData, decision, refund, m.rateLimited(w, r, limitData, decision)
+}
 
-	claims := token.Claims.(*jwt.StandardClaims)
-	if time.Unix(claims.ExpiresAt, 0).Before(time.Now()) {
-		w.WriteHeader(http.StatusUnauthorized)
-		m.writeErrorResponse(w, "Token expired")
+// rateLimited sets the quota headers of the limit data that decided the
+// request and refuses the request when it was limited.
+func (m *RateLimiterMiddleware) rateLimited(w http.ResponseWriter, r *http.Request, data LimitData, decision ratelimiter.Decision) bool {
+	m.setRateLimitHeaders(w, data, decision)
+	if !decision.Limited {
 		return false
 	}
-
+	m.reject(w, r, Rejection{
+		Status:     http.StatusTooManyRequests,
+		Reason:     ReasonRateLimited,
+		Limit:      decision.Limit,
+		Remaining:  decision.Remaining,
+		RetryAfter: decision.RetryAfter,
+	})
 	return true
 }
 
-func (m *RateLimiterMiddleware) writeErrorResponse(w http.ResponseWriter, message string) {
+// reject answers a refused request through the rejection handler. gRPC calls
+// only record the rejection, which their interceptor turns into a status.
+func (m *RateLimiterMiddleware) reject(w http.ResponseWriter, r *http.Request, rejection Rejection) {
+	if response, ok := w.(*grpcResponse); ok {
+		response.reject(rejection, m.rejectionMessage(r, rejection))
+		return
+	}
+	m.rejectionHandler.Reject(w, r, rejection)
+}
+
+// writeErrorResponse writes a JSON error. The Content-Type is set before the
+// status, as headers set after it are not sent.
+func writeErrorResponse(w http.ResponseWriter, status int, message string) {
 	response := ErrorResponse{
 		Message: message,
 	}
 	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
 	_ = json.NewEncoder(w).Encode(response)
 }
 
@@ -0,0 +1,321 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Reasons a request is refused for, which pick the message of the rejection.
+const (
+	ReasonRateLimited  = "rate_limited"
+	ReasonConcurrency  = "concurrency_limited"
+	ReasonAccessDenied = "access_denied"
+	ReasonBanned       = "banned"
+)
+
+const (
+	problemJSON     = "application/problem+json"
+	defaultLanguage = "en"
+)
+
+// rejectionMediaTypes are the formats a rejection can be written in, the
+// first one being used when the client states no preference.
+var rejectionMediaTypes = []string{"application/json", problemJSON, "text/plain"}
+
+// Rejection describes a request the middleware refuses. Limit and Remaining
+// are only set when the request is refused by a limit, and RetryAfter when it
+// is refused by a limit or a ban that expires.
+type Rejection struct {
+	Status     int
+	Reason     string
+	Limit      int64
+	Remaining  int64
+	RetryAfter time.Duration
+}
+
+// RejectionHandler writes the response of a refused request. The quota
+// headers are already set when it is called.
+type RejectionHandler interface {
+	Reject(w http.ResponseWriter, r *http.Request, rejection Rejection)
+}
+
+// RejectionHandlerFunc adapts an ordinary function to a RejectionHandler.
+type RejectionHandlerFunc func(w http.ResponseWriter, r *http.Request, rejection Rejection)
+
+func (f RejectionHandlerFunc) Reject(w http.ResponseWriter, r *http.Request, rejection Rejection) {
+	f(w, r, rejection)
+}
+
+// WithRejectionHandler replaces the handler that writes the responses of
+// refused requests.
+func WithRejectionHandler(handler RejectionHandler) Option {
+	return func(m *RateLimiterMiddleware) {
+		m.rejectionHandler = handler
+	}
+}
+
+// ProblemDetails is an RFC 9457 problem+json body, extended with the quota of
+// the limit that refused the request.
+type ProblemDetails struct {
+	Type       string `json:"type"`
+	Title      string `json:"title"`
+	Status     int    `json:"status"`
+	Detail     string `json:"detail"`
+	Limit      *int64 `json:"limit,omitempty"`
+	Remaining  *int64 `json:"remaining,omitempty"`
+	RetryAfter *int64 `json:"retry_after,omitempty"`
+}
+
+// defaultMessages are the built-in messages of each reason by language.
+var defaultMessages = map[string]map[string]string{
+	"en": {
+		ReasonRateLimited:  "You have reached the maximum number of requests or actions allowed within a certain time frame",
+		ReasonConcurrency:  "You have reached the maximum number of simultaneous requests allowed",
+		ReasonAccessDenied: "Access denied",
+		ReasonBanned:       "Access suspended",
+	},
+	"pt": {
+		ReasonRateLimited:  "Você atingiu o número máximo de requisições ou ações permitidas em um determinado período",
+		ReasonConcurrency:  "Você atingiu o número máximo de requisições simultâneas permitidas",
+		ReasonAccessDenied: "Acesso negado",
+		ReasonBanned:       "Acesso suspenso",
+	},
+}
+
+// RejectionResponder is the default RejectionHandler. It answers in JSON,
+// problem+json or plain text as the Accept header asks, with the message of
+// the reason in the language the Accept-Language header asks for.
+type RejectionResponder struct {
+	status   int
+	language string
+	messages map[string]map[string]*template.Template
+}
+
+// NewRejectionResponder returns a responder that refuses requests over a limit
+// with status, or 429 when it is zero, and falls back to language when the
+// client accepts none of the known ones. messages adds or overrides, by
+// language and then reason, the built-in English and Portuguese messages. They
+// are text/template templates that can use {{.Limit}}, {{.Remaining}} and
+// {{.RetryAfter}}, the last one in seconds. Messages under an empty language
+// are in the default language.
+func NewRejectionResponder(status int, language string, messages map[string]map[string]string) (*RejectionResponder, error) {
+	if status == 0 {
+		status = http.StatusTooManyRequests
+	}
+	if status < 400 || status > 599 {
+		return nil, fmt.Errorf("invalid rejection status %d: want a 4xx or 5xx status", status)
+	}
+	if language == "" {
+		language = defaultLanguage
+	}
+
+	responder := &RejectionResponder{
+		status:   status,
+		language: strings.ToLower(language),
+		messages: map[string]map[string]*template.Template{},
+	}
+	for _, set := range []map[string]map[string]string{defaultMessages, messages} {
+		for language, reasons := range set {
+			language = strings.ToLower(language)
+			if language == "" {
+				language = responder.language
+			}
+			if responder.messages[language] == nil {
+				responder.messages[language] = map[string]*template.Template{}
+			}
+			for reason, text := range reasons {
+				tmpl, err := template.New(reason).Option("missingkey=error").Parse(text)
+				if err != nil {
+					return nil, fmt.Errorf("invalid %s message for %s: %v", language, reason, err)
+				}
+				responder.messages[language][reason] = tmpl
+			}
+		}
+	}
+	if responder.messages[responder.language] == nil {
+		return nil, fmt.Errorf("no messages for the default language %q", language)
+	}
+	return responder, nil
+}
+
+// defaultRejectionResponder returns the responder with the built-in messages.
+func defaultRejectionResponder() *RejectionResponder {
+	responder, err := NewRejectionResponder(0, defaultLanguage, nil)
+	if err != nil {
+		panic(err)
+	}
+	return responder
+}
+
+func (h *RejectionResponder) Reject(w http.ResponseWriter, r *http.Request, rejection Rejection) {
+	status := rejection.Status
+	if rejection.Reason == ReasonRateLimited || rejection.Reason == ReasonConcurrency {
+		status = h.status
+	}
+	language := h.negotiateLanguage(r.Header.Get("Accept-Language"))
+	message := h.message(language, rejection)
+
+	header := w.Header()
+	header.Set("Content-Language", language)
+	switch negotiate(r.Header.Get("Accept"), rejectionMediaTypes) {
+	case problemJSON:
+		problem := ProblemDetails{
+			Type:   "about:blank",
+			Title:  http.StatusText(status),
+			Status: status,
+			Detail: message,
+		}
+		if rejection.Limit > 0 {
+			retryAfter := seconds(rejection.RetryAfter)
+			problem.Limit, problem.Remaining, problem.RetryAfter = &rejection.Limit, &rejection.Remaining, &retryAfter
+		}
+		header.Set("Content-Type", problemJSON)
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(problem)
+	case "text/plain":
+		header.Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(message + "\n"))
+	default:
+		header.Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(ErrorResponse{Message: message})
+	}
+}
+
+// message renders the message of the rejection reason in language, or in the
+// default language when language has no message for it.
+func (h *RejectionResponder) message(language string, rejection Rejection) string {
+	tmpl := h.messages[language][rejection.Reason]
+	if tmpl == nil {
+		tmpl = h.messages[h.language][rejection.Reason]
+	}
+	if tmpl == nil {
+		return http.StatusText(rejection.Status)
+	}
+
+	var message bytes.Buffer
+	err := tmpl.Execute(&message, struct {
+		Limit      int64
+		Remaining  int64
+		RetryAfter int64
+	}{rejection.Limit, rejection.Remaining, seconds(rejection.RetryAfter)})
+	if err != nil {
+		return http.StatusText(rejection.Status)
+	}
+	return message.String()
+}
+
+// negotiateLanguage returns the language the client prefers among the ones
+// there are messages for. A regional tag such as pt-BR also matches pt.
+func (h *RejectionResponder) negotiateLanguage(acceptLanguage string) string {
+	for _, tag := range preferences(acceptLanguage) {
+		tag = strings.ToLower(tag)
+		if tag == "*" {
+			break
+		}
+		if _, ok := h.messages[tag]; ok {
+			return tag
+		}
+		if base, _, found := strings.Cut(tag, "-"); found {
+			if _, ok := h.messages[base]; ok {
+				return base
+			}
+		}
+	}
+	return h.language
+}
+
+// negotiate returns the offer the Accept header ranks highest, preferring the
+// earlier offers on ties, or the first offer when the client accepts none.
+func negotiate(accept string, offers []string) string {
+	if strings.TrimSpace(accept) == "" {
+		return offers[0]
+	}
+
+	best, bestQuality := offers[0], 0.0
+	for _, offer := range offers {
+		quality, specificity := 0.0, -1
+		for _, entry := range strings.Split(accept, ",") {
+			mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(entry))
+			if err != nil {
+				continue
+			}
+			matched, rank := matchMediaType(mediaType, offer)
+			if !matched || rank < specificity {
+				continue
+			}
+			specificity, quality = rank, parseQuality(params["q"])
+		}
+		if quality > bestQuality {
+			best, bestQuality = offer, quality
+		}
+	}
+	return best
+}
+
+// matchMediaType reports whether the media range matches the offer and how
+// specific the range is.
+func matchMediaType(mediaRange string, offer string) (bool, int) {
+	switch {
+	case mediaRange == offer:
+		return true, 2
+	case mediaRange == "*/*":
+		return true, 0
+	case strings.HasSuffix(mediaRange, "/*"):
+		return strings.HasPrefix(offer, strings.TrimSuffix(mediaRange, "*")), 1
+	}
+	return false, 0
+}
+
+// preferences returns the values of a header like Accept-Language from the
+// highest quality to the lowest, leaving out the ones with quality zero.
+func preferences(header string) []string {
+	type preference struct {
+		value   string
+		quality float64
+	}
+	var list []preference
+	for _, entry := range strings.Split(header, ",") {
+		value, params, _ := strings.Cut(strings.TrimSpace(entry), ";")
+		if value = strings.TrimSpace(value); value == "" {
+			continue
+		}
+		quality := 1.0
+		if q, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+			quality = parseQuality(q)
+		}
+		if quality > 0 {
+			list = append(list, preference{value, quality})
+		}
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].quality > list[j].quality
+	})
+
+	values := make([]string, len(list))
+	for i, preference := range list {
+		values[i] = preference.value
+	}
+	return values
+}
+
+// parseQuality parses a q parameter, which is 1 when it is missing.
+func parseQuality(q string) float64 {
+	if q == "" {
+		return 1
+	}
+	quality, err := strconv.ParseFloat(q, 64)
+	if err != nil || quality < 0 || quality > 1 {
+		return 0
+	}
+	return quality
+}
@@ -0,0 +1,120 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"ratelimiter/configs"
+	"ratelimiter/pkg/ratelimiter"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{"", "application/json"},
+		{"*/*", "application/json"},
+		{"application/problem+json", problemJSON},
+		{"text/*", "text/plain"},
+		{"application/json;q=0.5, application/problem+json", problemJSON},
+		{"text/plain, */*;q=0.1", "text/plain"},
+		{"image/png", "application/json"},
+		{"application/*;q=0.2, application/json;q=0", problemJSON},
+	}
+	for _, test := range tests {
+		if got := negotiate(test.accept, rejectionMediaTypes); got != test.want {
+			t.Errorf("negotiate(%q) = %q, want %q", test.accept, got, test.want)
+		}
+	}
+}
+
+func TestRejectionResponder(t *testing.T) {
+	responder, err := NewRejectionResponder(http.StatusServiceUnavailable, "en", map[string]map[string]string{
+		"en": {ReasonRateLimited: "Limit of {{.Limit}} reached, {{.Remaining}} left, retry in {{.RetryAfter}}s"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create the rejection responder: %v", err)
+	}
+	rejection := Rejection{Status: http.StatusTooManyRequests, Reason: ReasonRateLimited, Limit: 5, RetryAfter: 1500 * time.Millisecond}
+	reject := func(accept string, acceptLanguage string, rejection Rejection) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/home", nil)
+		req.Header.Set("Accept", accept)
+		req.Header.Set("Accept-Language", acceptLanguage)
+		rr := httptest.NewRecorder()
+		responder.Reject(rr, req, rejection)
+		return rr
+	}
+
+	rr := reject("", "", rejection)
+	if rr.Code != http.StatusServiceUnavailable || rr.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("json rejection returned %v with Content-Type %q", rr.Code, rr.Header().Get("Content-Type"))
+	}
+	var response ErrorResponse
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil || response.Message != "Limit of 5 reached, 0 left, retry in 2s" {
+		t.Errorf("json rejection message = %q, %v", response.Message, err)
+	}
+
+	rr = reject("application/problem+json", "", rejection)
+	var problem ProblemDetails
+	if err := json.NewDecoder(rr.Body).Decode(&problem); err != nil {
+		t.Fatalf("Failed to decode the problem details: %v", err)
+	}
+	if rr.Header().Get("Content-Type") != problemJSON || problem.Status != http.StatusServiceUnavailable ||
+		problem.Title != "Service Unavailable" || *problem.Limit != 5 || *problem.Remaining != 0 || *problem.RetryAfter != 2 {
+		t.Errorf("problem rejection returned %q %+v", rr.Header().Get("Content-Type"), problem)
+	}
+
+	rr = reject("text/plain", "pt-BR, en;q=0.8", rejection)
+	if rr.Header().Get("Content-Language") != "pt" || !strings.HasPrefix(rr.Body.String(), "Você atingiu") {
+		t.Errorf("localized rejection returned %q %q", rr.Header().Get("Content-Language"), rr.Body.String())
+	}
+
+	// The configured status only replaces the status of limit rejections.
+	rr = reject("text/plain", "fr", Rejection{Status: http.StatusForbidden, Reason: ReasonAccessDenied})
+	if rr.Code != http.StatusForbidden || rr.Body.String() != "Access denied\n" {
+		t.Errorf("access rejection returned %v %q", rr.Code, rr.Body.String())
+	}
+
+	for _, status := range []int{200, 600} {
+		if _, err := NewRejectionResponder(status, "en", nil); err == nil {
+			t.Errorf("NewRejectionResponder(%d) returned no error", status)
+		}
+	}
+	if _, err := NewRejectionResponder(0, "en", map[string]map[string]string{"en": {ReasonRateLimited: "{{.Limit"}}); err == nil {
+		t.Errorf("NewRejectionResponder with an invalid template returned no error")
+	}
+}
+
+func TestWithRejectionHandler(t *testing.T) {
+	configs.LoadConfig()
+	store := ratelimiter.NewMemoryStore()
+	defer store.Close()
+	var rejections []Rejection
+	middleware := NewRateLimiterMiddleware(ratelimiter.NewRateLimiter(store),
+		WithRoutePolicies(RoutePolicy{Pattern: "/home", Limit: LimitData{Seconds: 60, MaxRequests: 1}}),
+		WithRejectionHandler(RejectionHandlerFunc(func(w http.ResponseWriter, r *http.Request, rejection Rejection) {
+			rejections = append(rejections, rejection)
+			w.WriteHeader(http.StatusTeapot)
+		})),
+	)
+	handler := middleware.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	}))
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest("GET", "/home", nil)
+		req.RemoteAddr = "192.0.2.8:1234"
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if i == 1 && rr.Code != http.StatusTeapot {
+			t.Errorf("limited request returned wrong status code: got %v want %v", rr.Code, http.StatusTeapot)
+		}
+	}
+	if len(rejections) != 1 || rejections[0].Reason != ReasonRateLimited || rejections[0].Limit != 1 {
+		t.Errorf("rejections = %+v", rejections)
+	}
+}
@@ -0,0 +1,127 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// RoutePolicy limits the requests whose method and path match it. Pattern is
+// an exact path or a path.Match pattern such as /orders/*, and an empty
+// Method matches every method. Each policy counts in its own namespace, so a
+// client has an independent quota on every route. Cost, when set, is how many
+// units of the quota each request to the route consumes.
+type RoutePolicy struct {
+	Method  string
+	Pattern string
+	Limit   LimitData
+	Cost    int64
+}
+
+// matches reports whether the policy applies to the request.
+func (p RoutePolicy) matches(r *http.Request) bool {
+	if p.Method != "" && !strings.EqualFold(p.Method, r.Method) {
+		return false
+	}
+	if p.Pattern == r.URL.Path {
+		return true
+	}
+	matched, err := path.Match(p.Pattern, r.URL.Path)
+	return err == nil && matched
+}
+
+// name identifies the policy, such as "route:POST /orders/*".
+func (p RoutePolicy) name() string {
+	method := strings.ToUpper(p.Method)
+	if method == "" {
+		method = "*"
+	}
+	return "route:" + method + " " + p.Pattern
+}
+
+// key returns the key the policy counts the requests of a client under.
+func (p RoutePolicy) key(clientKey string) string {
+	return p.name() + ":" + clientKey
+}
+
+// WithRoutePolicies limits the routes matching each policy with its own limit
+// instead of the per-key limit data. The first matching policy applies, and
+// requests matching none keep the per-key limit data.
+func WithRoutePolicies(policies ...RoutePolicy) Option {
+	return func(m *RateLimiterMiddleware) {
+		m.routePolicies = append(m.routePolicies, policies...)
+	}
+}
+
+// WithGlobalLimit caps the requests of a client across all routes, on top of
+// the limit of the route. The cap is only charged for requests the route
+// allows.
+func WithGlobalLimit(limit LimitData) Option {
+	return func(m *RateLimiterMiddleware) {
+		m.globalLimit = &limit
+	}
+}
+
+// ParseRoutePolicies parses a comma separated list of
+// "METHOD /pattern=max_requests/seconds" entries, where the method may be left
+// out to match every method. Blocking follows blockDuration.
+func ParseRoutePolicies(list string, blockDuration int64) ([]RoutePolicy, error) {
+	var policies []RoutePolicy
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		route, quota, found := strings.Cut(entry, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid route policy %q: want METHOD /pattern=max_requests/seconds", entry)
+		}
+		limit, err := ParseQuota(quota, blockDuration)
+		if err != nil {
+			return nil, fmt.Errorf("invalid route policy %q: %v", entry, err)
+		}
+
+		policy := RoutePolicy{Pattern: strings.TrimSpace(route), Limit: limit}
+		if method, pattern, found := strings.Cut(policy.Pattern, " "); found {
+			policy.Method, policy.Pattern = method, strings.TrimSpace(pattern)
+		}
+		if !strings.HasPrefix(policy.Pattern, "/") {
+			return nil, fmt.Errorf("invalid route policy %q: the pattern must start with /", entry)
+		}
+		if _, err := path.Match(policy.Pattern, "/"); err != nil {
+			return nil, fmt.Errorf("invalid route policy %q: %v", entry, err)
+		}
+		policies = append(policies, policy)
+	}
+	return policies, nil
+}
+
+// ParseQuota parses a "max_requests/seconds" fixed window quota.
+func ParseQuota(quota string, blockDuration int64) (LimitData, error) {
+	maxValue, secondsValue, found := strings.Cut(strings.TrimSpace(quota), "/")
+	if !found {
+		return LimitData{}, fmt.Errorf("invalid quota %q: want max_requests/seconds", quota)
+	}
+	maxRequests, err := strconv.ParseInt(strings.TrimSpace(maxValue), 10, 64)
+	if err != nil || maxRequests < 1 {
+		return LimitData{}, fmt.Errorf("invalid quota %q: max_requests must be a positive integer", quota)
+	}
+	seconds, err := strconv.ParseInt(strings.TrimSpace(secondsValue), 10, 64)
+	if err != nil || seconds < 1 {
+		return LimitData{}, fmt.Errorf("invalid quota %q: seconds must be a positive integer", quota)
+	}
+	return LimitData{Seconds: seconds, MaxRequests: maxRequests, BlockDuration: blockDuration}, nil
+}
+
+// routePolicy returns the first policy matching the request.
+func (m *RateLimiterMiddleware) routePolicy(r *http.Request) (RoutePolicy, bool) {
+	for _, policy := range m.routePolicies {
+		if policy.matches(r) {
+			return policy, true
+		}
+	}
+	return RoutePolicy{}, false
+}
@@ -0,0 +1,167 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"ratelimiter/configs"
+	"ratelimiter/pkg/ratelimiter"
+	"testing"
+)
+
+func TestParseRoutePolicies(t *testing.T) {
+	policies, err := ParseRoutePolicies("POST /orders=10/60, /catalog/*=1000/60", 30)
+	if err != nil {
+		t.Fatalf("Failed to parse route policies: %v", err)
+	}
+	want := []RoutePolicy{
+		{Method: "POST", Pattern: "/orders", Limit: LimitData{Seconds: 60, MaxRequests: 10, BlockDuration: 30}},
+		{Pattern: "/catalog/*", Limit: LimitData{Seconds: 60, MaxRequests: 1000, BlockDuration: 30}},
+	}
+	if len(policies) != len(want) {
+		t.Fatalf("got %d route policies, want %d", len(policies), len(want))
+	}
+	for i := range want {
+		if policies[i] != want[i] {
+			t.Errorf("route policy %d = %+v, want %+v", i, policies[i], want[i])
+		}
+	}
+
+	for _, list := range []string{"POST /orders", "POST /orders=10", "POST /orders=0/60", "POST orders=10/60", "GET /[=10/60"} {
+		if _, err := ParseRoutePolicies(list, 30); err == nil {
+			t.Errorf("ParseRoutePolicies(%q) returned no error", list)
+		}
+	}
+}
+
+func TestRoutePolicies(t *testing.T) {
+	configs.LoadConfig()
+	newHandler := func(opts ...Option) http.Handler {
+		store := ratelimiter.NewMemoryStore()
+		t.Cleanup(store.Close)
+		middleware := NewRateLimiterMiddleware(ratelimiter.NewRateLimiter(store), opts...)
+		return middleware.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("OK"))
+		}))
+	}
+	serve := func(handler http.Handler, method string, target string) int {
+		req := httptest.NewRequest(method, target, nil)
+		req.RemoteAddr = "192.0.2.4:1234"
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	policies := []RoutePolicy{
+		{Method: "POST", Pattern: "/orders", Limit: LimitData{Seconds: 60, MaxRequests: 1}},
+		{Method: "GET", Pattern: "/catalog/*", Limit: LimitData{Seconds: 60, MaxRequests: 2}},
+	}
+
+	t.Run("keeps an independent quota per route", func(t *testing.T) {
+		handler := newHandler(WithRoutePolicies(policies...))
+		requests := []struct {
+			method string
+			target string
+			want   int
+		}{
+			{"POST", "/orders", http.StatusOK},
+			{"POST", "/orders", http.StatusTooManyRequests},
+			{"GET", "/catalog/1", http.StatusOK},
+			{"GET", "/catalog/2", http.StatusOK},
+			{"GET", "/catalog/3", http.StatusTooManyRequests},
+			// GET /orders matches no policy and keeps the per-key limit.
+			{"GET", "/orders", http.StatusOK},
+		}
+		for _, request := range requests {
+			if status := serve(handler, request.method, request.target); status != request.want {
+				t.Errorf("%s %s returned wrong status code: got %v want %v", request.method, request.target, status, request.want)
+			}
+		}
+	})
+
+	t.Run("caps the client across routes", func(t *testing.T) {
+		handler := newHandler(WithRoutePolicies(policies...), WithGlobalLimit(LimitData{Seconds: 60, MaxRequests: 2}))
+		requests := []struct {
+			method string
+			target string
+			want   int
+		}{
+			{"POST", "/orders", http.StatusOK},
+			{"GET", "/catalog/1", http.StatusOK},
+			{"GET", "/catalog/2", http.StatusTooManyRequests},
+		}
+		for _, request := range requests {
+			if status := serve(handler, request.method, request.target); status != request.want {
+				t.Errorf("%s %s returned wrong status code: got %v want %v", request.method, request.target, status, request.want)
+			}
+		}
+	})
+	t.Run("refunds the global cap when the route refuses", func(t *testing.T) {
+		handler := newHandler(WithRoutePolicies(policies...), WithGlobalLimit(LimitData{Seconds: 60, MaxRequests: 2}))
+		requests := []struct {
+			method string
+			target string
+			want   int
+		}{
+			{"POST", "/orders", http.StatusOK},
+			{"POST", "/orders", http.StatusTooManyRequests},
+			{"GET", "/catalog/1", http.StatusOK},
+			{"GET", "/catalog/2", http.StatusTooManyRequests},
+		}
+		for _, request := range requests {
+			if status := serve(handler, request.method, request.target); status != request.want {
+				t.Errorf("%s %s returned wrong status code: got %v want %v", request.method, request.target, status, request.want)
+			}
+		}
+	})
+
+	t.Run("does not charge the route when the global cap refuses", func(t *testing.T) {
+		store := ratelimiter.NewMemoryStore()
+		t.Cleanup(store.Close)
+		next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("OK"))
+		})
+		capped := NewRateLimiterMiddleware(ratelimiter.NewRateLimiter(store), WithRoutePolicies(policies...), WithGlobalLimit(LimitData{Seconds: 60, MaxRequests: 1})).Middleware(next)
+		uncapped := NewRateLimiterMiddleware(ratelimiter.NewRateLimiter(store), WithRoutePolicies(policies...)).Middleware(next)
+
+		if status := serve(capped, "GET", "/catalog/1"); status != http.StatusOK {
+			t.Errorf("GET /catalog/1 returned wrong status code: got %v want %v", status, http.StatusOK)
+		}
+		if status := serve(capped, "GET", "/catalog/2"); status != http.StatusTooManyRequests {
+			t.Errorf("GET /catalog/2 returned wrong status code: got %v want %v", status, http.StatusTooManyRequests)
+		}
+		// The route quota of two still has room for the request the global cap refused.
+		if status := serve(uncapped, "GET", "/catalog/3"); status != http.StatusOK {
+			t.Errorf("GET /catalog/3 returned wrong status code: got %v want %v", status, http.StatusOK)
+		}
+	})
+
+	t.Run("refunds both limits when the concurrency limit refuses", func(t *testing.T) {
+		store := ratelimiter.NewMemoryStore()
+		t.Cleanup(store.Close)
+		entered := make(chan struct{})
+		release := make(chan struct{})
+		handler := NewRateLimiterMiddleware(ratelimiter.NewRateLimiter(store),
+			WithRoutePolicies(RoutePolicy{Pattern: "/reports", Limit: LimitData{Seconds: 60, MaxRequests: 2, MaxInFlight: 1}}),
+			WithGlobalLimit(LimitData{Seconds: 60, MaxRequests: 2}),
+		).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("hold") != "" {
+				entered <- struct{}{}
+				<-release
+			}
+		}))
+
+		done := make(chan int)
+		go func() { done <- serve(handler, "GET", "/reports?hold=1") }()
+		<-entered
+		if status := serve(handler, "GET", "/reports"); status != http.StatusTooManyRequests {
+			t.Errorf("GET /reports while one is running returned wrong status code: got %v want %v", status, http.StatusTooManyRequests)
+		}
+		close(release)
+		<-done
+
+		// Neither quota of two counts the request the concurrency limit refused.
+		if status := serve(handler, "GET", "/reports"); status != http.StatusOK {
+			t.Errorf("GET /reports returned wrong status code: got %v want %v", status, http.StatusOK)
+		}
+	})
+}
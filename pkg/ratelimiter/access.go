@@ -0,0 +1,230 @@
+package ratelimiter
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	AccessAllow = "allow"
+	AccessDeny  = "deny"
+
+	MatchKey    = "key"
+	MatchCIDR   = "cidr"
+	MatchPrefix = "prefix"
+)
+
+// accessListRefresh is how often the local copy of the access lists checks the
+// store for changes made by other instances.
+const accessListRefresh = 5 * time.Second
+
+// ErrInvalidAccessRule is returned when an access rule has an unknown list or
+// match, or a value that does not fit its match.
+var ErrInvalidAccessRule = errors.New("invalid access rule")
+
+// AccessRule puts the requests matching it on the allow list, where they are
+// never limited, or on the deny list, where they are always refused. Match
+// says whether Value is an exact key, a CIDR the client IP must be in, or a
+// prefix of the key, such as the prefix of a family of API keys.
+type AccessRule struct {
+	List  string `json:"list" enums:"allow,deny"`
+	Match string `json:"match" enums:"key,cidr,prefix"`
+	Value string `json:"value"`
+}
+
+// Validate checks the rule and returns it with a CIDR value in canonical form.
+func (a AccessRule) Validate() (AccessRule, error) {
+	if a.List != AccessAllow && a.List != AccessDeny {
+		return a, fmt.Errorf("%w: list must be %s or %s", ErrInvalidAccessRule, AccessAllow, AccessDeny)
+	}
+	if a.Value == "" {
+		return a, fmt.Errorf("%w: value is required", ErrInvalidAccessRule)
+	}
+
+	switch a.Match {
+	case MatchKey, MatchPrefix:
+	case MatchCIDR:
+		_, network, err := net.ParseCIDR(a.Value)
+		if err != nil {
+			return a, fmt.Errorf("%w: %v", ErrInvalidAccessRule, err)
+		}
+		a.Value = network.String()
+	default:
+		return a, fmt.Errorf("%w: match must be %s, %s or %s", ErrInvalidAccessRule, MatchKey, MatchCIDR, MatchPrefix)
+	}
+	return a, nil
+}
+
+// id identifies the rule in the store.
+func (a AccessRule) id() string {
+	return a.List + "|" + a.Match + "|" + a.Value
+}
+
+// accessList is the local copy of the access lists. Requests match against an
+// immutable snapshot, so they never wait on each other, and a single request
+// at a time builds a new one when the version in the store changes.
+type accessList struct {
+	snapshot atomic.Pointer[accessSnapshot]
+	// checkedAt is the Unix time, in nanoseconds, of the last refresh, failed
+	// or not, so a store outage is retried once per refresh interval.
+	checkedAt atomic.Int64
+	stale     atomic.Bool
+	refresh   sync.Mutex
+}
+
+// accessSnapshot is a version of the access lists, indexed for matching.
+type accessSnapshot struct {
+	version  int64
+	keys     map[string]string
+	networks []accessNetwork
+	prefixes []accessPrefix
+}
+
+type accessNetwork struct {
+	list    string
+	network *net.IPNet
+}
+
+type accessPrefix struct {
+	list   string
+	prefix string
+}
+
+func newAccessSnapshot(rules []AccessRule, version int64) *accessSnapshot {
+	l := &accessSnapshot{version: version, keys: map[string]string{}}
+	for _, rule := range rules {
+		switch rule.Match {
+		case MatchKey:
+			if l.keys[rule.Value] != AccessDeny {
+				l.keys[rule.Value] = rule.List
+			}
+		case MatchCIDR:
+			_, network, err := net.ParseCIDR(rule.Value)
+			if err != nil {
+				continue
+			}
+			l.networks = append(l.networks, accessNetwork{list: rule.List, network: network})
+		case MatchPrefix:
+			l.prefixes = append(l.prefixes, accessPrefix{list: rule.List, prefix: rule.Value})
+		}
+	}
+	return l
+}
+
+// match returns the list the key or client IP is on. A match on the deny list
+// wins over one on the allow list.
+func (l *accessSnapshot) match(key string, ip net.IP) string {
+	result := l.keys[key]
+	if result == AccessDeny {
+		return result
+	}
+	for _, entry := range l.networks {
+		if ip != nil && entry.network.Contains(ip) {
+			if entry.list == AccessDeny {
+				return AccessDeny
+			}
+			result = entry.list
+		}
+	}
+	for _, entry := range l.prefixes {
+		if strings.HasPrefix(key, entry.prefix) {
+			if entry.list == AccessDeny {
+				return AccessDeny
+			}
+			result = entry.list
+		}
+	}
+	return result
+}
+
+// CheckAccess returns AccessAllow or AccessDeny when the key or the client IP,
+// which may be nil, is on one of the access lists, and an empty string when
+// the request must be limited as usual. The lists are read from a local copy
+// that checks the store for changes every few seconds.
+func (r *RateLimiter) CheckAccess(key string, ip net.IP) (string, error) {
+	list := &r.access
+	now := r.now()
+	snapshot := list.snapshot.Load()
+	if snapshot == nil || list.stale.Load() || now.Sub(time.Unix(0, list.checkedAt.Load())) >= accessListRefresh {
+		refreshed, err := r.refreshAccessList(now)
+		if err != nil && refreshed == nil {
+			return "", err
+		}
+		if err != nil {
+			log.Printf("Failed to refresh access lists, using the cached ones: %v", err)
+		}
+		snapshot = refreshed
+	}
+	return snapshot.match(key, ip), nil
+}
+
+// refreshAccessList reloads the access lists when their version in the store
+// changed and returns the current snapshot. While a refresh is running the
+// other requests keep the snapshot they have, and only wait for it when there
+// is none yet.
+func (r *RateLimiter) refreshAccessList(now time.Time) (*accessSnapshot, error) {
+	list := &r.access
+	current := list.snapshot.Load()
+	if current == nil {
+		list.refresh.Lock()
+	} else if !list.refresh.TryLock() {
+		return current, nil
+	}
+	defer list.refresh.Unlock()
+
+	// Another request may have refreshed the lists in the meantime.
+	current = list.snapshot.Load()
+	if current != nil && !list.stale.Load() && now.Sub(time.Unix(0, list.checkedAt.Load())) < accessListRefresh {
+		return current, nil
+	}
+
+	list.checkedAt.Store(now.UnixNano())
+	stale := list.stale.Swap(false)
+	version, err := r.store.GetAccessVersion()
+	if err != nil {
+		return current, err
+	}
+
+	if current == nil || stale || version != current.version {
+		rules, err := r.store.GetAccessRules()
+		if err != nil {
+			return current, err
+		}
+		current = newAccessSnapshot(rules, version)
+		list.snapshot.Store(current)
+	}
+	return current, nil
+}
+
+// invalidateAccessList makes the next CheckAccess reload the lists.
+func (r *RateLimiter) invalidateAccessList() {
+	r.access.stale.Store(true)
+}
+
+func (r *RateLimiter) AddAccessRule(rule AccessRule) (AccessRule, error) {
+	rule, err := rule.Validate()
+	if err != nil {
+		return rule, err
+	}
+	defer r.invalidateAccessList()
+	return rule, r.store.SaveAccessRule(rule)
+}
+
+func (r *RateLimiter) RemoveAccessRule(rule AccessRule) error {
+	rule, err := rule.Validate()
+	if err != nil {
+		return err
+	}
+	defer r.invalidateAccessList()
+	return r.store.DeleteAccessRule(rule)
+}
+
+func (r *RateLimiter) GetAccessRules() ([]AccessRule, error) {
+	return r.store.GetAccessRules()
+}
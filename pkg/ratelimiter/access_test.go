@@ -0,0 +1,137 @@
+package ratelimiter
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestAccessRuleValidate tests that Validate rejects malformed rules and normalizes CIDRs
+func TestAccessRuleValidate(t *testing.T) {
+	rule, err := AccessRule{List: AccessDeny, Match: MatchCIDR, Value: "203.0.113.9/24"}.Validate()
+	assert.NoError(t, err)
+	assert.Equal(t, "203.0.113.0/24", rule.Value)
+
+	for _, rule := range []AccessRule{
+		{List: "maybe", Match: MatchKey, Value: "a"},
+		{List: AccessAllow, Match: "regex", Value: "a"},
+		{List: AccessAllow, Match: MatchKey},
+		{List: AccessAllow, Match: MatchCIDR, Value: "203.0.113.9"},
+	} {
+		_, err := rule.Validate()
+		assert.ErrorIs(t, err, ErrInvalidAccessRule)
+	}
+}
+
+// TestCheckAccess tests that keys, prefixes and CIDRs are matched with the deny list first
+func TestCheckAccess(t *testing.T) {
+	store := NewMemoryStore()
+	defer store.Close()
+	rateLimiter := NewRateLimiter(store)
+
+	for _, rule := range []AccessRule{
+		{List: AccessAllow, Match: MatchKey, Value: "health-checker"},
+		{List: AccessAllow, Match: MatchPrefix, Value: "internal_"},
+		{List: AccessAllow, Match: MatchCIDR, Value: "10.0.0.0/8"},
+		{List: AccessDeny, Match: MatchCIDR, Value: "10.66.0.0/16"},
+		{List: AccessDeny, Match: MatchPrefix, Value: "internal_revoked_"},
+	} {
+		_, err := rateLimiter.AddAccessRule(rule)
+		assert.NoError(t, err)
+	}
+
+	tests := []struct {
+		key  string
+		ip   string
+		want string
+	}{
+		{"health-checker", "", AccessAllow},
+		{"internal_billing", "", AccessAllow},
+		{"internal_revoked_billing", "", AccessDeny},
+		{"10.1.2.3", "10.1.2.3", AccessAllow},
+		{"10.66.2.3", "10.66.2.3", AccessDeny},
+		{"health-checker", "10.66.2.3", AccessDeny},
+		{"198.51.100.7", "198.51.100.7", ""},
+	}
+	for _, test := range tests {
+		got, err := rateLimiter.CheckAccess(test.key, net.ParseIP(test.ip))
+		assert.NoError(t, err)
+		assert.Equal(t, test.want, got, "key %s ip %s", test.key, test.ip)
+	}
+}
+
+// TestCheckAccessCache tests that the access lists are cached until the store version changes
+func TestCheckAccessCache(t *testing.T) {
+	version := int64(1)
+	loads := 0
+	rules := []AccessRule{{List: AccessDeny, Match: MatchKey, Value: "abuser"}}
+	store := &MockStore{
+		GetAccessVersionFunc: func() (int64, error) {
+			return version, nil
+		},
+		GetAccessRulesFunc: func() ([]AccessRule, error) {
+			loads++
+			return rules, nil
+		},
+	}
+	rateLimiter := NewRateLimiter(store)
+	now := time.Now()
+	rateLimiter.now = func() time.Time { return now }
+
+	access, err := rateLimiter.CheckAccess("abuser", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, AccessDeny, access)
+
+	// Another instance lifts the ban: it is only noticed after the refresh interval.
+	rules, version = nil, 2
+	access, _ = rateLimiter.CheckAccess("abuser", nil)
+	assert.Equal(t, AccessDeny, access)
+	assert.Equal(t, 1, loads)
+
+	now = now.Add(accessListRefresh)
+	access, _ = rateLimiter.CheckAccess("abuser", nil)
+	assert.Equal(t, "", access)
+	assert.Equal(t, 2, loads)
+
+	// An unchanged version does not reload the rules.
+	now = now.Add(accessListRefresh)
+	_, _ = rateLimiter.CheckAccess("abuser", nil)
+	assert.Equal(t, 2, loads)
+}
+
+// TestCheckAccessStoreOutage tests that a failed refresh keeps the cached lists and waits for the next interval to retry
+func TestCheckAccessStoreOutage(t *testing.T) {
+	var versionErr error
+	calls := 0
+	store := &MockStore{
+		GetAccessVersionFunc: func() (int64, error) {
+			calls++
+			return 1, versionErr
+		},
+		GetAccessRulesFunc: func() ([]AccessRule, error) {
+			return []AccessRule{{List: AccessDeny, Match: MatchKey, Value: "abuser"}}, nil
+		},
+	}
+	rateLimiter := NewRateLimiter(store)
+	now := time.Now()
+	rateLimiter.now = func() time.Time { return now }
+
+	_, err := rateLimiter.CheckAccess("abuser", nil)
+	assert.NoError(t, err)
+
+	versionErr = errors.New("connection refused")
+	now = now.Add(accessListRefresh)
+	for i := 0; i < 3; i++ {
+		access, err := rateLimiter.CheckAccess("abuser", nil)
+		assert.NoError(t, err)
+		assert.Equal(t, AccessDeny, access)
+	}
+	assert.Equal(t, 2, calls)
+
+	now = now.Add(accessListRefresh)
+	_, _ = rateLimiter.CheckAccess("abuser", nil)
+	assert.Equal(t, 3, calls)
+}
@@ -0,0 +1,57 @@
+package ratelimiter
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// ErrInvalidBan is returned when a ban has no key, reason or actor, or a
+	// negative duration.
+	ErrInvalidBan = errors.New("invalid ban")
+	// ErrBanned is returned when unblocking a key that is banned, as only
+	// revoking the ban lifts it.
+	ErrBanned = errors.New("key is banned")
+)
+
+// Ban blocks key by hand for duration, or for good when it is zero, recording
+// why and by whom. A ban is refused before any limit is evaluated, and is left
+// in place by Reset and Unblock and their bulk variants: only Unban or its
+// expiry lift it. Banning a key that is already blocked replaces the block.
+func (r *RateLimiter) Ban(key string, duration time.Duration, reason string, actor string) (BlockInfo, error) {
+	switch {
+	case key == "":
+		return BlockInfo{}, fmt.Errorf("%w: the key is required", ErrInvalidBan)
+	case reason == "":
+		return BlockInfo{}, fmt.Errorf("%w: the reason is required", ErrInvalidBan)
+	case actor == "":
+		return BlockInfo{}, fmt.Errorf("%w: the actor is required", ErrInvalidBan)
+	case duration < 0:
+		return BlockInfo{}, fmt.Errorf("%w: the duration must not be negative", ErrInvalidBan)
+	}
+	return r.block(BlockInfo{Key: key, Type: BlockTypeBan, Reason: reason, Actor: actor}, duration)
+}
+
+// GetBan returns the ban of key, or ErrNotFound when key is not banned.
+func (r *RateLimiter) GetBan(key string) (BlockInfo, error) {
+	info, err := r.store.GetBlockInfo(key)
+	if err != nil {
+		return BlockInfo{}, err
+	}
+	if info.Type != BlockTypeBan {
+		return BlockInfo{}, ErrNotFound
+	}
+	return info, nil
+}
+
+// Unban revokes the ban of key. It returns ErrNotFound when key is not banned,
+// leaving a block set by a limit in place.
+func (r *RateLimiter) Unban(key string) error {
+	_, err := r.GetBan(key)
+	if err != nil {
+		return err
+	}
+	_, err = r.store.Unblock(key)
+	return err
+}
@@ -0,0 +1,49 @@
+package ratelimiter
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBanValidation(t *testing.T) {
+	limiter := NewRateLimiter(&MockStore{})
+	tests := []struct {
+		key      string
+		duration time.Duration
+		reason   string
+		actor    string
+	}{
+		{"", time.Hour, "abuse", "alice"},
+		{"user:1", time.Hour, "", "alice"},
+		{"user:1", time.Hour, "abuse", ""},
+		{"user:1", -time.Second, "abuse", "alice"},
+	}
+	for _, tt := range tests {
+		_, err := limiter.Ban(tt.key, tt.duration, tt.reason, tt.actor)
+		assert.True(t, errors.Is(err, ErrInvalidBan), "%+v", tt)
+	}
+}
+
+func TestUnblockRefusesBans(t *testing.T) {
+	unblocked := false
+	store := &MockStore{
+		GetBlockInfoFunc: func(key string) (BlockInfo, error) {
+			return BlockInfo{Key: key, Type: BlockTypeBan}, nil
+		},
+		UnblockFunc: func(key string) (bool, error) {
+			unblocked = true
+			return true, nil
+		},
+	}
+	limiter := NewRateLimiter(store)
+
+	_, err := limiter.Unblock("user:1")
+	assert.Equal(t, ErrBanned, err)
+	assert.False(t, unblocked)
+
+	assert.NoError(t, limiter.Unban("user:1"))
+	assert.True(t, unblocked)
+}
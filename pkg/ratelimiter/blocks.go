@@ -0,0 +1,89 @@
+package ratelimiter
+
+import (
+	"time"
+)
+
+const (
+	// BlockTypeLimit is a block set when a key goes over its limit.
+	BlockTypeLimit = "limit"
+	// BlockTypeBan is a block set by hand with Ban.
+	BlockTypeBan = "ban"
+)
+
+// BlockInfo describes a block. Everything but the key and the expiry is
+// recorded next to the block when it is set, and is missing for blocks set
+// without going through the RateLimiter. ExpiresAt is nil for a block without
+// expiry.
+type BlockInfo struct {
+	Key       string     `json:"key"`
+	Type      string     `json:"type" enums:"limit,ban"`
+	BlockedAt *time.Time `json:"blocked_at"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	// Policy is the limit the key went over: the id of its limit data, or the
+	// algorithm when the limit data has no id.
+	Policy string `json:"policy,omitempty"`
+	// Reason and Actor say why and by whom a ban was set.
+	Reason string `json:"reason,omitempty"`
+	Actor  string `json:"actor,omitempty"`
+}
+
+// BlockedPage is a page of blocks. NextCursor is empty on the last page.
+type BlockedPage struct {
+	Items      []BlockInfo `json:"items"`
+	NextCursor string      `json:"next_cursor"`
+}
+
+// ListBlocked lists the keys blocked right now, bans included, one page at a
+// time. A page size under one lists DefaultPageSize blocks.
+func (r *RateLimiter) ListBlocked(cursor string, pageSize int64) (BlockedPage, error) {
+	page, err := r.store.ListBlocked(cursor, pageSizeOrDefault(pageSize))
+	if err != nil {
+		return BlockedPage{}, err
+	}
+	for i := range page.Items {
+		if page.Items[i].Type == "" {
+			page.Items[i].Type = BlockTypeLimit
+		}
+	}
+	return page, nil
+}
+
+// block blocks info.Key for duration, or for good when it is zero, and records
+// info next to the block.
+func (r *RateLimiter) block(info BlockInfo, duration time.Duration) (BlockInfo, error) {
+	err := r.store.SetBlockDuration("blocked:"+info.Key, 1, duration)
+	if err != nil {
+		return BlockInfo{}, err
+	}
+	return r.saveBlockInfo(info, duration)
+}
+
+// saveBlockInfo records info with the time of the block next to it. It
+// expires with the block.
+func (r *RateLimiter) saveBlockInfo(info BlockInfo, duration time.Duration) (BlockInfo, error) {
+	now := r.now()
+	info.BlockedAt, info.ExpiresAt = &now, blockExpiry(now, duration)
+	return info, r.store.SaveBlockInfo(info, duration)
+}
+
+// policy names the limit data in the blocks it sets.
+func (d LimitData) policy() string {
+	if d.Id != "" {
+		return d.Id
+	}
+	if d.Algorithm == "" {
+		return AlgorithmFixedWindow
+	}
+	return d.Algorithm
+}
+
+// blockExpiry returns when a block with ttl left ends, or nil when the block
+// does not expire.
+func blockExpiry(now time.Time, ttl time.Duration) *time.Time {
+	if ttl <= 0 {
+		return nil
+	}
+	expiresAt := now.Add(ttl)
+	return &expiresAt
+}
@@ -0,0 +1,86 @@
+package ratelimiter
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DefaultLease is how long an in-flight slot is held when LimitData does not
+// set LeaseSeconds.
+const DefaultLease = 30 * time.Second
+
+// Lease is an in-flight slot held by a request. The lease is renewed in the
+// background while it is held, so only the slots of a crashed instance expire.
+type Lease struct {
+	rateLimiter *RateLimiter
+	key         string
+	id          string
+	stop        chan struct{}
+	once        sync.Once
+}
+
+// Acquire takes one of the MaxInFlight concurrent slots of key. It returns a
+// nil lease when all slots are taken. Limit data without MaxInFlight always
+// gets a lease that holds no slot.
+func (r *RateLimiter) Acquire(key string, data LimitData) (*Lease, error) {
+	if data.MaxInFlight <= 0 {
+		return &Lease{}, nil
+	}
+
+	duration := time.Duration(data.LeaseSeconds) * time.Second
+	if duration <= 0 {
+		duration = DefaultLease
+	}
+
+	id := uuid.New().String()
+	_, acquired, err := r.store.AcquireSlot(key, id, data.MaxInFlight, duration, r.now())
+	if err != nil {
+		return nil, err
+	}
+	if !acquired {
+		return nil, nil
+	}
+
+	lease := &Lease{
+		rateLimiter: r,
+		key:         key,
+		id:          id,
+		stop:        make(chan struct{}),
+	}
+	go lease.renew(duration)
+	return lease, nil
+}
+
+func (l *Lease) renew(duration time.Duration) {
+	ticker := time.NewTicker(duration / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			err := l.rateLimiter.store.RenewSlot(l.key, l.id, duration, l.rateLimiter.now())
+			if err != nil {
+				log.Printf("Failed to renew lease for key %s: %v", l.key, err)
+			}
+		case <-l.stop:
+			return
+		}
+	}
+}
+
+// Release gives the slot back. It is safe to call more than once.
+func (l *Lease) Release() error {
+	if l.rateLimiter == nil {
+		return nil
+	}
+
+	var err error
+	l.once.Do(func() {
+		close(l.stop)
+		err = l.rateLimiter.store.ReleaseSlot(l.key, l.id)
+	})
+	return err
+}
@@ -0,0 +1,92 @@
+package ratelimiter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestAcquireWithoutMaxInFlight tests that keys without a concurrency limit never touch the store
+func TestAcquireWithoutMaxInFlight(t *testing.T) {
+	rateLimiter := NewRateLimiter(&MockStore{})
+
+	lease, err := rateLimiter.Acquire("testKey", LimitData{})
+	assert.NoError(t, err)
+	assert.NotNil(t, lease)
+	assert.NoError(t, lease.Release())
+}
+
+// TestAcquireAndRelease tests that an acquired slot is released exactly once
+func TestAcquireAndRelease(t *testing.T) {
+	var acquiredId string
+	releases := 0
+	store := &MockStore{
+		AcquireSlotFunc: func(key string, id string, maxInFlight int64, lease time.Duration, now time.Time) (int64, bool, error) {
+			assert.Equal(t, "testKey", key)
+			assert.Equal(t, int64(2), maxInFlight)
+			assert.Equal(t, DefaultLease, lease)
+			acquiredId = id
+			return 1, true, nil
+		},
+		ReleaseSlotFunc: func(key string, id string) error {
+			assert.Equal(t, "testKey", key)
+			assert.Equal(t, acquiredId, id)
+			releases++
+			return nil
+		},
+	}
+	rateLimiter := NewRateLimiter(store)
+
+	lease, err := rateLimiter.Acquire("testKey", LimitData{MaxInFlight: 2})
+	assert.NoError(t, err)
+	assert.NotNil(t, lease)
+	assert.NoError(t, lease.Release())
+	assert.NoError(t, lease.Release())
+	assert.Equal(t, 1, releases)
+}
+
+// TestAcquireFull tests that no lease is returned when all slots are taken
+func TestAcquireFull(t *testing.T) {
+	store := &MockStore{
+		AcquireSlotFunc: func(key string, id string, maxInFlight int64, lease time.Duration, now time.Time) (int64, bool, error) {
+			return 2, false, nil
+		},
+	}
+	rateLimiter := NewRateLimiter(store)
+
+	lease, err := rateLimiter.Acquire("testKey", LimitData{MaxInFlight: 2})
+	assert.NoError(t, err)
+	assert.Nil(t, lease)
+}
+
+// TestLeaseRenewal tests that a held lease is renewed in the background
+func TestLeaseRenewal(t *testing.T) {
+	renewed := make(chan struct{}, 1)
+	store := &MockStore{
+		AcquireSlotFunc: func(key string, id string, maxInFlight int64, lease time.Duration, now time.Time) (int64, bool, error) {
+			return 1, true, nil
+		},
+		RenewSlotFunc: func(key string, id string, lease time.Duration, now time.Time) error {
+			select {
+			case renewed <- struct{}{}:
+			default:
+			}
+			return nil
+		},
+		ReleaseSlotFunc: func(key string, id string) error {
+			return nil
+		},
+	}
+	rateLimiter := NewRateLimiter(store)
+
+	lease, err := rateLimiter.Acquire("testKey", LimitData{MaxInFlight: 1, LeaseSeconds: 1})
+	assert.NoError(t, err)
+	defer lease.Release()
+
+	select {
+	case <-renewed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("lease was not renewed")
+	}
+}
@@ -0,0 +1,43 @@
+package ratelimiter
+
+import (
+	"fmt"
+	"time"
+)
+
+// gcra implements the generic cell rate algorithm. MaxRequests may be spent at
+// once, after which one request is allowed every Seconds/MaxRequests. The only
+// state kept per key is its theoretical arrival time, updated in a single store
+// call, so neither a counter nor a blocked: key is involved and BlockDuration
+// is ignored.
+type gcra struct{}
+
+func (gcra) Limit(r *RateLimiter, key string, data LimitData, cost int64) (Decision, error) {
+	emissionInterval, err := gcraEmissionInterval(data)
+	if err != nil {
+		return Decision{}, err
+	}
+	return r.store.GCRA(key, emissionInterval, data.MaxRequests, cost, r.now())
+}
+
+func (gcra) Refund(r *RateLimiter, key string, data LimitData, charged charge) error {
+	emissionInterval, err := gcraEmissionInterval(data)
+	if err != nil {
+		return err
+	}
+	return r.store.RefundGCRA(key, emissionInterval, charged.cost, r.now())
+}
+
+// gcraEmissionInterval returns the time one request takes to drain from the
+// burst tolerance. It must be at least a microsecond, the precision the Redis
+// script works at.
+func gcraEmissionInterval(data LimitData) (time.Duration, error) {
+	if data.MaxRequests <= 0 || data.Seconds <= 0 {
+		return 0, fmt.Errorf("invalid %s limit: seconds and max_requests must be positive", AlgorithmGCRA)
+	}
+	emissionInterval := time.Duration(data.Seconds) * time.Second / time.Duration(data.MaxRequests)
+	if emissionInterval < time.Microsecond {
+		return 0, fmt.Errorf("invalid %s limit: more than one request per microsecond", AlgorithmGCRA)
+	}
+	return emissionInterval, nil
+}
@@ -0,0 +1,48 @@
+package ratelimiter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestGCRA tests that GCRA is evaluated in a single store call without a block check
+func TestGCRA(t *testing.T) {
+	store := &MockStore{
+		GCRAFunc: func(key string, emissionInterval time.Duration, limit int64, cost int64, now time.Time) (Decision, error) {
+			assert.Equal(t, "testKey", key)
+			assert.Equal(t, 2*time.Second, emissionInterval)
+			assert.Equal(t, int64(5), limit)
+			return Decision{Limited: true, Limit: limit, RetryAfter: time.Second}, nil
+		},
+	}
+	rateLimiter := NewRateLimiter(store)
+
+	decision, err := rateLimiter.Evaluate("testKey", LimitData{
+		Algorithm:   AlgorithmGCRA,
+		Seconds:     10,
+		MaxRequests: 5,
+	})
+	assert.NoError(t, err)
+	assert.True(t, decision.Limited)
+	assert.Equal(t, time.Second, decision.RetryAfter)
+}
+
+// TestGCRAInvalid tests that GCRA refuses limits it cannot compute an emission interval for
+func TestGCRAInvalid(t *testing.T) {
+	rateLimiter := NewRateLimiter(&MockStore{})
+
+	for _, data := range []LimitData{
+		{Key: "testKey", Algorithm: AlgorithmGCRA, Seconds: 10},
+		{Key: "testKey", Algorithm: AlgorithmGCRA, Seconds: 10, MaxRequests: -1},
+		{Key: "testKey", Algorithm: AlgorithmGCRA, MaxRequests: 5},
+		{Key: "testKey", Algorithm: AlgorithmGCRA, Seconds: 1, MaxRequests: 2e9},
+		{Key: "testKey", Algorithm: AlgorithmGCRA, Seconds: 1, MaxRequests: 2e6},
+	} {
+		_, err := rateLimiter.Evaluate("testKey", data)
+		assert.Error(t, err, "%+v", data)
+		assert.Error(t, data.Validate(), "%+v", data)
+	}
+	assert.False(t, LimitData{Algorithm: AlgorithmGCRA, Seconds: 10}.IsConfigured())
+}
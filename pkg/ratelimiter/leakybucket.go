@@ -0,0 +1,74 @@
+package ratelimiter
+
+import (
+	"fmt"
+	"time"
+)
+
+// leakyBucket releases the requests of a key at a steady LeakPerSecond drip.
+// Instead of being rejected, a request over the rate is queued and the caller
+// is told to wait for its slot through Decision.Delay. Only when the queue
+// already holds MaxQueue requests, or the wait would exceed MaxWait seconds,
+// is the request limited. This mode never blocks a key itself, but a key
+// blocked by hand or by another limit is refused.
+type leakyBucket struct{}
+
+func (leakyBucket) Limit(r *RateLimiter, key string, data LimitData, cost int64) (Decision, error) {
+	interval := leakInterval(data.LeakPerSecond)
+	if interval <= 0 {
+		return Decision{}, fmt.Errorf("invalid %s limit: leak_per_second must be positive and at most one per microsecond", AlgorithmLeakyBucket)
+	}
+	if decision, blocked, err := r.blocked(key, data.MaxQueue); err != nil || blocked {
+		return decision, err
+	}
+
+	maxWait := time.Duration(data.MaxWait) * time.Second
+
+	wait, allowed, err := r.store.ReserveSlot(key, interval, data.MaxQueue, maxWait, cost, r.now())
+	if err != nil {
+		return Decision{}, err
+	}
+
+	position := int64((wait+interval-1)/interval) + cost - 1
+	if !allowed {
+		retryAfter := time.Duration(position-data.MaxQueue) * interval
+		if maxWait > 0 && wait-maxWait > retryAfter {
+			retryAfter = wait - maxWait
+		}
+		return Decision{
+			Limited:    true,
+			Limit:      data.MaxQueue,
+			RetryAfter: retryAfter,
+			ResetAfter: wait,
+		}, nil
+	}
+
+	return Decision{
+		Limit:      data.MaxQueue,
+		Remaining:  data.MaxQueue - position,
+		ResetAfter: wait + time.Duration(cost)*interval,
+		Delay:      wait,
+	}, nil
+}
+
+func (leakyBucket) Refund(r *RateLimiter, key string, data LimitData, charged charge) error {
+	interval := leakInterval(data.LeakPerSecond)
+	if interval <= 0 {
+		return fmt.Errorf("invalid %s limit: leak_per_second must be positive and at most one per microsecond", AlgorithmLeakyBucket)
+	}
+	return r.store.RefundSlot(key, interval, charged.cost, r.now())
+}
+
+// leakInterval returns the time between two requests leaving the queue. It is
+// zero for rates that cannot be scheduled, including those leaking more than
+// one request per microsecond, the precision the Redis script works at.
+func leakInterval(leakPerSecond float64) time.Duration {
+	if leakPerSecond <= 0 {
+		return 0
+	}
+	interval := time.Duration(float64(time.Second) / leakPerSecond)
+	if interval < time.Microsecond {
+		return 0
+	}
+	return interval
+}
@@ -0,0 +1,86 @@
+package ratelimiter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestLeakyBucketQueues tests that a request over the drip rate is delayed instead of limited
+func TestLeakyBucketQueues(t *testing.T) {
+	store := &MockStore{
+		GetBlockTTLFunc: func(key string) (time.Duration, bool, error) {
+			return 0, false, nil
+		},
+		ReserveSlotFunc: func(key string, interval time.Duration, maxQueue int64, maxWait time.Duration, cost int64, now time.Time) (time.Duration, bool, error) {
+			assert.Equal(t, "testKey", key)
+			assert.Equal(t, 500*time.Millisecond, interval)
+			assert.Equal(t, int64(4), maxQueue)
+			assert.Equal(t, 10*time.Second, maxWait)
+			return time.Second, true, nil
+		},
+	}
+	rateLimiter := NewRateLimiter(store)
+
+	decision, err := rateLimiter.Evaluate("testKey", LimitData{
+		Algorithm:     AlgorithmLeakyBucket,
+		LeakPerSecond: 2,
+		MaxQueue:      4,
+		MaxWait:       10,
+	})
+	assert.NoError(t, err)
+	assert.False(t, decision.Limited)
+	assert.Equal(t, time.Second, decision.Delay)
+	assert.Equal(t, int64(2), decision.Remaining)
+}
+
+// TestLeakyBucketQueueFull tests that a request is limited once the queue is full
+func TestLeakyBucketQueueFull(t *testing.T) {
+	store := &MockStore{
+		GetBlockTTLFunc: func(key string) (time.Duration, bool, error) {
+			return 0, false, nil
+		},
+		ReserveSlotFunc: func(key string, interval time.Duration, maxQueue int64, maxWait time.Duration, cost int64, now time.Time) (time.Duration, bool, error) {
+			return 2500 * time.Millisecond, false, nil
+		},
+	}
+	rateLimiter := NewRateLimiter(store)
+
+	decision, err := rateLimiter.Evaluate("testKey", LimitData{
+		Algorithm:     AlgorithmLeakyBucket,
+		LeakPerSecond: 2,
+		MaxQueue:      4,
+	})
+	assert.NoError(t, err)
+	assert.True(t, decision.Limited)
+	assert.Equal(t, time.Duration(0), decision.Delay)
+	assert.Equal(t, 500*time.Millisecond, decision.RetryAfter)
+}
+
+// TestLeakyBucketBlocked tests that a blocked key is limited without taking a slot
+func TestLeakyBucketBlocked(t *testing.T) {
+	store := &MockStore{
+		GetBlockTTLFunc: func(key string) (time.Duration, bool, error) {
+			assert.Equal(t, "blocked:testKey", key)
+			return time.Minute, true, nil
+		},
+	}
+	rateLimiter := NewRateLimiter(store)
+
+	decision, err := rateLimiter.Evaluate("testKey", LimitData{Algorithm: AlgorithmLeakyBucket, LeakPerSecond: 2, MaxQueue: 4})
+	assert.NoError(t, err)
+	assert.True(t, decision.Limited)
+	assert.Equal(t, time.Minute, decision.RetryAfter)
+}
+
+// TestLeakyBucketInvalidRate tests that a rate whose interval is under a microsecond is refused
+func TestLeakyBucketInvalidRate(t *testing.T) {
+	for _, rate := range []float64{2e9, 2e6} {
+		data := LimitData{Key: "testKey", Algorithm: AlgorithmLeakyBucket, LeakPerSecond: rate}
+		_, err := NewRateLimiter(&MockStore{}).Evaluate("testKey", data)
+		assert.Error(t, err, "%v", rate)
+		assert.Error(t, data.Validate(), "%v", rate)
+		assert.False(t, data.IsConfigured(), "%v", rate)
+	}
+}
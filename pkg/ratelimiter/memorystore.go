@@ -0,0 +1,912 @@
+package ratelimiter
+
+import (
+	"hash/fnv"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	memoryShardCount      = 32
+	memoryJanitorInterval = time.Minute
+)
+
+// MemoryStore is a Store kept in the memory of the process, for deployments
+// with a single instance and for tests. Keys are spread over shards with their
+// own lock, and a janitor evicts expired entries in the background until the
+// store is closed. It uses the same key prefixes as the RedisStore.
+type MemoryStore struct {
+	shards []*memoryShard
+	now    func() time.Time
+	stop   chan struct{}
+	once   sync.Once
+}
+
+type memoryShard struct {
+	mu    sync.Mutex
+	items map[string]*memoryItem
+}
+
+type memoryItem struct {
+	value     interface{}
+	expiresAt time.Time
+}
+
+type memoryBucket struct {
+	tokens float64
+	ts     time.Time
+}
+
+// memoryLogEntry is a request in the sliding window log, with its cost.
+type memoryLogEntry struct {
+	id   string
+	ts   time.Time
+	cost int64
+}
+
+func (i *memoryItem) expired(now time.Time) bool {
+	return !i.expiresAt.IsZero() && !now.Before(i.expiresAt)
+}
+
+func NewMemoryStore() *MemoryStore {
+	return newMemoryStore(memoryJanitorInterval)
+}
+
+func newMemoryStore(janitorInterval time.Duration) *MemoryStore {
+	shards := make([]*memoryShard, memoryShardCount)
+	for i := range shards {
+		shards[i] = &memoryShard{items: make(map[string]*memoryItem)}
+	}
+
+	m := &MemoryStore{
+		shards: shards,
+		now:    time.Now,
+		stop:   make(chan struct{}),
+	}
+	go m.janitor(janitorInterval)
+	return m
+}
+
+// Close stops the janitor.
+func (m *MemoryStore) Close() {
+	m.once.Do(func() {
+		close(m.stop)
+	})
+}
+
+func (m *MemoryStore) janitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			m.evictExpired()
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+func (m *MemoryStore) evictExpired() {
+	now := m.now()
+	for _, shard := range m.shards {
+		shard.mu.Lock()
+		for key, item := range shard.items {
+			if item.expired(now) {
+				delete(shard.items, key)
+			}
+		}
+		shard.mu.Unlock()
+	}
+}
+
+func (m *MemoryStore) shard(key string) *memoryShard {
+	return m.shards[m.shardIndex(key)]
+}
+
+func (m *MemoryStore) shardIndex(key string) uint32 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return h.Sum32() % memoryShardCount
+}
+
+// update runs fn with the live item stored at key, or nil when there is none,
+// while holding the lock of its shard. Setting the item to nil deletes it.
+func (m *MemoryStore) update(key string, now time.Time, fn func(item **memoryItem)) {
+	m.updateAll([]string{key}, now, func(items []*memoryItem) {
+		fn(&items[0])
+	})
+}
+
+// updateAll is update for several keys at once, so a single step can read and
+// write them together like a Redis script does. The shards of the keys are
+// locked in index order, which keeps concurrent calls from deadlocking.
+func (m *MemoryStore) updateAll(keys []string, now time.Time, fn func(items []*memoryItem)) {
+	indexes := make([]uint32, len(keys))
+	locked := make([]uint32, 0, len(keys))
+	for i, key := range keys {
+		indexes[i] = m.shardIndex(key)
+		locked = append(locked, indexes[i])
+	}
+	sort.Slice(locked, func(i, j int) bool { return locked[i] < locked[j] })
+	for i, index := range locked {
+		if i == 0 || index != locked[i-1] {
+			m.shards[index].mu.Lock()
+			defer m.shards[index].mu.Unlock()
+		}
+	}
+
+	items := make([]*memoryItem, len(keys))
+	for i, key := range keys {
+		item := m.shards[indexes[i]].items[key]
+		if item != nil && item.expired(now) {
+			item = nil
+		}
+		items[i] = item
+	}
+
+	fn(items)
+
+	for i, key := range keys {
+		if items[i] == nil {
+			delete(m.shards[indexes[i]].items, key)
+			continue
+		}
+		m.shards[indexes[i]].items[key] = items[i]
+	}
+}
+
+func (m *MemoryStore) get(key string) (interface{}, bool) {
+	var value interface{}
+	var found bool
+	m.update(key, m.now(), func(item **memoryItem) {
+		if *item != nil {
+			value, found = (*item).value, true
+		}
+	})
+	return value, found
+}
+
+// ttl reports whether key holds a live entry and how long it has left, which
+// is zero for an entry that never expires.
+func (m *MemoryStore) ttl(key string, now time.Time) (time.Duration, bool) {
+	var ttl time.Duration
+	var found bool
+	m.update(key, now, func(item **memoryItem) {
+		if *item != nil {
+			found = true
+			if !(*item).expiresAt.IsZero() {
+				ttl = (*item).expiresAt.Sub(now)
+			}
+		}
+	})
+	return ttl, found
+}
+
+func (m *MemoryStore) set(key string, value interface{}, expiration time.Duration) {
+	now := m.now()
+	m.update(key, now, func(item **memoryItem) {
+		*item = &memoryItem{value: value, expiresAt: expiresAt(now, expiration)}
+	})
+}
+
+// expiresAt returns the expiry for a TTL, where 0 means the entry never expires.
+func expiresAt(now time.Time, ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return now.Add(ttl)
+}
+
+func (m *MemoryStore) Increment(key string, seconds int64) (int64, error) {
+	now := m.now()
+	var count int64
+	m.update("limit::"+key, now, func(item **memoryItem) {
+		if *item != nil {
+			count = (*item).value.(int64)
+		}
+		count++
+		*item = &memoryItem{value: count, expiresAt: expiresAt(now, time.Duration(seconds)*time.Second)}
+	})
+	return count, nil
+}
+
+// CheckAndIncrement checks the block, counts the request and sets the block in
+// one step, holding the locks of both entries like the Redis script does.
+func (m *MemoryStore) CheckAndIncrement(key string, limit int64, cost int64, window time.Duration, blockDuration time.Duration) (Decision, error) {
+	now := m.now()
+	var decision Decision
+	m.updateAll([]string{"blocked:" + key, "limit::" + key}, now, func(items []*memoryItem) {
+		block, counter := &items[0], &items[1]
+		if *block != nil {
+			var blockedFor time.Duration
+			if !(*block).expiresAt.IsZero() {
+				blockedFor = (*block).expiresAt.Sub(now)
+			}
+			decision = Decision{Limited: true, Limit: limit, RetryAfter: blockedFor, ResetAfter: blockedFor}
+			return
+		}
+
+		var count int64
+		ttl := window
+		if *counter != nil {
+			count = (*counter).value.(int64)
+			if !(*counter).expiresAt.IsZero() {
+				ttl = (*counter).expiresAt.Sub(now)
+			}
+		}
+
+		if count+cost > limit {
+			if blockDuration > 0 && cost <= limit {
+				*block = &memoryItem{value: int64(1), expiresAt: now.Add(blockDuration)}
+				resetAfter := ttl
+				if blockDuration > resetAfter {
+					resetAfter = blockDuration
+				}
+				decision = Decision{Limited: true, Limit: limit, RetryAfter: blockDuration, ResetAfter: resetAfter, Blocked: true}
+				return
+			}
+			decision = Decision{Limited: true, Limit: limit, RetryAfter: ttl, ResetAfter: ttl}
+			return
+		}
+
+		if *counter == nil {
+			*counter = &memoryItem{expiresAt: expiresAt(now, window)}
+		}
+		count += cost
+		(*counter).value = count
+		decision = Decision{Limit: limit, Remaining: limit - count, ResetAfter: ttl}
+	})
+	return decision, nil
+}
+
+func (m *MemoryStore) SaveInfoLimitData(key string, data LimitData) error {
+	m.set("info::"+key, data, 0)
+	if data.Id != "" {
+		m.set("id::"+data.Id, key, 0)
+	}
+	return nil
+}
+
+// CreateLimitData saves the limit data and its id index entry in one step,
+// unless either is already there.
+func (m *MemoryStore) CreateLimitData(key string, data LimitData) error {
+	keys := []string{"info::" + key}
+	if data.Id != "" {
+		keys = append(keys, "id::"+data.Id)
+	}
+
+	err := ErrExists
+	m.updateAll(keys, m.now(), func(items []*memoryItem) {
+		for _, item := range items {
+			if item != nil {
+				return
+			}
+		}
+		items[0] = &memoryItem{value: data}
+		if data.Id != "" {
+			items[1] = &memoryItem{value: key}
+		}
+		err = nil
+	})
+	return err
+}
+
+// DeleteLimitData deletes the limit data of key and its id index entry.
+func (m *MemoryStore) DeleteLimitData(key string) error {
+	var data LimitData
+	found := false
+	m.update("info::"+key, m.now(), func(item **memoryItem) {
+		if *item != nil {
+			data, found = (*item).value.(LimitData), true
+			*item = nil
+		}
+	})
+	if !found {
+		return ErrNotFound
+	}
+	if data.Id != "" {
+		m.update("id::"+data.Id, m.now(), func(item **memoryItem) {
+			*item = nil
+		})
+	}
+	return nil
+}
+
+func (m *MemoryStore) GetKeyByID(id string) (string, error) {
+	value, found := m.get("id::" + id)
+	if !found {
+		return "", ErrNotFound
+	}
+	return value.(string), nil
+}
+
+func (m *MemoryStore) GetInfoLimitData(key string) (LimitData, error) {
+	value, found := m.get("info::" + key)
+	if !found {
+		return LimitData{}, ErrNotFound
+	}
+	return value.(LimitData), nil
+}
+
+func (m *MemoryStore) SetBlockDuration(key string, value int64, expiration time.Duration) error {
+	m.set(key, value, expiration)
+	return nil
+}
+
+func (m *MemoryStore) GetBlockDuration(key string) (int64, error) {
+	value, found := m.get(key)
+	if !found {
+		return 0, nil
+	}
+	return value.(int64), nil
+}
+
+func (m *MemoryStore) GetBlockTTL(key string) (time.Duration, bool, error) {
+	ttl, found := m.ttl(key, m.now())
+	return ttl, found, nil
+}
+
+// Unblock lifts the block of key, bans included. The RateLimiter checks for a
+// ban before calling it.
+func (m *MemoryStore) Unblock(key string) (bool, error) {
+	found := false
+	m.updateAll([]string{"blocked:" + key, "blockinfo::" + key}, m.now(), func(items []*memoryItem) {
+		found = items[0] != nil
+		items[0], items[1] = nil, nil
+	})
+	return found, nil
+}
+
+// UnblockMatching lifts the blocks of the keys matching pattern, leaving the
+// bans in place.
+func (m *MemoryStore) UnblockMatching(pattern string) (int64, error) {
+	banned := m.bannedKeys()
+	unblocked := m.deleteState(func(prefix string, key string) bool {
+		return prefix == "blocked:" && matchGlob(pattern, key) && !banned[key]
+	})
+	m.deleteState(func(prefix string, key string) bool {
+		return prefix == "blockinfo::" && unblocked[key]
+	})
+	return int64(len(unblocked)), nil
+}
+
+func (m *MemoryStore) SaveBlockInfo(info BlockInfo, expiration time.Duration) error {
+	m.set("blockinfo::"+info.Key, info, expiration)
+	return nil
+}
+
+func (m *MemoryStore) GetBlockInfo(key string) (BlockInfo, error) {
+	now := m.now()
+	ttl, found := m.ttl("blocked:"+key, now)
+	if !found {
+		return BlockInfo{}, ErrNotFound
+	}
+
+	var info BlockInfo
+	if value, found := m.get("blockinfo::" + key); found {
+		info = value.(BlockInfo)
+	}
+	info.Key = key
+	info.ExpiresAt = blockExpiry(now, ttl)
+	return info, nil
+}
+
+// bannedKeys returns the keys whose block is a ban.
+func (m *MemoryStore) bannedKeys() map[string]bool {
+	now := m.now()
+	banned := make(map[string]bool)
+	for _, shard := range m.shards {
+		shard.mu.Lock()
+		for storeKey, item := range shard.items {
+			key, found := strings.CutPrefix(storeKey, "blockinfo::")
+			if found && !item.expired(now) && item.value.(BlockInfo).Type == BlockTypeBan {
+				banned[key] = true
+			}
+		}
+		shard.mu.Unlock()
+	}
+	return banned
+}
+
+// ListBlocked lists the blocks ordered by key. The cursor is the last key of
+// the previous page.
+func (m *MemoryStore) ListBlocked(cursor string, pageSize int64) (BlockedPage, error) {
+	now := m.now()
+	var keys []string
+	expiries := make(map[string]time.Time)
+	for _, shard := range m.shards {
+		shard.mu.Lock()
+		for key, item := range shard.items {
+			if !strings.HasPrefix(key, "blocked:") || item.expired(now) {
+				continue
+			}
+			key = strings.TrimPrefix(key, "blocked:")
+			if cursor == "" || key > cursor {
+				keys = append(keys, key)
+				expiries[key] = item.expiresAt
+			}
+		}
+		shard.mu.Unlock()
+	}
+	sort.Strings(keys)
+
+	page := BlockedPage{Items: []BlockInfo{}}
+	for i, key := range keys {
+		if int64(i) == pageSize {
+			page.NextCursor = keys[i-1]
+			break
+		}
+		var info BlockInfo
+		if value, found := m.get("blockinfo::" + key); found {
+			info = value.(BlockInfo)
+		}
+		info.Key = key
+		info.ExpiresAt = nil
+		if expiresAt := expiries[key]; !expiresAt.IsZero() {
+			info.ExpiresAt = &expiresAt
+		}
+		page.Items = append(page.Items, info)
+	}
+	return page, nil
+}
+
+// ResetKey deletes the counters and the block of key, unless it is a ban.
+func (m *MemoryStore) ResetKey(key string) error {
+	now := m.now()
+	info, err := m.GetBlockInfo(key)
+	banned := err == nil && info.Type == BlockTypeBan
+	for _, prefix := range stateKeyPrefixes {
+		if prefix == "counter::" || (banned && isBlockPrefix(prefix)) {
+			continue
+		}
+		m.update(prefix+key, now, func(item **memoryItem) {
+			*item = nil
+		})
+	}
+	m.deleteState(func(prefix string, stateKey string) bool {
+		return prefix == "counter::" && stateKey == key
+	})
+	return nil
+}
+
+// ResetMatching deletes the counters and blocks of the keys matching
+// pattern, except bans.
+func (m *MemoryStore) ResetMatching(pattern string) (int64, error) {
+	banned := m.bannedKeys()
+	reset := m.deleteState(func(prefix string, key string) bool {
+		return matchGlob(pattern, key) && !(banned[key] && isBlockPrefix(prefix))
+	})
+	return int64(len(reset)), nil
+}
+
+// deleteState deletes the live counters and blocks that match accepts, given
+// their prefix and the key they belong to, and returns those keys.
+func (m *MemoryStore) deleteState(match func(prefix string, key string) bool) map[string]bool {
+	now := m.now()
+	deleted := make(map[string]bool)
+	for _, shard := range m.shards {
+		shard.mu.Lock()
+		for storeKey, item := range shard.items {
+			prefix, key, ok := memoryStateKey(storeKey)
+			if !ok || item.expired(now) || !match(prefix, key) {
+				continue
+			}
+			delete(shard.items, storeKey)
+			deleted[key] = true
+		}
+		shard.mu.Unlock()
+	}
+	return deleted
+}
+
+// memoryStateKey splits a counter or block entry into its prefix and the key
+// it belongs to. The sliding window counters are named like in Redis.
+func memoryStateKey(storeKey string) (string, string, bool) {
+	for _, prefix := range stateKeyPrefixes {
+		rest, found := strings.CutPrefix(storeKey, prefix)
+		if !found {
+			continue
+		}
+		if prefix == "counter::" {
+			return untagStateKey(storeKey)
+		}
+		return prefix, rest, true
+	}
+	return "", "", false
+}
+
+func (m *MemoryStore) UpdateLimitData(key string, data LimitDataInput) error {
+	var err error
+	m.update("info::"+key, m.now(), func(item **memoryItem) {
+		if *item == nil {
+			err = ErrNotFound
+			return
+		}
+		(*item).value = (*item).value.(LimitData).Merge(data)
+	})
+	return err
+}
+
+func (m *MemoryStore) GetAllLimitData() ([]LimitData, error) {
+	var allData []LimitData
+	cursor := ""
+	for {
+		page, err := m.ListLimitData(cursor, DefaultPageSize)
+		if err != nil {
+			return nil, err
+		}
+		allData = append(allData, page.Items...)
+		if page.NextCursor == "" {
+			return allData, nil
+		}
+		cursor = page.NextCursor
+	}
+}
+
+// ListLimitData lists limit data ordered by key. The cursor is the last key
+// of the previous page.
+func (m *MemoryStore) ListLimitData(cursor string, pageSize int64) (LimitDataPage, error) {
+	now := m.now()
+	var keys []string
+	values := make(map[string]LimitData)
+	for _, shard := range m.shards {
+		shard.mu.Lock()
+		for key, item := range shard.items {
+			if !strings.HasPrefix(key, "info::") || item.expired(now) {
+				continue
+			}
+			key = strings.TrimPrefix(key, "info::")
+			if cursor == "" || key > cursor {
+				keys = append(keys, key)
+				values[key] = item.value.(LimitData)
+			}
+		}
+		shard.mu.Unlock()
+	}
+	sort.Strings(keys)
+
+	page := LimitDataPage{Items: []LimitData{}}
+	for i, key := range keys {
+		if int64(i) == pageSize {
+			page.NextCursor = keys[i-1]
+			break
+		}
+		page.Items = append(page.Items, values[key])
+	}
+	return page, nil
+}
+
+func (m *MemoryStore) SlidingWindowLog(key string, id string, limit int64, cost int64, window time.Duration, now time.Time) (int64, bool, error) {
+	var count int64
+	allowed := false
+	m.update("window::"+key, now, func(item **memoryItem) {
+		var log []memoryLogEntry
+		if *item != nil {
+			log = (*item).value.([]memoryLogEntry)
+		}
+
+		kept := log[:0]
+		for _, entry := range log {
+			if entry.ts.After(now.Add(-window)) {
+				kept = append(kept, entry)
+				count += entry.cost
+			}
+		}
+
+		if count+cost <= limit {
+			kept = append(kept, memoryLogEntry{id: id, ts: now, cost: cost})
+			count += cost
+			allowed = true
+		}
+		*item = &memoryItem{value: kept, expiresAt: now.Add(window)}
+	})
+	return count, allowed, nil
+}
+
+// RefundWindowLog removes the request being refunded from the log.
+func (m *MemoryStore) RefundWindowLog(key string, id string, cost int64) error {
+	m.update("window::"+key, m.now(), func(item **memoryItem) {
+		if *item == nil {
+			return
+		}
+		log := (*item).value.([]memoryLogEntry)
+		for i := len(log) - 1; i >= 0; i-- {
+			if log[i].id == id {
+				(*item).value = append(log[:i:i], log[i+1:]...)
+				return
+			}
+		}
+	})
+	return nil
+}
+
+func (m *MemoryStore) TakeToken(key string, capacity int64, refillPerSecond float64, cost int64, now time.Time) (float64, bool, error) {
+	var tokens float64
+	allowed := false
+	m.update("bucket::"+key, now, func(item **memoryItem) {
+		bucket := memoryBucket{tokens: float64(capacity), ts: now}
+		if *item != nil {
+			bucket = (*item).value.(memoryBucket)
+		}
+
+		elapsed := math.Max(0, now.Sub(bucket.ts).Seconds())
+		tokens = math.Min(float64(capacity), bucket.tokens+elapsed*refillPerSecond)
+		if tokens >= float64(cost) {
+			tokens -= float64(cost)
+			allowed = true
+		}
+
+		refill := time.Duration(float64(capacity) / refillPerSecond * float64(time.Second))
+		*item = &memoryItem{value: memoryBucket{tokens: tokens, ts: now}, expiresAt: now.Add(refill)}
+	})
+	return tokens, allowed, nil
+}
+
+// RefundTokens puts the cost of a request back in the bucket, up to its
+// capacity.
+func (m *MemoryStore) RefundTokens(key string, capacity int64, cost int64) error {
+	m.update("bucket::"+key, m.now(), func(item **memoryItem) {
+		if *item == nil {
+			return
+		}
+		bucket := (*item).value.(memoryBucket)
+		bucket.tokens = math.Min(float64(capacity), bucket.tokens+float64(cost))
+		(*item).value = bucket
+	})
+	return nil
+}
+
+func (m *MemoryStore) GCRA(key string, emissionInterval time.Duration, limit int64, cost int64, now time.Time) (Decision, error) {
+	decision := Decision{Limit: limit}
+	m.update("gcra::"+key, now, func(item **memoryItem) {
+		tat := now
+		if *item != nil && (*item).value.(time.Time).After(now) {
+			tat = (*item).value.(time.Time)
+		}
+
+		newTat := tat.Add(emissionInterval * time.Duration(cost))
+		diff := now.Sub(newTat.Add(-emissionInterval * time.Duration(limit)))
+		if diff < 0 {
+			decision.Limited = true
+			decision.RetryAfter = -diff
+			decision.ResetAfter = tat.Sub(now)
+			return
+		}
+
+		decision.Remaining = int64(diff / emissionInterval)
+		decision.ResetAfter = newTat.Sub(now)
+		*item = &memoryItem{value: newTat, expiresAt: newTat}
+	})
+	return decision, nil
+}
+
+func (m *MemoryStore) ReserveSlot(key string, interval time.Duration, maxQueue int64, maxWait time.Duration, cost int64, now time.Time) (time.Duration, bool, error) {
+	var wait time.Duration
+	allowed := false
+	m.update("queue::"+key, now, func(item **memoryItem) {
+		slot := now
+		if *item != nil && (*item).value.(time.Time).After(now) {
+			slot = (*item).value.(time.Time)
+		}
+
+		wait = slot.Sub(now)
+		position := int64((wait+interval-1)/interval) + cost - 1
+		if position > maxQueue || (maxWait > 0 && wait > maxWait) {
+			return
+		}
+
+		allowed = true
+		nextSlot := slot.Add(interval * time.Duration(cost))
+		*item = &memoryItem{value: nextSlot, expiresAt: nextSlot}
+	})
+	return wait, allowed, nil
+}
+
+func (m *MemoryStore) RefundGCRA(key string, emissionInterval time.Duration, cost int64, now time.Time) error {
+	m.refundTime("gcra::"+key, emissionInterval, cost, now)
+	return nil
+}
+
+func (m *MemoryStore) RefundSlot(key string, interval time.Duration, cost int64, now time.Time) error {
+	m.refundTime("queue::"+key, interval, cost, now)
+	return nil
+}
+
+// refundTime moves the time the GCRA and the leaky bucket keep for a key back
+// by cost steps, deleting it once it is no longer ahead of now.
+func (m *MemoryStore) refundTime(storeKey string, step time.Duration, cost int64, now time.Time) {
+	m.update(storeKey, now, func(item **memoryItem) {
+		if *item == nil {
+			return
+		}
+		next := (*item).value.(time.Time).Add(-step * time.Duration(cost))
+		if !next.After(now) {
+			*item = nil
+			return
+		}
+		*item = &memoryItem{value: next, expiresAt: next}
+	})
+}
+
+// SlidingWindowCounter estimates and counts the request in one step, holding
+// the locks of both window counters like the Redis script does.
+func (m *MemoryStore) SlidingWindowCounter(key string, limit int64, cost int64, window time.Duration, now time.Time) (int64, bool, error) {
+	var estimate int64
+	allowed := false
+	keys := []string{windowCounterKey(key, window, now, -1), windowCounterKey(key, window, now, 0)}
+	m.updateAll(keys, now, func(items []*memoryItem) {
+		var previous, current int64
+		if items[0] != nil {
+			previous = int64(float64(items[0].value.(int64)) * windowOverlap(window, now))
+		}
+		if items[1] != nil {
+			current = items[1].value.(int64)
+		}
+
+		estimate = previous + current
+		if estimate+cost > limit {
+			return
+		}
+		estimate += cost
+		allowed = true
+		// The counter is still read as the previous window during the next one.
+		items[1] = &memoryItem{value: current + cost, expiresAt: now.Add(2 * window)}
+	})
+	return estimate, allowed, nil
+}
+
+func (m *MemoryStore) RefundCount(key string, cost int64) error {
+	m.refundCounter("limit::"+key, cost)
+	return nil
+}
+
+func (m *MemoryStore) RefundWindowCounter(key string, cost int64, window time.Duration, chargedAt time.Time) error {
+	m.refundCounter(windowCounterKey(key, window, chargedAt, 0), cost)
+	return nil
+}
+
+// refundCounter takes the cost of a request back off a counter, keeping its
+// expiry.
+func (m *MemoryStore) refundCounter(counterKey string, cost int64) {
+	m.update(counterKey, m.now(), func(item **memoryItem) {
+		if *item == nil {
+			return
+		}
+		count := (*item).value.(int64) - cost
+		if count < 0 {
+			count = 0
+		}
+		(*item).value = count
+	})
+}
+
+func (m *MemoryStore) AcquireSlot(key string, id string, maxInFlight int64, lease time.Duration, now time.Time) (int64, bool, error) {
+	var count int64
+	acquired := false
+	m.update("inflight::"+key, now, func(item **memoryItem) {
+		slots := make(map[string]time.Time)
+		if *item != nil {
+			for slotId, expiry := range (*item).value.(map[string]time.Time) {
+				if expiry.After(now) {
+					slots[slotId] = expiry
+				}
+			}
+		}
+
+		count = int64(len(slots))
+		if count < maxInFlight {
+			slots[id] = now.Add(lease)
+			count++
+			acquired = true
+		}
+		*item = &memoryItem{value: slots, expiresAt: latestExpiry(slots)}
+	})
+	return count, acquired, nil
+}
+
+func (m *MemoryStore) RenewSlot(key string, id string, lease time.Duration, now time.Time) error {
+	m.update("inflight::"+key, now, func(item **memoryItem) {
+		if *item == nil {
+			return
+		}
+		slots := (*item).value.(map[string]time.Time)
+		if _, held := slots[id]; held {
+			slots[id] = now.Add(lease)
+			(*item).expiresAt = latestExpiry(slots)
+		}
+	})
+	return nil
+}
+
+func (m *MemoryStore) ReleaseSlot(key string, id string) error {
+	m.update("inflight::"+key, m.now(), func(item **memoryItem) {
+		if *item == nil {
+			return
+		}
+		slots := (*item).value.(map[string]time.Time)
+		delete(slots, id)
+		if len(slots) == 0 {
+			*item = nil
+		}
+	})
+	return nil
+}
+
+func (m *MemoryStore) SaveAccessRule(rule AccessRule) error {
+	m.updateAccessRules(func(rules map[string]AccessRule) {
+		rules[rule.id()] = rule
+	})
+	return nil
+}
+
+func (m *MemoryStore) DeleteAccessRule(rule AccessRule) error {
+	found := false
+	m.updateAccessRules(func(rules map[string]AccessRule) {
+		_, found = rules[rule.id()]
+		delete(rules, rule.id())
+	})
+	if !found {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (m *MemoryStore) GetAccessRules() ([]AccessRule, error) {
+	var rules []AccessRule
+	m.update("access::rules", m.now(), func(item **memoryItem) {
+		if *item == nil {
+			return
+		}
+		for _, rule := range (*item).value.(map[string]AccessRule) {
+			rules = append(rules, rule)
+		}
+	})
+	sort.Slice(rules, func(i, j int) bool { return rules[i].id() < rules[j].id() })
+	return rules, nil
+}
+
+func (m *MemoryStore) GetAccessVersion() (int64, error) {
+	version, found := m.get("access::version")
+	if !found {
+		return 0, nil
+	}
+	return version.(int64), nil
+}
+
+// updateAccessRules runs fn on a copy of the access rules, stores it and bumps
+// the access version.
+func (m *MemoryStore) updateAccessRules(fn func(rules map[string]AccessRule)) {
+	now := m.now()
+	m.update("access::rules", now, func(item **memoryItem) {
+		rules := map[string]AccessRule{}
+		if *item != nil {
+			for id, rule := range (*item).value.(map[string]AccessRule) {
+				rules[id] = rule
+			}
+		}
+		fn(rules)
+		*item = &memoryItem{value: rules}
+	})
+	m.update("access::version", now, func(item **memoryItem) {
+		var version int64
+		if *item != nil {
+			version = (*item).value.(int64)
+		}
+		*item = &memoryItem{value: version + 1}
+	})
+}
+
+func latestExpiry(slots map[string]time.Time) time.Time {
+	var latest time.Time
+	for _, expiry := range slots {
+		if expiry.After(latest) {
+			latest = expiry
+		}
+	}
+	return latest
+}
@@ -0,0 +1,644 @@
+package ratelimiter
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestLimitDataMemory tests the limit data functions of the MemoryStore
+func TestLimitDataMemory(t *testing.T) {
+	store := NewMemoryStore()
+	defer store.Close()
+
+	_, err := store.GetInfoLimitData("testKey")
+	assert.Equal(t, ErrNotFound, err)
+	assert.Equal(t, ErrNotFound, store.UpdateLimitData("testKey", LimitDataInput{}))
+
+	err = store.SaveInfoLimitData("testKey", LimitData{Key: "testKey", Seconds: 5, MaxRequests: 2, Id: "testId"})
+	assert.NoError(t, err)
+	err = store.SaveInfoLimitData("otherKey", LimitData{Key: "otherKey", Seconds: 5})
+	assert.NoError(t, err)
+
+	err = store.UpdateLimitData("testKey", LimitDataInput{BlockDuration: 30})
+	assert.NoError(t, err)
+
+	data, err := store.GetInfoLimitData("testKey")
+	assert.NoError(t, err)
+	assert.Equal(t, LimitData{Key: "testKey", Seconds: 5, MaxRequests: 2, BlockDuration: 30, Id: "testId"}, data)
+
+	dryRun := true
+	assert.NoError(t, store.UpdateLimitData("testKey", LimitDataInput{DryRun: &dryRun}))
+	data, _ = store.GetInfoLimitData("testKey")
+	assert.True(t, data.DryRun)
+	dryRun = false
+	assert.NoError(t, store.UpdateLimitData("testKey", LimitDataInput{DryRun: &dryRun}))
+	data, _ = store.GetInfoLimitData("testKey")
+	assert.False(t, data.DryRun)
+
+	all, err := store.GetAllLimitData()
+	assert.NoError(t, err)
+	assert.Len(t, all, 2)
+	assert.Equal(t, "otherKey", all[0].Key)
+}
+
+// TestLimitDataIndexMemory tests the id index and DeleteLimitData of the MemoryStore
+func TestLimitDataIndexMemory(t *testing.T) {
+	store := NewMemoryStore()
+	defer store.Close()
+
+	assert.NoError(t, store.SaveInfoLimitData("testKey", LimitData{Key: "testKey", Seconds: 5, Id: "testId"}))
+	key, err := store.GetKeyByID("testId")
+	assert.NoError(t, err)
+	assert.Equal(t, "testKey", key)
+
+	assert.NoError(t, store.DeleteLimitData("testKey"))
+	_, err = store.GetInfoLimitData("testKey")
+	assert.Equal(t, ErrNotFound, err)
+	_, err = store.GetKeyByID("testId")
+	assert.Equal(t, ErrNotFound, err)
+	assert.Equal(t, ErrNotFound, store.DeleteLimitData("testKey"))
+}
+
+// TestCreateLimitDataMemory tests that CreateLimitData refuses a key or an id that is taken
+func TestCreateLimitDataMemory(t *testing.T) {
+	store := NewMemoryStore()
+	defer store.Close()
+
+	assert.NoError(t, store.CreateLimitData("testKey", LimitData{Key: "testKey", Seconds: 5, Id: "testId"}))
+	assert.Equal(t, ErrExists, store.CreateLimitData("testKey", LimitData{Key: "testKey", Seconds: 10, Id: "otherId"}))
+	assert.Equal(t, ErrExists, store.CreateLimitData("otherKey", LimitData{Key: "otherKey", Seconds: 10, Id: "testId"}))
+
+	data, err := store.GetInfoLimitData("testKey")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), data.Seconds)
+	_, err = store.GetInfoLimitData("otherKey")
+	assert.Equal(t, ErrNotFound, err)
+	_, err = store.GetKeyByID("otherId")
+	assert.Equal(t, ErrNotFound, err)
+}
+
+// TestBlockMemory tests that blocks expire with their duration
+func TestBlockMemory(t *testing.T) {
+	store := NewMemoryStore()
+	defer store.Close()
+	now := time.Now()
+	store.now = func() time.Time { return now }
+
+	err := store.SetBlockDuration("blocked:testKey", 1, time.Second)
+	assert.NoError(t, err)
+
+	val, err := store.GetBlockDuration("blocked:testKey")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), val)
+
+	ttl, blocked, err := store.GetBlockTTL("blocked:testKey")
+	assert.NoError(t, err)
+	assert.True(t, blocked)
+	assert.Equal(t, time.Second, ttl)
+
+	now = now.Add(time.Second)
+	val, err = store.GetBlockDuration("blocked:testKey")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), val)
+
+	_, blocked, err = store.GetBlockTTL("blocked:testKey")
+	assert.NoError(t, err)
+	assert.False(t, blocked)
+}
+
+// TestResetMemory tests that resets delete the counters and blocks of the matching keys
+func TestResetMemory(t *testing.T) {
+	store := NewMemoryStore()
+	defer store.Close()
+	now := time.Now()
+	store.now = func() time.Time { return now }
+
+	for _, key := range []string{"user:1", "user:2", "admin"} {
+		assert.NoError(t, store.SaveInfoLimitData(key, LimitData{Key: key, Seconds: 10, MaxRequests: 1}))
+		for i := 0; i < 2; i++ {
+			_, err := store.CheckAndIncrement(key, 1, 1, 10*time.Second, 30*time.Second)
+			assert.NoError(t, err)
+		}
+		_, _, err := store.SlidingWindowCounter(key, 10, 1, 10*time.Second, now)
+		assert.NoError(t, err)
+	}
+
+	unblocked, err := store.Unblock("admin")
+	assert.NoError(t, err)
+	assert.True(t, unblocked)
+	unblocked, err = store.Unblock("admin")
+	assert.NoError(t, err)
+	assert.False(t, unblocked)
+
+	count, err := store.UnblockMatching("user:*")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), count)
+
+	decision, err := store.CheckAndIncrement("user:1", 1, 1, 10*time.Second, 30*time.Second)
+	assert.NoError(t, err)
+	assert.True(t, decision.Limited, "unblocking keeps the counters")
+
+	assert.NoError(t, store.ResetKey("user:1"))
+	decision, err = store.CheckAndIncrement("user:1", 1, 1, 10*time.Second, 30*time.Second)
+	assert.NoError(t, err)
+	assert.False(t, decision.Limited)
+	count, _, err = store.SlidingWindowCounter("user:1", 10, 1, 10*time.Second, now)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+
+	count, err = store.ResetMatching("*")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), count)
+	count, _, err = store.SlidingWindowCounter("admin", 10, 1, 10*time.Second, now)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+
+	all, err := store.GetAllLimitData()
+	assert.NoError(t, err)
+	assert.Len(t, all, 3)
+}
+
+// TestListBlockedMemory tests that blocks are listed by key with their info
+func TestListBlockedMemory(t *testing.T) {
+	store := NewMemoryStore()
+	defer store.Close()
+	now := time.Now()
+	store.now = func() time.Time { return now }
+	limiter := NewRateLimiter(store)
+	limiter.now = store.now
+
+	data := LimitData{Id: "limit-1", Seconds: 10, MaxRequests: 1, BlockDuration: 30}
+	for i := 0; i < 2; i++ {
+		_, err := limiter.Evaluate("user:1", data)
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, limiter.Block("user:2", 0))
+	assert.NoError(t, store.SetBlockDuration("blocked:user:3", 1, time.Minute))
+
+	page, err := store.ListBlocked("", 2)
+	assert.NoError(t, err)
+	assert.Equal(t, "user:2", page.NextCursor)
+	expiresAt := now.Add(30 * time.Second)
+	assert.Equal(t, []BlockInfo{
+		{Key: "user:1", Type: BlockTypeLimit, BlockedAt: &now, ExpiresAt: &expiresAt, Policy: "limit-1"},
+		{Key: "user:2", Type: BlockTypeLimit, BlockedAt: &now},
+	}, page.Items)
+
+	page, err = store.ListBlocked(page.NextCursor, 2)
+	assert.NoError(t, err)
+	expiresAt = now.Add(time.Minute)
+	assert.Equal(t, BlockedPage{Items: []BlockInfo{{Key: "user:3", ExpiresAt: &expiresAt}}}, page)
+
+	_, err = store.Unblock("user:1")
+	assert.NoError(t, err)
+	_, found := store.get("blockinfo::user:1")
+	assert.False(t, found)
+}
+
+// TestCheckAndIncrementMemoryConcurrent tests that concurrent requests never
+// get past the limit and that the request over it always sets the block
+func TestCheckAndIncrementMemoryConcurrent(t *testing.T) {
+	store := NewMemoryStore()
+	defer store.Close()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	allowed, blocked := 0, 0
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			decision, err := store.CheckAndIncrement("testKey", 10, 1, time.Minute, time.Minute)
+			assert.NoError(t, err)
+			mu.Lock()
+			defer mu.Unlock()
+			if !decision.Limited {
+				allowed++
+			}
+			if decision.Blocked {
+				blocked++
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, 10, allowed)
+	assert.Equal(t, 1, blocked)
+}
+
+// TestCheckAndIncrementMemory tests the CheckAndIncrement function of the MemoryStore
+func TestCheckAndIncrementMemory(t *testing.T) {
+	store := NewMemoryStore()
+	defer store.Close()
+	now := time.Now()
+	store.now = func() time.Time { return now }
+
+	for i := int64(1); i <= 2; i++ {
+		decision, err := store.CheckAndIncrement("testKey", 2, 1, 10*time.Second, 30*time.Second)
+		assert.NoError(t, err)
+		assert.False(t, decision.Limited)
+		assert.Equal(t, 2-i, decision.Remaining)
+	}
+
+	decision, err := store.CheckAndIncrement("testKey", 2, 1, 10*time.Second, 30*time.Second)
+	assert.NoError(t, err)
+	assert.True(t, decision.Limited)
+	assert.Equal(t, 30*time.Second, decision.RetryAfter)
+
+	now = now.Add(20 * time.Second)
+	decision, err = store.CheckAndIncrement("testKey", 2, 1, 10*time.Second, 30*time.Second)
+	assert.NoError(t, err)
+	assert.True(t, decision.Limited)
+	assert.Equal(t, 10*time.Second, decision.RetryAfter)
+
+	now = now.Add(10 * time.Second)
+	decision, err = store.CheckAndIncrement("testKey", 2, 1, 10*time.Second, 30*time.Second)
+	assert.NoError(t, err)
+	assert.False(t, decision.Limited)
+	assert.Equal(t, int64(1), decision.Remaining)
+}
+
+// TestAccessRulesMemory tests the access rule functions of the MemoryStore
+func TestAccessRulesMemory(t *testing.T) {
+	store := NewMemoryStore()
+	defer store.Close()
+
+	version, err := store.GetAccessVersion()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), version)
+
+	assert.NoError(t, store.SaveAccessRule(AccessRule{List: AccessDeny, Match: MatchCIDR, Value: "203.0.113.0/24"}))
+	assert.NoError(t, store.SaveAccessRule(AccessRule{List: AccessAllow, Match: MatchKey, Value: "health"}))
+
+	rules, err := store.GetAccessRules()
+	assert.NoError(t, err)
+	assert.Equal(t, []AccessRule{
+		{List: AccessAllow, Match: MatchKey, Value: "health"},
+		{List: AccessDeny, Match: MatchCIDR, Value: "203.0.113.0/24"},
+	}, rules)
+
+	assert.NoError(t, store.DeleteAccessRule(AccessRule{List: AccessAllow, Match: MatchKey, Value: "health"}))
+	assert.Equal(t, ErrNotFound, store.DeleteAccessRule(AccessRule{List: AccessAllow, Match: MatchKey, Value: "health"}))
+
+	version, err = store.GetAccessVersion()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), version)
+}
+
+// TestRequestCostMemory tests that a request costing more than what is left consumes nothing
+func TestRequestCostMemory(t *testing.T) {
+	store := NewMemoryStore()
+	defer store.Close()
+	now := time.Now()
+
+	decision, err := store.CheckAndIncrement("testKey", 5, 3, 10*time.Second, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), decision.Remaining)
+	decision, err = store.CheckAndIncrement("testKey", 5, 3, 10*time.Second, 0)
+	assert.NoError(t, err)
+	assert.True(t, decision.Limited)
+	decision, err = store.CheckAndIncrement("testKey", 5, 2, 10*time.Second, 0)
+	assert.NoError(t, err)
+	assert.False(t, decision.Limited)
+
+	count, allowed, err := store.SlidingWindowLog("testKey", uuid.New().String(), 5, 4, time.Second, now)
+	assert.NoError(t, err)
+	assert.True(t, allowed)
+	assert.Equal(t, int64(4), count)
+	count, allowed, err = store.SlidingWindowLog("testKey", uuid.New().String(), 5, 2, time.Second, now)
+	assert.NoError(t, err)
+	assert.False(t, allowed)
+	assert.Equal(t, int64(4), count)
+
+	_, allowed, err = store.TakeToken("testKey", 5, 1, 4, now)
+	assert.NoError(t, err)
+	assert.True(t, allowed)
+	tokens, allowed, err := store.TakeToken("testKey", 5, 1, 2, now)
+	assert.NoError(t, err)
+	assert.False(t, allowed)
+	assert.Equal(t, 1.0, tokens)
+
+	decision, err = store.GCRA("testKey", time.Second, 5, 4, now)
+	assert.NoError(t, err)
+	assert.False(t, decision.Limited)
+	assert.Equal(t, int64(1), decision.Remaining)
+	decision, err = store.GCRA("testKey", time.Second, 5, 2, now)
+	assert.NoError(t, err)
+	assert.True(t, decision.Limited)
+	assert.Equal(t, time.Second, decision.RetryAfter)
+
+	_, allowed, err = store.ReserveSlot("testKey", time.Second, 2, 0, 3, now)
+	assert.NoError(t, err)
+	assert.True(t, allowed)
+	wait, allowed, err := store.ReserveSlot("testKey", time.Second, 2, 0, 1, now)
+	assert.NoError(t, err)
+	assert.False(t, allowed)
+	assert.Equal(t, 3*time.Second, wait)
+}
+
+// TestRefundMemory tests that a refund gives back what a request was charged
+func TestRefundMemory(t *testing.T) {
+	store := NewMemoryStore()
+	defer store.Close()
+	now := time.Now()
+	store.now = func() time.Time { return now }
+
+	_, err := store.CheckAndIncrement("testKey", 5, 3, 10*time.Second, 0)
+	assert.NoError(t, err)
+	assert.NoError(t, store.RefundCount("testKey", 3))
+	decision, err := store.CheckAndIncrement("testKey", 5, 5, 10*time.Second, 0)
+	assert.NoError(t, err)
+	assert.False(t, decision.Limited)
+
+	_, _, err = store.SlidingWindowCounter("testKey", 5, 3, 10*time.Second, now)
+	assert.NoError(t, err)
+	assert.NoError(t, store.RefundWindowCounter("testKey", 3, 10*time.Second, now))
+	_, allowed, err := store.SlidingWindowCounter("testKey", 5, 5, 10*time.Second, now)
+	assert.NoError(t, err)
+	assert.True(t, allowed)
+
+	_, _, err = store.SlidingWindowLog("testKey", "first", 5, 2, time.Second, now)
+	assert.NoError(t, err)
+	_, _, err = store.SlidingWindowLog("testKey", "second", 5, 2, time.Second, now.Add(500*time.Millisecond))
+	assert.NoError(t, err)
+	assert.NoError(t, store.RefundWindowLog("testKey", "first", 2))
+	// The second request, of the same cost, is still logged once the first
+	// would have left the window.
+	count, allowed, err := store.SlidingWindowLog("testKey", "third", 5, 3, time.Second, now.Add(time.Second))
+	assert.NoError(t, err)
+	assert.True(t, allowed)
+	assert.Equal(t, int64(5), count)
+
+	_, _, err = store.TakeToken("testKey", 5, 1, 3, now)
+	assert.NoError(t, err)
+	assert.NoError(t, store.RefundTokens("testKey", 5, 4))
+	tokens, allowed, err := store.TakeToken("testKey", 5, 1, 5, now)
+	assert.NoError(t, err)
+	assert.True(t, allowed)
+	assert.Equal(t, 0.0, tokens)
+
+	_, err = store.GCRA("testKey", time.Second, 5, 3, now)
+	assert.NoError(t, err)
+	assert.NoError(t, store.RefundGCRA("testKey", time.Second, 3, now))
+	decision, err = store.GCRA("testKey", time.Second, 5, 5, now)
+	assert.NoError(t, err)
+	assert.False(t, decision.Limited)
+
+	_, _, err = store.ReserveSlot("testKey", time.Second, 2, 0, 3, now)
+	assert.NoError(t, err)
+	assert.NoError(t, store.RefundSlot("testKey", time.Second, 3, now))
+	wait, allowed, err := store.ReserveSlot("testKey", time.Second, 2, 0, 1, now)
+	assert.NoError(t, err)
+	assert.True(t, allowed)
+	assert.Equal(t, time.Duration(0), wait)
+}
+
+// TestOversizedCostMemory tests that a request costing more than the limit is refused without blocking the key
+func TestOversizedCostMemory(t *testing.T) {
+	store := NewMemoryStore()
+	defer store.Close()
+
+	decision, err := store.CheckAndIncrement("testKey", 5, 6, 10*time.Second, time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, decision.Limited)
+	assert.False(t, decision.Blocked)
+
+	decision, err = store.CheckAndIncrement("testKey", 5, 1, 10*time.Second, time.Minute)
+	assert.NoError(t, err)
+	assert.False(t, decision.Limited)
+}
+
+// TestSlidingWindowLogMemory tests the SlidingWindowLog function of the MemoryStore
+func TestSlidingWindowLogMemory(t *testing.T) {
+	store := NewMemoryStore()
+	defer store.Close()
+
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		_, allowed, err := store.SlidingWindowLog("testKey", uuid.New().String(), 2, 1, time.Second, now)
+		assert.NoError(t, err)
+		assert.True(t, allowed)
+	}
+
+	_, allowed, err := store.SlidingWindowLog("testKey", uuid.New().String(), 2, 1, time.Second, now.Add(999*time.Millisecond))
+	assert.NoError(t, err)
+	assert.False(t, allowed)
+
+	count, allowed, err := store.SlidingWindowLog("testKey", uuid.New().String(), 2, 1, time.Second, now.Add(time.Second))
+	assert.NoError(t, err)
+	assert.True(t, allowed)
+	assert.Equal(t, int64(1), count)
+}
+
+// TestTakeTokenMemory tests the TakeToken function of the MemoryStore
+func TestTakeTokenMemory(t *testing.T) {
+	store := NewMemoryStore()
+	defer store.Close()
+
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		_, allowed, err := store.TakeToken("testKey", 2, 1, 1, now)
+		assert.NoError(t, err)
+		assert.True(t, allowed)
+	}
+
+	tokens, allowed, err := store.TakeToken("testKey", 2, 1, 1, now.Add(500*time.Millisecond))
+	assert.NoError(t, err)
+	assert.False(t, allowed)
+	assert.InDelta(t, 0.5, tokens, 0.001)
+}
+
+// TestGCRAMemory tests the GCRA function of the MemoryStore
+func TestGCRAMemory(t *testing.T) {
+	store := NewMemoryStore()
+	defer store.Close()
+
+	now := time.Now()
+	for i := int64(1); i <= 3; i++ {
+		decision, err := store.GCRA("testKey", time.Second, 3, 1, now)
+		assert.NoError(t, err)
+		assert.False(t, decision.Limited)
+		assert.Equal(t, 3-i, decision.Remaining)
+	}
+
+	decision, err := store.GCRA("testKey", time.Second, 3, 1, now.Add(400*time.Millisecond))
+	assert.NoError(t, err)
+	assert.True(t, decision.Limited)
+	assert.Equal(t, 600*time.Millisecond, decision.RetryAfter)
+}
+
+// TestReserveSlotMemory tests the ReserveSlot function of the MemoryStore
+func TestReserveSlotMemory(t *testing.T) {
+	store := NewMemoryStore()
+	defer store.Close()
+
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		wait, allowed, err := store.ReserveSlot("testKey", time.Second, 2, 0, 1, now)
+		assert.NoError(t, err)
+		assert.True(t, allowed)
+		assert.Equal(t, time.Duration(i)*time.Second, wait)
+	}
+
+	_, allowed, err := store.ReserveSlot("testKey", time.Second, 2, 0, 1, now)
+	assert.NoError(t, err)
+	assert.False(t, allowed)
+}
+
+// TestSlidingWindowCounterMemory tests the SlidingWindowCounter function of the MemoryStore
+func TestSlidingWindowCounterMemory(t *testing.T) {
+	store := NewMemoryStore()
+	defer store.Close()
+
+	previous := time.Unix(1000, 0)
+	store.now = func() time.Time { return previous }
+	for i := int64(1); i <= 3; i++ {
+		count, allowed, err := store.SlidingWindowCounter("testKey", 4, 1, 10*time.Second, previous)
+		assert.NoError(t, err)
+		assert.True(t, allowed)
+		assert.Equal(t, i, count)
+	}
+
+	// 1s into the next window, 90% of the 3 previous requests still count.
+	now := previous.Add(11 * time.Second)
+	count, allowed, err := store.SlidingWindowCounter("testKey", 4, 1, 10*time.Second, now)
+	assert.NoError(t, err)
+	assert.True(t, allowed)
+	assert.Equal(t, int64(3), count)
+	count, allowed, err = store.SlidingWindowCounter("testKey", 4, 2, 10*time.Second, now)
+	assert.NoError(t, err)
+	assert.False(t, allowed)
+	assert.Equal(t, int64(3), count)
+}
+
+// TestSlotsMemory tests the AcquireSlot, RenewSlot and ReleaseSlot functions of the MemoryStore
+func TestSlotsMemory(t *testing.T) {
+	store := NewMemoryStore()
+	defer store.Close()
+
+	now := time.Now()
+	_, acquired, err := store.AcquireSlot("testKey", "first", 1, time.Second, now)
+	assert.NoError(t, err)
+	assert.True(t, acquired)
+
+	_, acquired, err = store.AcquireSlot("testKey", "second", 1, time.Second, now)
+	assert.NoError(t, err)
+	assert.False(t, acquired)
+
+	assert.NoError(t, store.RenewSlot("testKey", "first", time.Second, now.Add(500*time.Millisecond)))
+	_, acquired, err = store.AcquireSlot("testKey", "second", 1, time.Second, now.Add(time.Second))
+	assert.NoError(t, err)
+	assert.False(t, acquired)
+
+	assert.NoError(t, store.ReleaseSlot("testKey", "first"))
+	_, acquired, err = store.AcquireSlot("testKey", "second", 1, time.Second, now.Add(time.Second))
+	assert.NoError(t, err)
+	assert.True(t, acquired)
+}
+
+// TestJanitorMemory tests that the janitor evicts expired entries
+func TestJanitorMemory(t *testing.T) {
+	store := newMemoryStore(10 * time.Millisecond)
+	defer store.Close()
+
+	err := store.SetBlockDuration("blocked:testKey", 1, time.Millisecond)
+	assert.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		shard := store.shard("blocked:testKey")
+		shard.mu.Lock()
+		defer shard.mu.Unlock()
+		return len(shard.items) == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+// TestListLimitDataMemory tests that limit data is listed page by page
+func TestListLimitDataMemory(t *testing.T) {
+	store := NewMemoryStore()
+	defer store.Close()
+
+	for _, key := range []string{"c", "a", "b"} {
+		assert.NoError(t, store.SaveInfoLimitData(key, LimitData{Key: key}))
+	}
+
+	page, err := store.ListLimitData("", 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []LimitData{{Key: "a"}, {Key: "b"}}, page.Items)
+	assert.Equal(t, "b", page.NextCursor)
+
+	page, err = store.ListLimitData(page.NextCursor, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []LimitData{{Key: "c"}}, page.Items)
+	assert.Equal(t, "", page.NextCursor)
+}
+
+// TestListPageSizeMemory tests that the RateLimiter lists a default page for page sizes under one
+func TestListPageSizeMemory(t *testing.T) {
+	store := NewMemoryStore()
+	defer store.Close()
+	limiter := NewRateLimiter(store)
+
+	for _, key := range []string{"a", "b"} {
+		assert.NoError(t, store.SaveInfoLimitData(key, LimitData{Key: key}))
+		assert.NoError(t, limiter.Block(key, time.Minute))
+	}
+
+	for _, pageSize := range []int64{0, -1} {
+		limits, err := limiter.ListLimitData("", pageSize)
+		assert.NoError(t, err)
+		assert.Len(t, limits.Items, 2)
+		assert.Equal(t, "", limits.NextCursor)
+
+		blocks, err := limiter.ListBlocked("", pageSize)
+		assert.NoError(t, err)
+		assert.Len(t, blocks.Items, 2)
+		assert.Equal(t, "", blocks.NextCursor)
+	}
+}
+
+func TestBanMemory(t *testing.T) {
+	store := NewMemoryStore()
+	defer store.Close()
+	now := time.Now()
+	store.now = func() time.Time { return now }
+	limiter := NewRateLimiter(store)
+	limiter.now = store.now
+
+	ban, err := limiter.Ban("user:1", time.Hour, "abuse", "alice")
+	assert.NoError(t, err)
+	expiresAt := now.Add(time.Hour)
+	assert.Equal(t, BlockInfo{Key: "user:1", Type: BlockTypeBan, BlockedAt: &now, ExpiresAt: &expiresAt, Reason: "abuse", Actor: "alice"}, ban)
+	assert.NoError(t, limiter.Block("user:2", time.Hour))
+
+	got, err := limiter.GetBan("user:1")
+	assert.NoError(t, err)
+	assert.Equal(t, ban, got)
+	_, err = limiter.GetBan("user:2")
+	assert.Equal(t, ErrNotFound, err)
+
+	decision, err := limiter.Evaluate("user:1", LimitData{Seconds: 10, MaxRequests: 5})
+	assert.NoError(t, err)
+	assert.True(t, decision.Limited)
+
+	// Resets and unblocks leave the ban in place.
+	assert.NoError(t, limiter.Reset("user:1"))
+	count, err := limiter.ResetMatching("user:*")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+	count, err = limiter.UnblockMatching("user:*")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), count)
+	_, err = limiter.Unblock("user:1")
+	assert.Equal(t, ErrBanned, err)
+	_, err = limiter.GetBan("user:1")
+	assert.NoError(t, err)
+
+	assert.NoError(t, limiter.Unban("user:1"))
+	_, err = limiter.GetBan("user:1")
+	assert.Equal(t, ErrNotFound, err)
+	assert.Equal(t, ErrNotFound, limiter.Unban("user:1"))
+}
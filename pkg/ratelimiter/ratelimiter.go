@@ -1,44 +1,210 @@
 package ratelimiter
 
 import (
+	"errors"
+	"fmt"
 	"time"
 )
 
+var (
+	// ErrNotFound is returned by the stores when a key holds no limit data.
+	ErrNotFound = errors.New("key not found")
+	// ErrInvalidCursor is returned when a listing cursor was not issued by the store.
+	ErrInvalidCursor = errors.New("invalid cursor")
+	// ErrExists is returned when creating limit data for a key that already has
+	// some, or with an id that is already taken.
+	ErrExists = errors.New("limit data already exists")
+)
+
+// DefaultPageSize is the page size used when listing everything at once.
+const DefaultPageSize = 100
+
 // LimitData godoc
 // @Summary Struct to store rate limiter data
 // @Description Struct to store rate limiter data
 type LimitData struct {
-	Key           string `json:"key"`
-	Seconds       int64  `json:"seconds"`
-	BlockDuration int64  `json:"block_duration"`
-	MaxRequests   int64  `json:"max_requests"`
-	Id            string `json:"id"`
+	Key             string  `json:"key"`
+	Seconds         int64   `json:"seconds"`
+	BlockDuration   int64   `json:"block_duration"`
+	MaxRequests     int64   `json:"max_requests"`
+	Id              string  `json:"id"`
+	Algorithm       string  `json:"algorithm"`
+	Capacity        int64   `json:"capacity"`
+	RefillPerSecond float64 `json:"refill_per_second"`
+	LeakPerSecond   float64 `json:"leak_per_second"`
+	MaxQueue        int64   `json:"max_queue"`
+	MaxWait         int64   `json:"max_wait"`
+	MaxInFlight     int64   `json:"max_in_flight"`
+	LeaseSeconds    int64   `json:"lease_seconds"`
+	// DryRun makes the limit be counted and evaluated without refusing the
+	// requests it would have limited or blocking their key.
+	DryRun bool `json:"dry_run"`
+}
+
+// LimitDataPage is a page of limit data. NextCursor is empty on the last page.
+type LimitDataPage struct {
+	Items      []LimitData `json:"items"`
+	NextCursor string      `json:"next_cursor"`
 }
 
 type LimitDataInput struct {
-	Key           string `json:"key"`
-	Seconds       int64  `json:"seconds"`
-	BlockDuration int64  `json:"block_duration"`
-	MaxRequests   int64  `json:"max_requests"`
+	Key             string  `json:"key"`
+	Seconds         int64   `json:"seconds"`
+	BlockDuration   int64   `json:"block_duration"`
+	MaxRequests     int64   `json:"max_requests"`
+	Algorithm       string  `json:"algorithm"`
+	Capacity        int64   `json:"capacity"`
+	RefillPerSecond float64 `json:"refill_per_second"`
+	LeakPerSecond   float64 `json:"leak_per_second"`
+	MaxQueue        int64   `json:"max_queue"`
+	MaxWait         int64   `json:"max_wait"`
+	MaxInFlight     int64   `json:"max_in_flight"`
+	LeaseSeconds    int64   `json:"lease_seconds"`
+	DryRun          *bool   `json:"dry_run"`
+}
+
+// IsConfigured reports whether the limit data carries the settings its
+// algorithm needs to be evaluated.
+func (d LimitData) IsConfigured() bool {
+	switch d.Algorithm {
+	case AlgorithmTokenBucket:
+		return d.Capacity > 0 && d.RefillPerSecond > 0
+	case AlgorithmLeakyBucket:
+		return leakInterval(d.LeakPerSecond) > 0
+	case AlgorithmGCRA:
+		return d.Seconds > 0 && d.MaxRequests > 0
+	default:
+		return d.Seconds != 0
+	}
+}
+
+// Merge returns a copy of the limit data with every non-zero field of data
+// applied on top of it. DryRun is applied whenever it is set, so it can be
+// turned off.
+func (d LimitData) Merge(data LimitDataInput) LimitData {
+	if data.Seconds != 0 {
+		d.Seconds = data.Seconds
+	}
+
+	if data.BlockDuration != 0 {
+		d.BlockDuration = data.BlockDuration
+	}
+
+	if data.MaxRequests != 0 {
+		d.MaxRequests = data.MaxRequests
+	}
+
+	if data.Algorithm != "" {
+		d.Algorithm = data.Algorithm
+	}
+
+	if data.Capacity != 0 {
+		d.Capacity = data.Capacity
+	}
+
+	if data.RefillPerSecond != 0 {
+		d.RefillPerSecond = data.RefillPerSecond
+	}
+
+	if data.LeakPerSecond != 0 {
+		d.LeakPerSecond = data.LeakPerSecond
+	}
+
+	if data.MaxQueue != 0 {
+		d.MaxQueue = data.MaxQueue
+	}
+
+	if data.MaxWait != 0 {
+		d.MaxWait = data.MaxWait
+	}
+
+	if data.MaxInFlight != 0 {
+		d.MaxInFlight = data.MaxInFlight
+	}
+
+	if data.LeaseSeconds != 0 {
+		d.LeaseSeconds = data.LeaseSeconds
+	}
+
+	if data.DryRun != nil {
+		d.DryRun = *data.DryRun
+	}
+	return d
+}
+
+// Window returns the period over which the limit data grants a full quota,
+// which for the bucket algorithms is the time it takes to refill or drain it.
+func (d LimitData) Window() time.Duration {
+	switch d.Algorithm {
+	case AlgorithmTokenBucket:
+		return refillTime(float64(d.Capacity), d.RefillPerSecond)
+	case AlgorithmLeakyBucket:
+		return refillTime(float64(d.MaxQueue), d.LeakPerSecond)
+	default:
+		return time.Duration(d.Seconds) * time.Second
+	}
 }
 
 type Store interface {
 	Increment(key string, seconds int64) (int64, error)
+	CheckAndIncrement(key string, limit int64, cost int64, window time.Duration, blockDuration time.Duration) (Decision, error)
 	SaveInfoLimitData(key string, data LimitData) error
+	CreateLimitData(key string, data LimitData) error
 	GetInfoLimitData(key string) (LimitData, error)
 	SetBlockDuration(key string, value int64, expiration time.Duration) error
 	GetBlockDuration(key string) (int64, error)
+	GetBlockTTL(key string) (time.Duration, bool, error)
+	Unblock(key string) (bool, error)
+	UnblockMatching(pattern string) (int64, error)
+	ResetKey(key string) error
+	ResetMatching(pattern string) (int64, error)
+	SaveBlockInfo(info BlockInfo, expiration time.Duration) error
+	GetBlockInfo(key string) (BlockInfo, error)
+	ListBlocked(cursor string, pageSize int64) (BlockedPage, error)
 	UpdateLimitData(key string, data LimitDataInput) error
 	GetAllLimitData() ([]LimitData, error)
+	ListLimitData(cursor string, pageSize int64) (LimitDataPage, error)
+	DeleteLimitData(key string) error
+	GetKeyByID(id string) (string, error)
+	SlidingWindowLog(key string, id string, limit int64, cost int64, window time.Duration, now time.Time) (int64, bool, error)
+	TakeToken(key string, capacity int64, refillPerSecond float64, cost int64, now time.Time) (float64, bool, error)
+	GCRA(key string, emissionInterval time.Duration, limit int64, cost int64, now time.Time) (Decision, error)
+	ReserveSlot(key string, interval time.Duration, maxQueue int64, maxWait time.Duration, cost int64, now time.Time) (time.Duration, bool, error)
+	SlidingWindowCounter(key string, limit int64, cost int64, window time.Duration, now time.Time) (int64, bool, error)
+	RefundCount(key string, cost int64) error
+	RefundWindowCounter(key string, cost int64, window time.Duration, chargedAt time.Time) error
+	RefundWindowLog(key string, id string, cost int64) error
+	RefundTokens(key string, capacity int64, cost int64) error
+	RefundGCRA(key string, emissionInterval time.Duration, cost int64, now time.Time) error
+	RefundSlot(key string, interval time.Duration, cost int64, now time.Time) error
+	AcquireSlot(key string, id string, maxInFlight int64, lease time.Duration, now time.Time) (int64, bool, error)
+	RenewSlot(key string, id string, lease time.Duration, now time.Time) error
+	ReleaseSlot(key string, id string) error
+	SaveAccessRule(rule AccessRule) error
+	DeleteAccessRule(rule AccessRule) error
+	GetAccessRules() ([]AccessRule, error)
+	GetAccessVersion() (int64, error)
 }
 
 type RateLimiter struct {
-	store Store
+	store      Store
+	strategies map[string]Strategy
+	now        func() time.Time
+	access     accessList
 }
 
 func NewRateLimiter(store Store) *RateLimiter {
 	return &RateLimiter{
 		store: store,
+		strategies: map[string]Strategy{
+			AlgorithmFixedWindow:      fixedWindow{},
+			AlgorithmSlidingWindowLog: slidingWindowLog{},
+			AlgorithmTokenBucket:      tokenBucket{},
+			AlgorithmGCRA:             gcra{},
+			AlgorithmLeakyBucket:      leakyBucket{},
+			AlgorithmSlidingWindow:    slidingWindowCounter{},
+		},
+		now: time.Now,
 	}
 }
 
@@ -46,6 +212,12 @@ func (r *RateLimiter) SetLimitData(key string, data LimitData) error {
 	return r.store.SaveInfoLimitData(key, data)
 }
 
+// CreateLimitData saves the limit data of a key that has none. Of concurrent
+// creates for the same key or id only one succeeds, the others get ErrExists.
+func (r *RateLimiter) CreateLimitData(key string, data LimitData) error {
+	return r.store.CreateLimitData(key, data)
+}
+
 func (r *RateLimiter) GetLimitData(key string) (LimitData, error) {
 	return r.store.GetInfoLimitData(key)
 }
@@ -58,12 +230,50 @@ func (r *RateLimiter) GetLimitDataByIdGenerated(idGenerated string) (LimitData,
 	return r.store.GetInfoLimitData(idGenerated)
 }
 
+// GetLimitDataByID returns the limit data whose Id is id, looked up through
+// the id index of the store.
+func (r *RateLimiter) GetLimitDataByID(id string) (LimitData, error) {
+	key, err := r.store.GetKeyByID(id)
+	if err != nil {
+		return LimitData{}, err
+	}
+	data, err := r.store.GetInfoLimitData(key)
+	if err != nil {
+		return LimitData{}, err
+	}
+	// The index is left behind when the limit data of the key gets a new id.
+	if data.Id != id {
+		return LimitData{}, ErrNotFound
+	}
+	return data, nil
+}
+
+func (r *RateLimiter) DeleteLimitData(key string) error {
+	return r.store.DeleteLimitData(key)
+}
+
 func (r *RateLimiter) GetAllLimitData() ([]LimitData, error) {
 	return r.store.GetAllLimitData()
 }
 
+// ListLimitData lists the limit data one page at a time. A page size under one
+// lists DefaultPageSize limits.
+func (r *RateLimiter) ListLimitData(cursor string, pageSize int64) (LimitDataPage, error) {
+	return r.store.ListLimitData(cursor, pageSizeOrDefault(pageSize))
+}
+
+// pageSizeOrDefault returns pageSize, or DefaultPageSize when it is under one,
+// which the stores cannot page with.
+func pageSizeOrDefault(pageSize int64) int64 {
+	if pageSize < 1 {
+		return DefaultPageSize
+	}
+	return pageSize
+}
+
 func (r *RateLimiter) Block(key string, blockDuration time.Duration) error {
-	return r.store.SetBlockDuration("blocked:"+key, 1, blockDuration)
+	_, err := r.block(BlockInfo{Key: key, Type: BlockTypeLimit}, blockDuration)
+	return err
 }
 
 func (r *RateLimiter) IsBlocked(key string) (bool, error) {
@@ -74,23 +284,80 @@ func (r *RateLimiter) IsBlocked(key string) (bool, error) {
 	return val > 0, nil
 }
 
+// BlockTTL reports whether key is blocked and how much of the block is left.
+// A block without expiry is reported with a zero TTL.
+func (r *RateLimiter) BlockTTL(key string) (time.Duration, bool, error) {
+	return r.store.GetBlockTTL("blocked:" + key)
+}
+
 func (r *RateLimiter) Limit(key string, limit int64, duration int64, blockDuration int64) (bool, error) {
-	if blocked, _ := r.IsBlocked(key); blocked {
-		return true, nil
+	decision, err := r.Evaluate(key, LimitData{
+		Key:           key,
+		Seconds:       duration,
+		BlockDuration: blockDuration,
+		MaxRequests:   limit,
+	})
+	if err != nil {
+		return false, err
 	}
+	return decision.Limited, nil
+}
+
+// Evaluate applies the algorithm named in data to key and returns the decision
+// for the request. An empty algorithm falls back to the fixed window.
+func (r *RateLimiter) Evaluate(key string, data LimitData) (Decision, error) {
+	return r.EvaluateCost(key, data, 1, data.DryRun)
+}
 
-	count, err := r.store.Increment(key, duration)
+// EvaluateCost is Evaluate for a request that consumes cost units of the quota
+// instead of one. A request whose cost does not fit in what is left is limited
+// and consumes nothing. With dryRun, or a DryRun limit, the request is counted
+// and decided as usual but the key is never blocked, since the caller lets the
+// request through anyway.
+func (r *RateLimiter) EvaluateCost(key string, data LimitData, cost int64, dryRun bool) (Decision, error) {
+	if cost < 1 {
+		return Decision{}, fmt.Errorf("invalid request cost %d", cost)
+	}
+
+	strategy, err := r.strategy(data)
 	if err != nil {
-		return false, err
+		return Decision{}, err
+	}
+	data.DryRun = data.DryRun || dryRun
+	decision, err := strategy.Limit(r, key, data, cost)
+	if err == nil && !decision.Limited {
+		decision.charged.cost = cost
 	}
+	return decision, err
+}
+
+// Refund gives back what the request of decision, which EvaluateCost allowed,
+// was charged, for a request that ends up refused by another limit. The quota
+// is restored as far as the algorithm allows: a window that ended in between
+// is not reopened. A decision that charged nothing, like a limited one, has
+// nothing to give back.
+func (r *RateLimiter) Refund(key string, data LimitData, decision Decision) error {
+	if decision.charged.cost == 0 {
+		return nil
+	}
+
+	strategy, err := r.strategy(data)
+	if err != nil {
+		return err
+	}
+	return strategy.Refund(r, key, data, decision.charged)
+}
 
-	if count > limit {
-		err := r.Block(key, time.Duration(blockDuration)*time.Second)
-		if err != nil {
-			return false, err
-		}
-		return true, nil
+// strategy returns the strategy of the algorithm named in data.
+func (r *RateLimiter) strategy(data LimitData) (Strategy, error) {
+	algorithm := data.Algorithm
+	if algorithm == "" {
+		algorithm = AlgorithmFixedWindow
 	}
 
-	return false, nil
+	strategy, ok := r.strategies[algorithm]
+	if !ok {
+		return nil, fmt.Errorf("unknown rate limit algorithm %q", data.Algorithm)
+	}
+	return strategy, nil
 }
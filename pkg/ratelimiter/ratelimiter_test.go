@@ -1,6 +1,8 @@
 package ratelimiter
 
 import (
+	"errors"
+	"strconv"
 	"testing"
 	"time"
 
@@ -8,23 +10,62 @@ import (
 )
 
 type MockStore struct {
-	IncrementFunc         func(key string, seconds int64) (int64, error)
-	SaveInfoLimitDataFunc func(key string, data LimitData) error
-	GetInfoLimitDataFunc  func(key string) (LimitData, error)
-	SetBlockDurationFunc  func(key string, value int64, expiration time.Duration) error
-	GetBlockDurationFunc  func(key string) (int64, error)
-	UpdateLimitDataFunc   func(key string, data LimitDataInput) error
-	GetAllLimitDataFunc   func() ([]LimitData, error)
+	IncrementFunc            func(key string, seconds int64) (int64, error)
+	CheckAndIncrementFunc    func(key string, limit int64, cost int64, window time.Duration, blockDuration time.Duration) (Decision, error)
+	SaveInfoLimitDataFunc    func(key string, data LimitData) error
+	CreateLimitDataFunc      func(key string, data LimitData) error
+	GetInfoLimitDataFunc     func(key string) (LimitData, error)
+	SetBlockDurationFunc     func(key string, value int64, expiration time.Duration) error
+	GetBlockDurationFunc     func(key string) (int64, error)
+	GetBlockTTLFunc          func(key string) (time.Duration, bool, error)
+	UnblockFunc              func(key string) (bool, error)
+	UnblockMatchingFunc      func(pattern string) (int64, error)
+	ResetKeyFunc             func(key string) error
+	ResetMatchingFunc        func(pattern string) (int64, error)
+	SaveBlockInfoFunc        func(info BlockInfo, expiration time.Duration) error
+	GetBlockInfoFunc         func(key string) (BlockInfo, error)
+	ListBlockedFunc          func(cursor string, pageSize int64) (BlockedPage, error)
+	UpdateLimitDataFunc      func(key string, data LimitDataInput) error
+	GetAllLimitDataFunc      func() ([]LimitData, error)
+	ListLimitDataFunc        func(cursor string, pageSize int64) (LimitDataPage, error)
+	SlidingWindowLogFunc     func(key string, id string, limit int64, cost int64, window time.Duration, now time.Time) (int64, bool, error)
+	TakeTokenFunc            func(key string, capacity int64, refillPerSecond float64, cost int64, now time.Time) (float64, bool, error)
+	GCRAFunc                 func(key string, emissionInterval time.Duration, limit int64, cost int64, now time.Time) (Decision, error)
+	ReserveSlotFunc          func(key string, interval time.Duration, maxQueue int64, maxWait time.Duration, cost int64, now time.Time) (time.Duration, bool, error)
+	SlidingWindowCounterFunc func(key string, limit int64, cost int64, window time.Duration, now time.Time) (int64, bool, error)
+	RefundCountFunc          func(key string, cost int64) error
+	RefundWindowCounterFunc  func(key string, cost int64, window time.Duration, chargedAt time.Time) error
+	RefundWindowLogFunc      func(key string, id string, cost int64) error
+	RefundTokensFunc         func(key string, capacity int64, cost int64) error
+	RefundGCRAFunc           func(key string, emissionInterval time.Duration, cost int64, now time.Time) error
+	RefundSlotFunc           func(key string, interval time.Duration, cost int64, now time.Time) error
+	AcquireSlotFunc          func(key string, id string, maxInFlight int64, lease time.Duration, now time.Time) (int64, bool, error)
+	RenewSlotFunc            func(key string, id string, lease time.Duration, now time.Time) error
+	ReleaseSlotFunc          func(key string, id string) error
+	DeleteLimitDataFunc      func(key string) error
+	GetKeyByIDFunc           func(id string) (string, error)
+	SaveAccessRuleFunc       func(rule AccessRule) error
+	DeleteAccessRuleFunc     func(rule AccessRule) error
+	GetAccessRulesFunc       func() ([]AccessRule, error)
+	GetAccessVersionFunc     func() (int64, error)
 }
 
 func (m *MockStore) Increment(key string, seconds int64) (int64, error) {
 	return m.IncrementFunc(key, seconds)
 }
 
+func (m *MockStore) CheckAndIncrement(key string, limit int64, cost int64, window time.Duration, blockDuration time.Duration) (Decision, error) {
+	return m.CheckAndIncrementFunc(key, limit, cost, window, blockDuration)
+}
+
 func (m *MockStore) SaveInfoLimitData(key string, data LimitData) error {
 	return m.SaveInfoLimitDataFunc(key, data)
 }
 
+func (m *MockStore) CreateLimitData(key string, data LimitData) error {
+	return m.CreateLimitDataFunc(key, data)
+}
+
 func (m *MockStore) GetInfoLimitData(key string) (LimitData, error) {
 	return m.GetInfoLimitDataFunc(key)
 }
@@ -37,6 +78,38 @@ func (m *MockStore) GetBlockDuration(key string) (int64, error) {
 	return m.GetBlockDurationFunc(key)
 }
 
+func (m *MockStore) GetBlockTTL(key string) (time.Duration, bool, error) {
+	return m.GetBlockTTLFunc(key)
+}
+
+func (m *MockStore) Unblock(key string) (bool, error) {
+	return m.UnblockFunc(key)
+}
+
+func (m *MockStore) UnblockMatching(pattern string) (int64, error) {
+	return m.UnblockMatchingFunc(pattern)
+}
+
+func (m *MockStore) ResetKey(key string) error {
+	return m.ResetKeyFunc(key)
+}
+
+func (m *MockStore) ResetMatching(pattern string) (int64, error) {
+	return m.ResetMatchingFunc(pattern)
+}
+
+func (m *MockStore) SaveBlockInfo(info BlockInfo, expiration time.Duration) error {
+	return m.SaveBlockInfoFunc(info, expiration)
+}
+
+func (m *MockStore) GetBlockInfo(key string) (BlockInfo, error) {
+	return m.GetBlockInfoFunc(key)
+}
+
+func (m *MockStore) ListBlocked(cursor string, pageSize int64) (BlockedPage, error) {
+	return m.ListBlockedFunc(cursor, pageSize)
+}
+
 func (m *MockStore) UpdateLimitData(key string, data LimitDataInput) error {
 	return m.UpdateLimitDataFunc(key, data)
 }
@@ -45,6 +118,90 @@ func (m *MockStore) GetAllLimitData() ([]LimitData, error) {
 	return m.GetAllLimitDataFunc()
 }
 
+func (m *MockStore) ListLimitData(cursor string, pageSize int64) (LimitDataPage, error) {
+	return m.ListLimitDataFunc(cursor, pageSize)
+}
+
+func (m *MockStore) SlidingWindowLog(key string, id string, limit int64, cost int64, window time.Duration, now time.Time) (int64, bool, error) {
+	return m.SlidingWindowLogFunc(key, id, limit, cost, window, now)
+}
+
+func (m *MockStore) TakeToken(key string, capacity int64, refillPerSecond float64, cost int64, now time.Time) (float64, bool, error) {
+	return m.TakeTokenFunc(key, capacity, refillPerSecond, cost, now)
+}
+
+func (m *MockStore) GCRA(key string, emissionInterval time.Duration, limit int64, cost int64, now time.Time) (Decision, error) {
+	return m.GCRAFunc(key, emissionInterval, limit, cost, now)
+}
+
+func (m *MockStore) ReserveSlot(key string, interval time.Duration, maxQueue int64, maxWait time.Duration, cost int64, now time.Time) (time.Duration, bool, error) {
+	return m.ReserveSlotFunc(key, interval, maxQueue, maxWait, cost, now)
+}
+
+func (m *MockStore) SlidingWindowCounter(key string, limit int64, cost int64, window time.Duration, now time.Time) (int64, bool, error) {
+	return m.SlidingWindowCounterFunc(key, limit, cost, window, now)
+}
+
+func (m *MockStore) RefundCount(key string, cost int64) error {
+	return m.RefundCountFunc(key, cost)
+}
+
+func (m *MockStore) RefundWindowCounter(key string, cost int64, window time.Duration, chargedAt time.Time) error {
+	return m.RefundWindowCounterFunc(key, cost, window, chargedAt)
+}
+
+func (m *MockStore) RefundWindowLog(key string, id string, cost int64) error {
+	return m.RefundWindowLogFunc(key, id, cost)
+}
+
+func (m *MockStore) RefundTokens(key string, capacity int64, cost int64) error {
+	return m.RefundTokensFunc(key, capacity, cost)
+}
+
+func (m *MockStore) RefundGCRA(key string, emissionInterval time.Duration, cost int64, now time.Time) error {
+	return m.RefundGCRAFunc(key, emissionInterval, cost, now)
+}
+
+func (m *MockStore) RefundSlot(key string, interval time.Duration, cost int64, now time.Time) error {
+	return m.RefundSlotFunc(key, interval, cost, now)
+}
+
+func (m *MockStore) AcquireSlot(key string, id string, maxInFlight int64, lease time.Duration, now time.Time) (int64, bool, error) {
+	return m.AcquireSlotFunc(key, id, maxInFlight, lease, now)
+}
+
+func (m *MockStore) RenewSlot(key string, id string, lease time.Duration, now time.Time) error {
+	return m.RenewSlotFunc(key, id, lease, now)
+}
+
+func (m *MockStore) ReleaseSlot(key string, id string) error {
+	return m.ReleaseSlotFunc(key, id)
+}
+
+func (m *MockStore) DeleteLimitData(key string) error {
+	return m.DeleteLimitDataFunc(key)
+}
+
+func (m *MockStore) GetKeyByID(id string) (string, error) {
+	return m.GetKeyByIDFunc(id)
+}
+
+func (m *MockStore) SaveAccessRule(rule AccessRule) error {
+	return m.SaveAccessRuleFunc(rule)
+}
+
+func (m *MockStore) DeleteAccessRule(rule AccessRule) error {
+	return m.DeleteAccessRuleFunc(rule)
+}
+
+func (m *MockStore) GetAccessRules() ([]AccessRule, error) {
+	return m.GetAccessRulesFunc()
+}
+
+func (m *MockStore) GetAccessVersion() (int64, error) {
+	return m.GetAccessVersionFunc()
+}
+
 // TestSetLimitData tests the SetLimitData function
 func TestSetLimitData(t *testing.T) {
 	store := &MockStore{
@@ -105,6 +262,33 @@ func TestGetLimitDataByIdGenerated(t *testing.T) {
 	assert.Equal(t, LimitData{}, data)
 }
 
+// TestGetLimitDataByID tests that GetLimitDataByID follows the id index and
+// ignores index entries left behind by a new id
+func TestGetLimitDataByID(t *testing.T) {
+	store := &MockStore{
+		GetKeyByIDFunc: func(id string) (string, error) {
+			if id == "missingId" {
+				return "", ErrNotFound
+			}
+			return "testKey", nil
+		},
+		GetInfoLimitDataFunc: func(key string) (LimitData, error) {
+			assert.Equal(t, "testKey", key)
+			return LimitData{Key: "testKey", Id: "testId"}, nil
+		},
+	}
+	rateLimiter := NewRateLimiter(store)
+
+	data, err := rateLimiter.GetLimitDataByID("testId")
+	assert.NoError(t, err)
+	assert.Equal(t, LimitData{Key: "testKey", Id: "testId"}, data)
+
+	_, err = rateLimiter.GetLimitDataByID("oldId")
+	assert.Equal(t, ErrNotFound, err)
+	_, err = rateLimiter.GetLimitDataByID("missingId")
+	assert.Equal(t, ErrNotFound, err)
+}
+
 // TestGetAllLimitData tests the GetAllLimitData function
 func TestGetAllLimitData(t *testing.T) {
 	store := &MockStore{
@@ -119,6 +303,22 @@ func TestGetAllLimitData(t *testing.T) {
 	assert.Equal(t, []LimitData{}, data)
 }
 
+// TestListLimitData tests the ListLimitData function
+func TestListLimitData(t *testing.T) {
+	store := &MockStore{
+		ListLimitDataFunc: func(cursor string, pageSize int64) (LimitDataPage, error) {
+			assert.Equal(t, "0-42", cursor)
+			assert.Equal(t, int64(10), pageSize)
+			return LimitDataPage{Items: []LimitData{}, NextCursor: "0-84"}, nil
+		},
+	}
+	rateLimiter := NewRateLimiter(store)
+
+	page, err := rateLimiter.ListLimitData("0-42", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, "0-84", page.NextCursor)
+}
+
 // TestBlock tests the Block function
 func TestBlock(t *testing.T) {
 	store := &MockStore{
@@ -128,6 +328,12 @@ func TestBlock(t *testing.T) {
 			assert.Equal(t, time.Second, expiration)
 			return nil
 		},
+		SaveBlockInfoFunc: func(info BlockInfo, expiration time.Duration) error {
+			assert.Equal(t, "testKey", info.Key)
+			assert.Equal(t, info.BlockedAt.Add(time.Second), *info.ExpiresAt)
+			assert.Equal(t, time.Second, expiration)
+			return nil
+		},
 	}
 	rateLimiter := NewRateLimiter(store)
 
@@ -149,3 +355,183 @@ func TestIsBlocked(t *testing.T) {
 	assert.NoError(t, err)
 	assert.False(t, blocked)
 }
+
+// TestBlockTTL tests the BlockTTL function
+func TestBlockTTL(t *testing.T) {
+	store := &MockStore{
+		GetBlockTTLFunc: func(key string) (time.Duration, bool, error) {
+			assert.Equal(t, "blocked:testKey", key)
+			return 5 * time.Second, true, nil
+		},
+	}
+	rateLimiter := NewRateLimiter(store)
+
+	ttl, blocked, err := rateLimiter.BlockTTL("testKey")
+	assert.NoError(t, err)
+	assert.True(t, blocked)
+	assert.Equal(t, 5*time.Second, ttl)
+}
+
+// TestEvaluateBlockTTLError tests that the strategies checking the block first return its store error without counting the request
+func TestEvaluateBlockTTLError(t *testing.T) {
+	storeErr := errors.New("connection refused")
+	store := &MockStore{
+		GetBlockTTLFunc: func(key string) (time.Duration, bool, error) {
+			return 0, false, storeErr
+		},
+	}
+	rateLimiter := NewRateLimiter(store)
+
+	for _, data := range []LimitData{
+		{Algorithm: AlgorithmTokenBucket, Capacity: 10, RefillPerSecond: 1},
+		{Algorithm: AlgorithmSlidingWindow, Seconds: 10, MaxRequests: 5},
+		{Algorithm: AlgorithmSlidingWindowLog, Seconds: 10, MaxRequests: 5},
+		{Algorithm: AlgorithmLeakyBucket, LeakPerSecond: 2},
+	} {
+		_, err := rateLimiter.Evaluate("testKey", data)
+		assert.Equal(t, storeErr, err, data.Algorithm)
+	}
+}
+
+// TestEvaluateCost tests that EvaluateCost passes the cost to the store and refuses a cost below one
+func TestEvaluateCost(t *testing.T) {
+	store := &MockStore{
+		CheckAndIncrementFunc: func(key string, limit int64, cost int64, window time.Duration, blockDuration time.Duration) (Decision, error) {
+			assert.Equal(t, int64(3), cost)
+			return Decision{Limit: limit, Remaining: limit - cost}, nil
+		},
+	}
+	rateLimiter := NewRateLimiter(store)
+
+	decision, err := rateLimiter.EvaluateCost("testKey", LimitData{Seconds: 10, MaxRequests: 5}, 3, false)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), decision.Remaining)
+
+	_, err = rateLimiter.EvaluateCost("testKey", LimitData{Seconds: 10, MaxRequests: 5}, 0, false)
+	assert.Error(t, err)
+}
+
+// TestEvaluateDryRun tests that dry-run limits decide the request as usual without ever blocking the key
+func TestEvaluateDryRun(t *testing.T) {
+	store := NewMemoryStore()
+	defer store.Close()
+	rateLimiter := NewRateLimiter(store)
+
+	for _, algorithm := range []string{AlgorithmFixedWindow, AlgorithmSlidingWindowLog, AlgorithmSlidingWindow} {
+		data := LimitData{Algorithm: algorithm, Seconds: 10, MaxRequests: 1, BlockDuration: 30}
+		for i, dryRun := range []bool{false, true} {
+			key := algorithm + strconv.Itoa(i)
+			data.DryRun = !dryRun
+			for j := 0; j < 3; j++ {
+				decision, err := rateLimiter.EvaluateCost(key, data, 1, dryRun)
+				assert.NoError(t, err)
+				assert.Equal(t, j > 0, decision.Limited, "%s request %d", key, j)
+				assert.False(t, decision.Blocked, "%s request %d", key, j)
+			}
+		}
+	}
+
+	page, err := rateLimiter.ListBlocked("", DefaultPageSize)
+	assert.NoError(t, err)
+	assert.Empty(t, page.Items)
+}
+
+// TestRefund tests that Refund gives back exactly what the decision charged through the strategy of the limit data
+func TestRefund(t *testing.T) {
+	var loggedID string
+	var countedAt time.Time
+	refunds := 0
+	store := &MockStore{
+		GetBlockTTLFunc: func(key string) (time.Duration, bool, error) {
+			return 0, false, nil
+		},
+		SlidingWindowLogFunc: func(key string, id string, limit int64, cost int64, window time.Duration, now time.Time) (int64, bool, error) {
+			loggedID = id
+			return cost, true, nil
+		},
+		RefundWindowLogFunc: func(key string, id string, cost int64) error {
+			assert.Equal(t, "testKey", key)
+			assert.Equal(t, loggedID, id)
+			assert.Equal(t, int64(3), cost)
+			refunds++
+			return nil
+		},
+		SlidingWindowCounterFunc: func(key string, limit int64, cost int64, window time.Duration, now time.Time) (int64, bool, error) {
+			countedAt = now
+			return cost, true, nil
+		},
+		RefundWindowCounterFunc: func(key string, cost int64, window time.Duration, chargedAt time.Time) error {
+			assert.Equal(t, countedAt, chargedAt)
+			assert.Equal(t, int64(3), cost)
+			refunds++
+			return nil
+		},
+	}
+	rateLimiter := NewRateLimiter(store)
+	now := time.Now()
+	rateLimiter.now = func() time.Time { return now }
+
+	for _, algorithm := range []string{AlgorithmSlidingWindowLog, AlgorithmSlidingWindow} {
+		data := LimitData{Algorithm: algorithm, Seconds: 10, MaxRequests: 5}
+		decision, err := rateLimiter.EvaluateCost("testKey", data, 3, false)
+		assert.NoError(t, err)
+		// The refund is for the window the request was counted in.
+		now = now.Add(10 * time.Second)
+		assert.NoError(t, rateLimiter.Refund("testKey", data, decision))
+	}
+	assert.Equal(t, 2, refunds)
+
+	// A decision that charged nothing has nothing to give back.
+	assert.NoError(t, rateLimiter.Refund("testKey", LimitData{Seconds: 10, MaxRequests: 5}, Decision{Limited: true}))
+	err := rateLimiter.Refund("testKey", LimitData{Algorithm: "unknown"}, Decision{charged: charge{cost: 1}})
+	assert.Error(t, err)
+}
+
+// TestEvaluateUnknownAlgorithm tests that Evaluate rejects an unknown algorithm
+func TestEvaluateUnknownAlgorithm(t *testing.T) {
+	rateLimiter := NewRateLimiter(&MockStore{})
+
+	_, err := rateLimiter.Evaluate("testKey", LimitData{Algorithm: "unknown"})
+	assert.Error(t, err)
+}
+
+// TestEvaluateDefaultsToFixedWindow tests that an empty algorithm uses the fixed window
+func TestEvaluateDefaultsToFixedWindow(t *testing.T) {
+	store := &MockStore{
+		CheckAndIncrementFunc: func(key string, limit int64, cost int64, window time.Duration, blockDuration time.Duration) (Decision, error) {
+			assert.Equal(t, "testKey", key)
+			assert.Equal(t, int64(5), limit)
+			assert.Equal(t, 10*time.Second, window)
+			assert.Equal(t, 30*time.Second, blockDuration)
+			return Decision{Limit: limit, Remaining: 4}, nil
+		},
+	}
+	rateLimiter := NewRateLimiter(store)
+
+	decision, err := rateLimiter.Evaluate("testKey", LimitData{Seconds: 10, MaxRequests: 5, BlockDuration: 30})
+	assert.NoError(t, err)
+	assert.False(t, decision.Limited)
+	assert.Equal(t, int64(4), decision.Remaining)
+}
+
+// TestLimit tests that Limit reports the decision of the fixed window
+func TestLimit(t *testing.T) {
+	store := &MockStore{
+		CheckAndIncrementFunc: func(key string, limit int64, cost int64, window time.Duration, blockDuration time.Duration) (Decision, error) {
+			return Decision{Limited: true, Limit: limit}, nil
+		},
+	}
+	rateLimiter := NewRateLimiter(store)
+
+	limited, err := rateLimiter.Limit("testKey", 5, 10, 30)
+	assert.NoError(t, err)
+	assert.True(t, limited)
+}
+
+// TestIsConfigured tests the IsConfigured function
+func TestIsConfigured(t *testing.T) {
+	assert.False(t, LimitData{}.IsConfigured())
+	assert.True(t, LimitData{Seconds: 10}.IsConfigured())
+	assert.False(t, LimitData{Algorithm: AlgorithmTokenBucket, Seconds: 10}.IsConfigured())
+	assert.True(t, LimitData{Algorithm: AlgorithmTokenBucket, Capacity: 5, RefillPerSecond: 0.5}.IsConfigured())
+}
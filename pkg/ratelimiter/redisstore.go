@@ -2,15 +2,39 @@ package ratelimiter
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-redis/redis"
 )
 
+const (
+	RedisModeSingle   = "single"
+	RedisModeSentinel = "sentinel"
+	RedisModeCluster  = "cluster"
+)
+
 type RedisStore struct {
-	client *redis.Client
+	client redis.UniversalClient
+}
+
+// RedisOptions selects how the RedisStore reaches Redis. Addrs holds the
+// Redis address in single mode, the Sentinel addresses in sentinel mode and
+// the seed nodes in cluster mode.
+type RedisOptions struct {
+	Mode       string
+	Addrs      []string
+	MasterName string
+	Password   string
+
+	// clusterSlots replaces CLUSTER SLOTS discovery, for tests.
+	clusterSlots func() ([]redis.ClusterSlot, error)
 }
 
 func (r *RedisStore) UpdateLimitData(key string, data LimitDataInput) error {
@@ -20,13 +44,7 @@ func (r *RedisStore) UpdateLimitData(key string, data LimitDataInput) error {
 		return err
 	}
 
-	if data.Seconds != 0 {
-		oldLimitData.Seconds = data.Seconds
-	}
-
-	if data.BlockDuration != 0 {
-		oldLimitData.BlockDuration = data.BlockDuration
-	}
+	oldLimitData = oldLimitData.Merge(data)
 
 	jsonData, err := json.Marshal(oldLimitData)
 	if err != nil {
@@ -34,7 +52,7 @@ func (r *RedisStore) UpdateLimitData(key string, data LimitDataInput) error {
 		return err
 	}
 
-	err = r.client.Set("info::"+key, jsonData, 0).Err()
+	err = r.client.Set(redisKey("info::", key), jsonData, 0).Err()
 	if err != nil {
 		log.Printf("Failed to set id %s: %v", key, err)
 		return err
@@ -43,24 +61,160 @@ func (r *RedisStore) UpdateLimitData(key string, data LimitDataInput) error {
 }
 
 func (r *RedisStore) GetAllLimitData() ([]LimitData, error) {
-	keys, err := r.client.Keys("info::*").Result()
+	var allData []LimitData
+	cursor := ""
+	for {
+		page, err := r.ListLimitData(cursor, DefaultPageSize)
+		if err != nil {
+			return nil, err
+		}
+		allData = append(allData, page.Items...)
+		if page.NextCursor == "" {
+			return allData, nil
+		}
+		cursor = page.NextCursor
+	}
+}
+
+// ListLimitData walks the info:: keys with SCAN and reads each batch with a
+// single MGET. The cursor is "<node>-<scan cursor>", where node indexes the
+// masters of a Redis Cluster and is always 0 otherwise. Keys that vanish or
+// cannot be decoded while listing are skipped.
+func (r *RedisStore) ListLimitData(cursor string, pageSize int64) (LimitDataPage, error) {
+	node, scanCursor := 0, uint64(0)
+	if cursor != "" {
+		_, err := fmt.Sscanf(cursor, "%d-%d", &node, &scanCursor)
+		if err != nil || node < 0 {
+			return LimitDataPage{}, ErrInvalidCursor
+		}
+	}
+
+	clients, err := r.scanClients()
+	if err != nil {
+		log.Printf("Failed to list Redis nodes: %v", err)
+		return LimitDataPage{}, err
+	}
+	if node >= len(clients) {
+		return LimitDataPage{}, ErrInvalidCursor
+	}
+
+	page := LimitDataPage{Items: []LimitData{}}
+	for node < len(clients) && int64(len(page.Items)) < pageSize {
+		keys, next, err := clients[node].Scan(scanCursor, redisKey("info::", "*"), pageSize).Result()
+		if err != nil {
+			log.Printf("Failed to scan limit data: %v", err)
+			return LimitDataPage{}, err
+		}
+
+		items, err := r.getLimitData(clients[node], keys)
+		if err != nil {
+			return LimitDataPage{}, err
+		}
+		page.Items = append(page.Items, items...)
+
+		scanCursor = next
+		if next == 0 {
+			node++
+		}
+	}
+
+	if node < len(clients) {
+		page.NextCursor = fmt.Sprintf("%d-%d", node, scanCursor)
+	}
+	return page, nil
+}
+
+// scanClients returns the clients a listing has to walk: the store client, or
+// every master in a stable order when talking to a Redis Cluster, since each
+// of them only knows about the keys of its own slots.
+func (r *RedisStore) scanClients() ([]redis.Cmdable, error) {
+	cluster, ok := r.client.(*redis.ClusterClient)
+	if !ok {
+		return []redis.Cmdable{r.client}, nil
+	}
+
+	var mu sync.Mutex
+	masters := make(map[string]*redis.Client)
+	err := cluster.ForEachMaster(func(master *redis.Client) error {
+		mu.Lock()
+		masters[master.Options().Addr] = master
+		mu.Unlock()
+		return nil
+	})
 	if err != nil {
-		log.Printf("Failed to get all keys: %v", err)
+		return nil, err
+	}
+
+	addrs := make([]string, 0, len(masters))
+	for addr := range masters {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+
+	clients := make([]redis.Cmdable, len(addrs))
+	for i, addr := range addrs {
+		clients[i] = masters[addr]
+	}
+	return clients, nil
+}
+
+func (r *RedisStore) getLimitData(client redis.Cmdable, keys []string) ([]LimitData, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	values, err := r.getValues(client, keys)
+	if err != nil {
+		log.Printf("Failed to get limit data: %v", err)
 		return nil, err
 	}
 
 	var allData []LimitData
-	for _, key := range keys {
-		key = strings.TrimPrefix(key, "info::")
-		data, err := r.GetInfoLimitData(key)
+	for i, value := range values {
+		// The key expired or was removed since it was scanned.
+		if value == nil {
+			continue
+		}
+
+		var data LimitData
+		err := json.Unmarshal([]byte(value.(string)), &data)
 		if err != nil {
-			log.Printf("Failed to get data for key %s: %v", key, err)
-			return nil, err
+			log.Printf("Failed to unmarshal data for key %s: %v", untagKey("info::", keys[i]), err)
+			continue
 		}
 		allData = append(allData, data)
 	}
 	return allData, nil
 }
+
+// getValues reads keys with one MGET, or with a pipeline of GETs on a Redis
+// Cluster, where an MGET cannot span keys of different slots.
+func (r *RedisStore) getValues(client redis.Cmdable, keys []string) ([]interface{}, error) {
+	if _, ok := r.client.(*redis.ClusterClient); !ok {
+		return client.MGet(keys...).Result()
+	}
+
+	pipe := client.Pipeline()
+	cmds := make([]*redis.StringCmd, len(keys))
+	for i, key := range keys {
+		cmds[i] = pipe.Get(key)
+	}
+	_, _ = pipe.Exec()
+
+	values := make([]interface{}, len(keys))
+	for i, cmd := range cmds {
+		value, err := cmd.Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
 func (r *RedisStore) SaveInfoLimitData(key string, data LimitData) error {
 	jsonData, err := json.Marshal(data)
 	if err != nil {
@@ -68,14 +222,96 @@ func (r *RedisStore) SaveInfoLimitData(key string, data LimitData) error {
 		return err
 	}
 
-	err = r.client.Set("info::"+key, jsonData, 0).Err()
+	err = r.client.Set(redisKey("info::", key), jsonData, 0).Err()
 	if err != nil {
 		log.Printf("Failed to set key %s: %v", key, err)
 		return err
 	}
+
+	// The index lives in the slot of the id, so it is written apart from the
+	// limit data. Readers check that the data found through it has the id.
+	if data.Id != "" {
+		err = r.client.Set(redisKey("id::", data.Id), key, 0).Err()
+		if err != nil {
+			log.Printf("Failed to index id %s of key %s: %v", data.Id, key, err)
+			return err
+		}
+	}
 	return nil
 }
 
+// CreateLimitData claims the limit data of key and then its id with SETNX, as
+// the index lives in the slot of the id. When the id turns out to be taken the
+// limit data is deleted again.
+func (r *RedisStore) CreateLimitData(key string, data LimitData) error {
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("Failed to marshal data for key %s: %v", key, err)
+		return err
+	}
+
+	created, err := r.client.SetNX(redisKey("info::", key), jsonData, 0).Result()
+	if err != nil {
+		log.Printf("Failed to create key %s: %v", key, err)
+		return err
+	}
+	if !created {
+		return ErrExists
+	}
+	if data.Id == "" {
+		return nil
+	}
+
+	indexed, err := r.client.SetNX(redisKey("id::", data.Id), key, 0).Result()
+	if err == nil && indexed {
+		return nil
+	}
+	if err != nil {
+		log.Printf("Failed to index id %s of key %s: %v", data.Id, key, err)
+	}
+	if delErr := r.client.Del(redisKey("info::", key)).Err(); delErr != nil {
+		log.Printf("Failed to delete key %s: %v", key, delErr)
+	}
+	if err != nil {
+		return err
+	}
+	return ErrExists
+}
+
+// DeleteLimitData deletes the limit data of key and its id index entry.
+func (r *RedisStore) DeleteLimitData(key string) error {
+	data, err := r.GetInfoLimitData(key)
+	if err != nil {
+		return err
+	}
+
+	err = r.client.Del(redisKey("info::", key)).Err()
+	if err != nil {
+		log.Printf("Failed to delete key %s: %v", key, err)
+		return err
+	}
+	if data.Id != "" {
+		err = r.client.Del(redisKey("id::", data.Id)).Err()
+		if err != nil {
+			log.Printf("Failed to delete the index of id %s: %v", data.Id, err)
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *RedisStore) GetKeyByID(id string) (string, error) {
+	key, err := r.client.Get(redisKey("id::", id)).Result()
+	if err == redis.Nil {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		log.Printf("Failed to get the key of id %s: %v", id, err)
+		return "", err
+	}
+	return key, nil
+}
+
 func NewRedisStore(addr string) *RedisStore {
 	client := redis.NewClient(&redis.Options{
 		Addr: addr,
@@ -86,23 +322,197 @@ func NewRedisStore(addr string) *RedisStore {
 	}
 }
 
+// NewRedisStoreWithOptions connects to a single Redis, to the master of a
+// Sentinel deployment or to a Redis Cluster, depending on the mode.
+func NewRedisStoreWithOptions(opts RedisOptions) (*RedisStore, error) {
+	if len(opts.Addrs) == 0 {
+		return nil, errors.New("at least one Redis address is required")
+	}
+
+	var client redis.UniversalClient
+	switch opts.Mode {
+	case "", RedisModeSingle:
+		client = redis.NewClient(&redis.Options{
+			Addr:     opts.Addrs[0],
+			Password: opts.Password,
+		})
+	case RedisModeSentinel:
+		if opts.MasterName == "" {
+			return nil, errors.New("the Sentinel master name is required")
+		}
+		client = redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:    opts.MasterName,
+			SentinelAddrs: opts.Addrs,
+			Password:      opts.Password,
+		})
+	case RedisModeCluster:
+		client = redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:        opts.Addrs,
+			Password:     opts.Password,
+			ClusterSlots: opts.clusterSlots,
+		})
+	default:
+		return nil, fmt.Errorf("unknown Redis mode %q", opts.Mode)
+	}
+
+	return &RedisStore{
+		client: client,
+	}, nil
+}
+
+// redisKey namespaces key under prefix. The key is wrapped in a hash tag so
+// that every entry of a key lands on the same Redis Cluster slot, which the
+// scripts and pipelines touching several of them at once depend on.
+func redisKey(prefix string, key string) string {
+	return prefix + "{" + key + "}"
+}
+
+// untagKey returns the key a redisKey was built from.
+func untagKey(prefix string, key string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(key, prefix+"{"), "}")
+}
+
+// tagKey hash tags the blocked: keys the RateLimiter passes in full.
+func tagKey(key string) string {
+	if strings.HasPrefix(key, "blocked:") {
+		return redisKey("blocked:", strings.TrimPrefix(key, "blocked:"))
+	}
+	return key
+}
+
+// untaggedKeyPrefixes are the prefixes of the long-lived keys written before
+// the keys were hash tagged: the limit data and the blocks. The counters of
+// that time are left to expire, so each key starts over with a full quota.
+var untaggedKeyPrefixes = []string{"info::", "blocked:"}
+
+// MigrateUntaggedKeys renames the limit data and blocks written before the
+// keys were hash tagged, such as info::key, to their tagged name, such as
+// info::{key}, keeping their TTL, and returns how many were renamed. A key
+// whose tagged name is already taken is deleted, the tagged one being newer.
+// Untagged keys can only be on a single Redis, the one mode supported before
+// the tags, so RENAMENX can move them in place.
+func (r *RedisStore) MigrateUntaggedKeys() (int64, error) {
+	var migrated int64
+	for _, prefix := range untaggedKeyPrefixes {
+		err := r.scanKeys(prefix+"*", func(client redis.Cmdable, keys []string) error {
+			for _, k := range keys {
+				key := strings.TrimPrefix(k, prefix)
+				if strings.HasPrefix(key, "{") {
+					continue
+				}
+				renamed, err := client.RenameNX(k, redisKey(prefix, key)).Result()
+				if err != nil {
+					return err
+				}
+				if renamed {
+					migrated++
+				} else if err := client.Del(k).Err(); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			log.Printf("Failed to migrate the keys under %s: %v", prefix, err)
+			return migrated, err
+		}
+	}
+	return migrated, nil
+}
+
 func (r *RedisStore) Ping() error {
 	return r.client.Ping().Err()
 }
 
 func (r *RedisStore) Increment(key string, seconds int64) (int64, error) {
-	val, err := r.client.IncrBy("limit::"+key, 1).Result()
+	pipe := r.client.TxPipeline()
+	incr := pipe.IncrBy(redisKey("limit::", key), 1)
+	pipe.Expire(redisKey("limit::", key), time.Duration(seconds)*time.Second)
+	_, err := pipe.Exec()
 	if err != nil {
 		log.Printf("Failed to increment key %s: %v", key, err)
 		return 0, err
 	}
 
-	go r.client.Expire("limit::"+key, time.Duration(seconds)*time.Second).Result()
-	return val, nil
+	return incr.Val(), nil
+}
+
+// checkAndIncrementScript does in one round trip what the fixed window used to
+// need four for: it refuses blocked keys, counts the cost of the request,
+// starts the window on the first request and blocks the key once a request
+// would go over the limit. A refused request is not counted at all, and one
+// costing more than the whole limit is refused without blocking the key.
+// Without a block duration the key stays limited until its window ends. Durations are in
+// milliseconds and the result is {limited, count, retry_after, reset_after,
+// blocked}.
+//
+// Like every script in this file it is sent with EVALSHA, and only sent in full
+// with EVAL when Redis answers NOSCRIPT.
+var checkAndIncrementScript = redis.NewScript(`
+local limit = tonumber(ARGV[1])
+local cost = tonumber(ARGV[2])
+local window = tonumber(ARGV[3])
+local block = tonumber(ARGV[4])
+
+local blocked = redis.call("PTTL", KEYS[2])
+if blocked == -1 or blocked > 0 then
+	return {1, limit, blocked, blocked, 0}
+end
+
+local count = tonumber(redis.call("GET", KEYS[1])) or 0
+local ttl = redis.call("PTTL", KEYS[1])
+if ttl < 0 then
+	ttl = window
+end
+
+if count + cost > limit then
+	if block > 0 and cost <= limit then
+		redis.call("SET", KEYS[2], 1, "PX", block)
+		return {1, count, block, math.max(block, ttl), 1}
+	end
+	return {1, count, ttl, ttl, 0}
+end
+
+count = redis.call("INCRBY", KEYS[1], cost)
+if redis.call("PTTL", KEYS[1]) < 0 then
+	redis.call("PEXPIRE", KEYS[1], window)
+end
+return {0, count, 0, ttl, 0}
+`)
+
+func (r *RedisStore) CheckAndIncrement(key string, limit int64, cost int64, window time.Duration, blockDuration time.Duration) (Decision, error) {
+	keys := []string{redisKey("limit::", key), redisKey("blocked:", key)}
+	windowMs := int64(window / time.Millisecond)
+	blockMs := int64(blockDuration / time.Millisecond)
+
+	res, err := checkAndIncrementScript.Run(r.client, keys, limit, cost, windowMs, blockMs).Result()
+	if err != nil {
+		log.Printf("Failed to check and increment key %s: %v", key, err)
+		return Decision{}, err
+	}
+
+	values := res.([]interface{})
+	decision := Decision{
+		Limited:    values[0].(int64) == 1,
+		Limit:      limit,
+		Remaining:  limit - values[1].(int64),
+		RetryAfter: time.Duration(values[2].(int64)) * time.Millisecond,
+		ResetAfter: time.Duration(values[3].(int64)) * time.Millisecond,
+		Blocked:    values[4].(int64) == 1,
+	}
+	if decision.Remaining < 0 {
+		decision.Remaining = 0
+	}
+	// A block without expiry reports -1, there is no meaningful delay for it.
+	if decision.RetryAfter < 0 {
+		decision.RetryAfter = 0
+		decision.ResetAfter = 0
+	}
+	return decision, nil
 }
 
 func (r *RedisStore) SetBlockDuration(key string, value int64, expiration time.Duration) error {
-	err := r.client.Set(key, value, expiration).Err()
+	err := r.client.Set(tagKey(key), value, expiration).Err()
 	if err != nil {
 		log.Printf("Failed to set block duration for key %s: %v", key, err)
 		return err
@@ -112,7 +522,7 @@ func (r *RedisStore) SetBlockDuration(key string, value int64, expiration time.D
 }
 
 func (r *RedisStore) GetBlockDuration(key string) (int64, error) {
-	val, err := r.client.Get(key).Int64()
+	val, err := r.client.Get(tagKey(key)).Int64()
 	if err != nil {
 		if err == redis.Nil {
 			return 0, nil
@@ -124,6 +534,365 @@ func (r *RedisStore) GetBlockDuration(key string) (int64, error) {
 	return val, nil
 }
 
+func (r *RedisStore) GetBlockTTL(key string) (time.Duration, bool, error) {
+	ttl, err := r.client.PTTL(tagKey(key)).Result()
+	if err != nil {
+		log.Printf("Failed to get block TTL for key %s: %v", key, err)
+		return 0, false, err
+	}
+
+	// PTTL answers -2 for a missing key and -1 for a key without expiry.
+	switch {
+	case ttl == -2*time.Millisecond:
+		return 0, false, nil
+	case ttl < 0:
+		return 0, true, nil
+	}
+	return ttl, true, nil
+}
+
+// Unblock lifts the block of key, bans included. The RateLimiter checks for a
+// ban before calling it.
+func (r *RedisStore) Unblock(key string) (bool, error) {
+	pipe := r.client.TxPipeline()
+	del := pipe.Del(redisKey("blocked:", key))
+	pipe.Del(redisKey("blockinfo::", key))
+	_, err := pipe.Exec()
+	if err != nil {
+		log.Printf("Failed to unblock key %s: %v", key, err)
+		return false, err
+	}
+	return del.Val() > 0, nil
+}
+
+// UnblockMatching lifts the blocks of the keys matching pattern, leaving the
+// bans in place.
+func (r *RedisStore) UnblockMatching(pattern string) (int64, error) {
+	var unblocked int64
+	err := r.scanKeys(redisKey("blocked:", pattern), func(client redis.Cmdable, keys []string) error {
+		plain := make([]string, len(keys))
+		for i, k := range keys {
+			plain[i] = untagKey("blocked:", k)
+		}
+		banned, err := r.bannedKeys(client, plain)
+		if err != nil {
+			return err
+		}
+
+		var blocks, infoKeys []string
+		for i, key := range plain {
+			if !banned[key] {
+				blocks = append(blocks, keys[i])
+				infoKeys = append(infoKeys, redisKey("blockinfo::", key))
+			}
+		}
+		deleted, err := deleteKeys(client, blocks)
+		unblocked += deleted
+		if err != nil {
+			return err
+		}
+		_, err = deleteKeys(client, infoKeys)
+		return err
+	})
+	if err != nil {
+		log.Printf("Failed to unblock keys matching %s: %v", pattern, err)
+		return unblocked, err
+	}
+	return unblocked, nil
+}
+
+// ResetKey deletes the counters and the block of key, unless it is a ban. The
+// names of the sliding window counters hold the index of their window, so
+// they are found with SCAN.
+func (r *RedisStore) ResetKey(key string) error {
+	banned, err := r.bannedKeys(r.client, []string{key})
+	if err != nil {
+		log.Printf("Failed to reset key %s: %v", key, err)
+		return err
+	}
+
+	var keys []string
+	for _, prefix := range stateKeyPrefixes {
+		if prefix != "counter::" && !(banned[key] && isBlockPrefix(prefix)) {
+			keys = append(keys, redisKey(prefix, key))
+		}
+	}
+	// The keys share the hash tag of key, so a single DEL works on a cluster.
+	err = r.client.Del(keys...).Err()
+	if err == nil {
+		err = r.scanKeys(escapeGlob(redisKey("counter::", key))+":*", func(client redis.Cmdable, keys []string) error {
+			_, err := deleteKeys(client, keys)
+			return err
+		})
+	}
+	if err != nil {
+		log.Printf("Failed to reset key %s: %v", key, err)
+		return err
+	}
+	return nil
+}
+
+// ResetMatching scans for the entries whose hash tag may match pattern and
+// deletes the counters and blocks among them whose key does, except bans.
+func (r *RedisStore) ResetMatching(pattern string) (int64, error) {
+	reset := make(map[string]bool)
+	err := r.scanKeys("*{"+pattern+"}*", func(client redis.Cmdable, keys []string) error {
+		var state []string
+		var blocks []string
+		prefixes := make(map[string]string)
+		stateKeys := make(map[string]string)
+		for _, k := range keys {
+			prefix, key, ok := untagStateKey(k)
+			if !ok || !matchGlob(pattern, key) {
+				continue
+			}
+			prefixes[k], stateKeys[k] = prefix, key
+			if isBlockPrefix(prefix) {
+				blocks = append(blocks, key)
+			}
+		}
+		banned, err := r.bannedKeys(client, blocks)
+		if err != nil {
+			return err
+		}
+
+		for k, key := range stateKeys {
+			if banned[key] && isBlockPrefix(prefixes[k]) {
+				continue
+			}
+			state = append(state, k)
+			reset[key] = true
+		}
+		_, err = deleteKeys(client, state)
+		return err
+	})
+	if err != nil {
+		log.Printf("Failed to reset keys matching %s: %v", pattern, err)
+		return int64(len(reset)), err
+	}
+	return int64(len(reset)), nil
+}
+
+// scanKeys walks the keys matching the glob match on every client returned by
+// scanClients, calling fn with each batch.
+func (r *RedisStore) scanKeys(match string, fn func(client redis.Cmdable, keys []string) error) error {
+	clients, err := r.scanClients()
+	if err != nil {
+		return err
+	}
+	for _, client := range clients {
+		var cursor uint64
+		for {
+			keys, next, err := client.Scan(cursor, match, DefaultPageSize).Result()
+			if err != nil {
+				return err
+			}
+			if len(keys) > 0 {
+				if err := fn(client, keys); err != nil {
+					return err
+				}
+			}
+			if cursor = next; cursor == 0 {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// deleteKeys deletes keys with a pipeline of DELs, as keys found by a scan may
+// be in different cluster slots, and returns how many of them existed.
+func deleteKeys(client redis.Cmdable, keys []string) (int64, error) {
+	if len(keys) == 0 {
+		return 0, nil
+	}
+
+	pipe := client.Pipeline()
+	cmds := make([]*redis.IntCmd, len(keys))
+	for i, key := range keys {
+		cmds[i] = pipe.Del(key)
+	}
+	_, err := pipe.Exec()
+	if err != nil {
+		return 0, err
+	}
+
+	var deleted int64
+	for _, cmd := range cmds {
+		deleted += cmd.Val()
+	}
+	return deleted, nil
+}
+
+// untagStateKey splits a counter or block entry into its prefix and the key
+// it belongs to, or returns false when redisKey is not one.
+func untagStateKey(redisKey string) (string, string, bool) {
+	for _, prefix := range stateKeyPrefixes {
+		rest, found := strings.CutPrefix(redisKey, prefix+"{")
+		if !found {
+			continue
+		}
+		if prefix == "counter::" {
+			end := strings.LastIndex(rest, "}:")
+			if end < 0 {
+				return "", "", false
+			}
+			return prefix, rest[:end], true
+		}
+		key, found := strings.CutSuffix(rest, "}")
+		return prefix, key, found
+	}
+	return "", "", false
+}
+
+// bannedKeys reads the block info of keys with a pipeline and returns the ones
+// that are banned.
+func (r *RedisStore) bannedKeys(client redis.Cmdable, keys []string) (map[string]bool, error) {
+	banned := make(map[string]bool)
+	if len(keys) == 0 {
+		return banned, nil
+	}
+
+	pipe := client.Pipeline()
+	cmds := make([]*redis.StringCmd, len(keys))
+	for i, key := range keys {
+		cmds[i] = pipe.Get(redisKey("blockinfo::", key))
+	}
+	_, _ = pipe.Exec()
+
+	for i, cmd := range cmds {
+		value, err := cmd.Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		var info BlockInfo
+		if json.Unmarshal([]byte(value), &info) == nil && info.Type == BlockTypeBan {
+			banned[keys[i]] = true
+		}
+	}
+	return banned, nil
+}
+
+func (r *RedisStore) SaveBlockInfo(info BlockInfo, expiration time.Duration) error {
+	jsonData, err := json.Marshal(info)
+	if err != nil {
+		log.Printf("Failed to marshal block info for key %s: %v", info.Key, err)
+		return err
+	}
+
+	err = r.client.Set(redisKey("blockinfo::", info.Key), jsonData, expiration).Err()
+	if err != nil {
+		log.Printf("Failed to set block info for key %s: %v", info.Key, err)
+		return err
+	}
+	return nil
+}
+
+func (r *RedisStore) GetBlockInfo(key string) (BlockInfo, error) {
+	items, err := r.getBlockInfo(r.client, []string{redisKey("blocked:", key)})
+	if err != nil {
+		return BlockInfo{}, err
+	}
+	if len(items) == 0 {
+		return BlockInfo{}, ErrNotFound
+	}
+	return items[0], nil
+}
+
+// ListBlocked walks the blocked: keys with SCAN, with the same cursor as
+// ListLimitData, and reads the TTL and block info of each batch with a
+// pipeline. The expiry comes from the TTL, so it follows changes to the block.
+func (r *RedisStore) ListBlocked(cursor string, pageSize int64) (BlockedPage, error) {
+	node, scanCursor := 0, uint64(0)
+	if cursor != "" {
+		_, err := fmt.Sscanf(cursor, "%d-%d", &node, &scanCursor)
+		if err != nil || node < 0 {
+			return BlockedPage{}, ErrInvalidCursor
+		}
+	}
+
+	clients, err := r.scanClients()
+	if err != nil {
+		log.Printf("Failed to list Redis nodes: %v", err)
+		return BlockedPage{}, err
+	}
+	if node >= len(clients) {
+		return BlockedPage{}, ErrInvalidCursor
+	}
+
+	page := BlockedPage{Items: []BlockInfo{}}
+	for node < len(clients) && int64(len(page.Items)) < pageSize {
+		keys, next, err := clients[node].Scan(scanCursor, redisKey("blocked:", "*"), pageSize).Result()
+		if err != nil {
+			log.Printf("Failed to scan blocked keys: %v", err)
+			return BlockedPage{}, err
+		}
+
+		items, err := r.getBlockInfo(clients[node], keys)
+		if err != nil {
+			return BlockedPage{}, err
+		}
+		page.Items = append(page.Items, items...)
+
+		scanCursor = next
+		if next == 0 {
+			node++
+		}
+	}
+
+	if node < len(clients) {
+		page.NextCursor = fmt.Sprintf("%d-%d", node, scanCursor)
+	}
+	return page, nil
+}
+
+func (r *RedisStore) getBlockInfo(client redis.Cmdable, keys []string) ([]BlockInfo, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	pipe := client.Pipeline()
+	ttls := make([]*redis.DurationCmd, len(keys))
+	infos := make([]*redis.StringCmd, len(keys))
+	for i, k := range keys {
+		ttls[i] = pipe.PTTL(k)
+		infos[i] = pipe.Get(redisKey("blockinfo::", untagKey("blocked:", k)))
+	}
+	_, err := pipe.Exec()
+	if err != nil && err != redis.Nil {
+		log.Printf("Failed to get block info: %v", err)
+		return nil, err
+	}
+
+	now := time.Now()
+	var items []BlockInfo
+	for i, k := range keys {
+		ttl, err := ttls[i].Result()
+		if err != nil {
+			log.Printf("Failed to get block TTL for key %s: %v", untagKey("blocked:", k), err)
+			return nil, err
+		}
+		// The block expired or was lifted since it was scanned.
+		if ttl == -2*time.Millisecond {
+			continue
+		}
+
+		var info BlockInfo
+		if value, err := infos[i].Result(); err == nil {
+			if err := json.Unmarshal([]byte(value), &info); err != nil {
+				log.Printf("Failed to unmarshal block info for key %s: %v", untagKey("blocked:", k), err)
+			}
+		}
+		info.Key = untagKey("blocked:", k)
+		info.ExpiresAt = blockExpiry(now, ttl)
+		items = append(items, info)
+	}
+	return items, nil
+}
+
 func (r *RedisStore) SaveLimitData(key string, data LimitData) error {
 	jsonData, err := json.Marshal(data)
 	if err != nil {
@@ -140,7 +909,10 @@ func (r *RedisStore) SaveLimitData(key string, data LimitData) error {
 }
 
 func (r *RedisStore) GetInfoLimitData(key string) (LimitData, error) {
-	val, err := r.client.Get("info::" + key).Result()
+	val, err := r.client.Get(redisKey("info::", key)).Result()
+	if err == redis.Nil {
+		return LimitData{}, ErrNotFound
+	}
 	if err != nil {
 		log.Printf("Failed to get key %s: %v", key, err)
 		return LimitData{}, err
@@ -154,3 +926,515 @@ func (r *RedisStore) GetInfoLimitData(key string) (LimitData, error) {
 	}
 	return data, nil
 }
+
+// slidingWindowLogScript trims the log to the window, then records the request
+// only if the log still has room for it, all in one atomic step. Each request
+// is a single member, its id ending in "#<cost>", and the count is the sum of those
+// costs, kept in KEYS[2] so that only the trimmed members are read. Members
+// logged without a cost count as one. The total lives as long as the log, and
+// a log written before it had a total is summed once.
+var slidingWindowLogScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+local cost = tonumber(ARGV[4])
+
+local function member_cost(member)
+	return tonumber(string.match(member, "#(%d+)$")) or 1
+end
+
+local count = tonumber(redis.call("GET", KEYS[2]))
+if count == nil then
+	count = 0
+	for _, member in ipairs(redis.call("ZRANGE", KEYS[1], 0, -1)) do
+		count = count + member_cost(member)
+	end
+end
+local expired = redis.call("ZRANGEBYSCORE", KEYS[1], "-inf", now - window)
+for _, member in ipairs(expired) do
+	count = count - member_cost(member)
+end
+if #expired > 0 then
+	redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", now - window)
+end
+
+local allowed = 0
+if count + cost <= limit then
+	redis.call("ZADD", KEYS[1], now, ARGV[5])
+	redis.call("PEXPIRE", KEYS[1], window)
+	count = count + cost
+	allowed = 1
+end
+
+local ttl = redis.call("PTTL", KEYS[1])
+if ttl > 0 then
+	redis.call("SET", KEYS[2], count, "PX", ttl)
+else
+	count = 0
+	redis.call("DEL", KEYS[2])
+end
+return {count, allowed}
+`)
+
+func (r *RedisStore) SlidingWindowLog(key string, id string, limit int64, cost int64, window time.Duration, now time.Time) (int64, bool, error) {
+	nowMs := now.UnixNano() / int64(time.Millisecond)
+	windowMs := int64(window / time.Millisecond)
+	member := windowLogMember(id, cost)
+
+	keys := []string{redisKey("window::", key), redisKey("windowcost::", key)}
+	res, err := slidingWindowLogScript.Run(r.client, keys, nowMs, windowMs, limit, cost, member).Result()
+	if err != nil {
+		log.Printf("Failed to log request for key %s: %v", key, err)
+		return 0, false, err
+	}
+
+	values := res.([]interface{})
+	return values[0].(int64), values[1].(int64) == 1, nil
+}
+
+// windowLogMember returns the member logging the request id of the given cost.
+func windowLogMember(id string, cost int64) string {
+	return fmt.Sprintf("%s#%d", id, cost)
+}
+
+// refundWindowLogScript removes the request being refunded from the log and
+// takes its cost off the total. A request that already left the window is not
+// taken off again.
+var refundWindowLogScript = redis.NewScript(`
+local cost = tonumber(ARGV[2])
+
+if redis.call("ZREM", KEYS[1], ARGV[1]) == 0 then
+	return 0
+end
+local count = tonumber(redis.call("GET", KEYS[2]))
+local ttl = redis.call("PTTL", KEYS[1])
+if count ~= nil and ttl > 0 then
+	redis.call("SET", KEYS[2], math.max(0, count - cost), "PX", ttl)
+else
+	redis.call("DEL", KEYS[2])
+end
+return 1
+`)
+
+func (r *RedisStore) RefundWindowLog(key string, id string, cost int64) error {
+	keys := []string{redisKey("window::", key), redisKey("windowcost::", key)}
+	err := refundWindowLogScript.Run(r.client, keys, windowLogMember(id, cost), cost).Err()
+	if err != nil {
+		log.Printf("Failed to refund request for key %s: %v", key, err)
+		return err
+	}
+	return nil
+}
+
+// tokenBucketScript refills the bucket for the time elapsed since the last
+// call and takes the cost of the request from it when enough tokens are left.
+var tokenBucketScript = redis.NewScript(`
+local capacity = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local cost = tonumber(ARGV[4])
+
+local bucket = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(bucket[1])
+local ts = tonumber(bucket[2])
+if tokens == nil then
+	tokens = capacity
+	ts = now
+end
+
+tokens = math.min(capacity, tokens + math.max(0, now - ts) * rate / 1000)
+local allowed = 0
+if tokens >= cost then
+	tokens = tokens - cost
+	allowed = 1
+end
+
+redis.call("HMSET", KEYS[1], "tokens", tostring(tokens), "ts", now)
+redis.call("PEXPIRE", KEYS[1], math.ceil(capacity / rate * 1000))
+return {tostring(tokens), allowed}
+`)
+
+func (r *RedisStore) TakeToken(key string, capacity int64, refillPerSecond float64, cost int64, now time.Time) (float64, bool, error) {
+	nowMs := now.UnixNano() / int64(time.Millisecond)
+
+	res, err := tokenBucketScript.Run(r.client, []string{redisKey("bucket::", key)}, capacity, refillPerSecond, nowMs, cost).Result()
+	if err != nil {
+		log.Printf("Failed to take token for key %s: %v", key, err)
+		return 0, false, err
+	}
+
+	values := res.([]interface{})
+	tokens, err := strconv.ParseFloat(values[0].(string), 64)
+	if err != nil {
+		log.Printf("Failed to parse tokens for key %s: %v", key, err)
+		return 0, false, err
+	}
+	return tokens, values[1].(int64) == 1, nil
+}
+
+// refundTokensScript puts the cost of a request back in the bucket, up to its
+// capacity. A bucket that has expired since is already full.
+var refundTokensScript = redis.NewScript(`
+local capacity = tonumber(ARGV[1])
+local cost = tonumber(ARGV[2])
+
+local tokens = tonumber(redis.call("HGET", KEYS[1], "tokens"))
+if tokens == nil then
+	return 0
+end
+redis.call("HSET", KEYS[1], "tokens", tostring(math.min(capacity, tokens + cost)))
+return 1
+`)
+
+func (r *RedisStore) RefundTokens(key string, capacity int64, cost int64) error {
+	err := refundTokensScript.Run(r.client, []string{redisKey("bucket::", key)}, capacity, cost).Err()
+	if err != nil {
+		log.Printf("Failed to refund tokens for key %s: %v", key, err)
+		return err
+	}
+	return nil
+}
+
+// gcraScript advances the theoretical arrival time (TAT) of the key by one
+// emission interval per unit of cost when the request fits in the burst
+// tolerance. Times are in
+// microseconds and the result is {allowed, remaining, retry_after, reset_after}.
+var gcraScript = redis.NewScript(`
+local emission = tonumber(ARGV[1])
+local limit = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local cost = tonumber(ARGV[4])
+
+local tat = tonumber(redis.call("GET", KEYS[1]))
+if tat == nil or tat < now then
+	tat = now
+end
+
+local new_tat = tat + emission * cost
+local diff = now - (new_tat - emission * limit)
+if diff < 0 then
+	return {0, 0, -diff, tat - now}
+end
+
+redis.call("SET", KEYS[1], string.format("%d", new_tat), "PX", math.ceil((new_tat - now) / 1000))
+return {1, math.floor(diff / emission), 0, new_tat - now}
+`)
+
+func (r *RedisStore) GCRA(key string, emissionInterval time.Duration, limit int64, cost int64, now time.Time) (Decision, error) {
+	emissionUs := int64(emissionInterval / time.Microsecond)
+	nowUs := now.UnixNano() / int64(time.Microsecond)
+
+	res, err := gcraScript.Run(r.client, []string{redisKey("gcra::", key)}, emissionUs, limit, nowUs, cost).Result()
+	if err != nil {
+		log.Printf("Failed to evaluate GCRA for key %s: %v", key, err)
+		return Decision{}, err
+	}
+
+	values := res.([]interface{})
+	return Decision{
+		Limited:    values[0].(int64) == 0,
+		Limit:      limit,
+		Remaining:  values[1].(int64),
+		RetryAfter: time.Duration(values[2].(int64)) * time.Microsecond,
+		ResetAfter: time.Duration(values[3].(int64)) * time.Microsecond,
+	}, nil
+}
+
+// reserveSlotScript hands out the next cost free drip slots of the key. The
+// request is refused when its last slot would end up deeper than max_queue in
+// the queue or it would wait longer than max_wait (0 means no wait limit). Times are in microseconds and
+// the result is {allowed, wait}.
+var reserveSlotScript = redis.NewScript(`
+local interval = tonumber(ARGV[1])
+local max_queue = tonumber(ARGV[2])
+local max_wait = tonumber(ARGV[3])
+local now = tonumber(ARGV[4])
+local cost = tonumber(ARGV[5])
+
+local slot = tonumber(redis.call("GET", KEYS[1]))
+if slot == nil or slot < now then
+	slot = now
+end
+
+local wait = slot - now
+if math.ceil(wait / interval) + cost - 1 > max_queue or (max_wait > 0 and wait > max_wait) then
+	return {0, wait}
+end
+
+local next_slot = slot + interval * cost
+redis.call("SET", KEYS[1], string.format("%d", next_slot), "PX", math.ceil((next_slot - now) / 1000))
+return {1, wait}
+`)
+
+func (r *RedisStore) ReserveSlot(key string, interval time.Duration, maxQueue int64, maxWait time.Duration, cost int64, now time.Time) (time.Duration, bool, error) {
+	intervalUs := int64(interval / time.Microsecond)
+	maxWaitUs := int64(maxWait / time.Microsecond)
+	nowUs := now.UnixNano() / int64(time.Microsecond)
+
+	res, err := reserveSlotScript.Run(r.client, []string{redisKey("queue::", key)}, intervalUs, maxQueue, maxWaitUs, nowUs, cost).Result()
+	if err != nil {
+		log.Printf("Failed to reserve slot for key %s: %v", key, err)
+		return 0, false, err
+	}
+
+	values := res.([]interface{})
+	return time.Duration(values[1].(int64)) * time.Microsecond, values[0].(int64) == 1, nil
+}
+
+// refundTimeScript moves the time the GCRA and the leaky bucket keep for a key
+// back by cost steps, deleting it once it is no longer ahead of now. Times are
+// in microseconds.
+var refundTimeScript = redis.NewScript(`
+local step = tonumber(ARGV[1])
+local cost = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+
+local time = tonumber(redis.call("GET", KEYS[1]))
+if time == nil then
+	return 0
+end
+
+time = time - step * cost
+if time <= now then
+	redis.call("DEL", KEYS[1])
+	return 1
+end
+redis.call("SET", KEYS[1], string.format("%d", time), "PX", math.ceil((time - now) / 1000))
+return 1
+`)
+
+func (r *RedisStore) RefundGCRA(key string, emissionInterval time.Duration, cost int64, now time.Time) error {
+	return r.refundTime(redisKey("gcra::", key), key, emissionInterval, cost, now)
+}
+
+func (r *RedisStore) RefundSlot(key string, interval time.Duration, cost int64, now time.Time) error {
+	return r.refundTime(redisKey("queue::", key), key, interval, cost, now)
+}
+
+func (r *RedisStore) refundTime(storeKey string, key string, step time.Duration, cost int64, now time.Time) error {
+	stepUs := int64(step / time.Microsecond)
+	nowUs := now.UnixNano() / int64(time.Microsecond)
+
+	err := refundTimeScript.Run(r.client, []string{storeKey}, stepUs, cost, nowUs).Err()
+	if err != nil {
+		log.Printf("Failed to refund request for key %s: %v", key, err)
+		return err
+	}
+	return nil
+}
+
+// accessRulesKey holds every access rule in a hash, and accessVersionKey is
+// bumped on each change so instances know when to reload their copy.
+var (
+	accessRulesKey   = redisKey("access::", "rules")
+	accessVersionKey = accessRulesKey + ":version"
+)
+
+func (r *RedisStore) SaveAccessRule(rule AccessRule) error {
+	jsonData, err := json.Marshal(rule)
+	if err != nil {
+		log.Printf("Failed to marshal access rule %s: %v", rule.id(), err)
+		return err
+	}
+
+	pipe := r.client.TxPipeline()
+	pipe.HSet(accessRulesKey, rule.id(), jsonData)
+	pipe.Incr(accessVersionKey)
+	_, err = pipe.Exec()
+	if err != nil {
+		log.Printf("Failed to save access rule %s: %v", rule.id(), err)
+		return err
+	}
+	return nil
+}
+
+func (r *RedisStore) DeleteAccessRule(rule AccessRule) error {
+	pipe := r.client.TxPipeline()
+	del := pipe.HDel(accessRulesKey, rule.id())
+	pipe.Incr(accessVersionKey)
+	_, err := pipe.Exec()
+	if err != nil {
+		log.Printf("Failed to delete access rule %s: %v", rule.id(), err)
+		return err
+	}
+	if del.Val() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *RedisStore) GetAccessRules() ([]AccessRule, error) {
+	values, err := r.client.HGetAll(accessRulesKey).Result()
+	if err != nil {
+		log.Printf("Failed to get access rules: %v", err)
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(values))
+	for id := range values {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	rules := make([]AccessRule, 0, len(ids))
+	for _, id := range ids {
+		var rule AccessRule
+		err := json.Unmarshal([]byte(values[id]), &rule)
+		if err != nil {
+			log.Printf("Failed to unmarshal access rule %s: %v", id, err)
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func (r *RedisStore) GetAccessVersion() (int64, error) {
+	version, err := r.client.Get(accessVersionKey).Int64()
+	if err != nil {
+		if err == redis.Nil {
+			return 0, nil
+		}
+		log.Printf("Failed to get access rules version: %v", err)
+		return 0, err
+	}
+	return version, nil
+}
+
+// windowCounterKey returns the counter of the fixed window that is offset
+// windows away from the one containing now.
+func windowCounterKey(key string, window time.Duration, now time.Time, offset int64) string {
+	index := now.UnixNano()/int64(window) + offset
+	return fmt.Sprintf("%s:%d", redisKey("counter::", key), index)
+}
+
+// windowOverlap returns how much of the window before the one containing now
+// still overlaps the sliding window ending at now.
+func windowOverlap(window time.Duration, now time.Time) float64 {
+	elapsed := time.Duration(now.UnixNano() % int64(window))
+	return float64(window-elapsed) / float64(window)
+}
+
+// slidingWindowCounterScript weighs the previous window counter by its overlap,
+// adds the current one and counts the cost of the request in the current
+// window only when the estimate leaves room for it. The current counter lives
+// for two windows, as it is still read as the previous one during the next.
+// The result is {estimate, allowed}.
+var slidingWindowCounterScript = redis.NewScript(`
+local limit = tonumber(ARGV[1])
+local cost = tonumber(ARGV[2])
+local window = tonumber(ARGV[3])
+local overlap = tonumber(ARGV[4])
+
+local previous = math.floor((tonumber(redis.call("GET", KEYS[1])) or 0) * overlap)
+local current = tonumber(redis.call("GET", KEYS[2])) or 0
+if previous + current + cost > limit then
+	return {previous + current, 0}
+end
+
+current = redis.call("INCRBY", KEYS[2], cost)
+redis.call("PEXPIRE", KEYS[2], 2 * window)
+return {previous + current, 1}
+`)
+
+func (r *RedisStore) SlidingWindowCounter(key string, limit int64, cost int64, window time.Duration, now time.Time) (int64, bool, error) {
+	keys := []string{windowCounterKey(key, window, now, -1), windowCounterKey(key, window, now, 0)}
+	windowMs := int64(window / time.Millisecond)
+	overlap := strconv.FormatFloat(windowOverlap(window, now), 'f', -1, 64)
+
+	res, err := slidingWindowCounterScript.Run(r.client, keys, limit, cost, windowMs, overlap).Result()
+	if err != nil {
+		log.Printf("Failed to count window for key %s: %v", key, err)
+		return 0, false, err
+	}
+
+	values := res.([]interface{})
+	return values[0].(int64), values[1].(int64) == 1, nil
+}
+
+// refundCounterScript takes the cost of a request back off a counter, keeping
+// its expiry. A counter whose window has ended is left alone.
+var refundCounterScript = redis.NewScript(`
+local cost = tonumber(ARGV[1])
+
+local count = tonumber(redis.call("GET", KEYS[1]))
+if count == nil then
+	return 0
+end
+redis.call("DECRBY", KEYS[1], math.min(count, cost))
+return 1
+`)
+
+func (r *RedisStore) RefundCount(key string, cost int64) error {
+	return r.refundCounter(redisKey("limit::", key), key, cost)
+}
+
+func (r *RedisStore) RefundWindowCounter(key string, cost int64, window time.Duration, chargedAt time.Time) error {
+	return r.refundCounter(windowCounterKey(key, window, chargedAt, 0), key, cost)
+}
+
+func (r *RedisStore) refundCounter(counterKey string, key string, cost int64) error {
+	err := refundCounterScript.Run(r.client, []string{counterKey}, cost).Err()
+	if err != nil {
+		log.Printf("Failed to refund request for key %s: %v", key, err)
+		return err
+	}
+	return nil
+}
+
+// acquireSlotScript drops the slots whose lease has expired, then takes a slot
+// for ARGV[4] when fewer than max_in_flight are held. Slots are members of a
+// sorted set scored by the time their lease expires, in milliseconds.
+var acquireSlotScript = redis.NewScript(`
+local max_in_flight = tonumber(ARGV[1])
+local lease = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", now)
+local count = redis.call("ZCARD", KEYS[1])
+if count >= max_in_flight then
+	return {count, 0}
+end
+
+redis.call("ZADD", KEYS[1], now + lease, ARGV[4])
+redis.call("PEXPIRE", KEYS[1], lease)
+return {count + 1, 1}
+`)
+
+func (r *RedisStore) AcquireSlot(key string, id string, maxInFlight int64, lease time.Duration, now time.Time) (int64, bool, error) {
+	nowMs := now.UnixNano() / int64(time.Millisecond)
+	leaseMs := int64(lease / time.Millisecond)
+
+	res, err := acquireSlotScript.Run(r.client, []string{redisKey("inflight::", key)}, maxInFlight, leaseMs, nowMs, id).Result()
+	if err != nil {
+		log.Printf("Failed to acquire slot for key %s: %v", key, err)
+		return 0, false, err
+	}
+
+	values := res.([]interface{})
+	return values[0].(int64), values[1].(int64) == 1, nil
+}
+
+func (r *RedisStore) RenewSlot(key string, id string, lease time.Duration, now time.Time) error {
+	expiresAt := now.Add(lease).UnixNano() / int64(time.Millisecond)
+
+	pipe := r.client.TxPipeline()
+	// XX only renews the slot while it is still held.
+	pipe.ZAddXX(redisKey("inflight::", key), redis.Z{Score: float64(expiresAt), Member: id})
+	pipe.PExpire(redisKey("inflight::", key), lease)
+	_, err := pipe.Exec()
+	if err != nil {
+		log.Printf("Failed to renew slot for key %s: %v", key, err)
+		return err
+	}
+	return nil
+}
+
+func (r *RedisStore) ReleaseSlot(key string, id string) error {
+	err := r.client.ZRem(redisKey("inflight::", key), id).Err()
+	if err != nil {
+		log.Printf("Failed to release slot for key %s: %v", key, err)
+		return err
+	}
+	return nil
+}
@@ -1,18 +1,22 @@
 package ratelimiter
 
 import (
+	"fmt"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/alicebob/miniredis/v2/server"
+	"github.com/go-redis/redis"
+	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
-	"os"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 )
 
 // TestUpdateLimitDataRedis tests the UpdateLimitData function
 func TestUpdateLimitDataRedis(t *testing.T) {
-	redisAddress := os.Getenv("REDIS_ADDRESS")
-	if redisAddress == "" {
-		redisAddress = "localhost:6379"
-	}
-	store := NewRedisStore(redisAddress)
+	server := miniredis.RunT(t)
+	store := NewRedisStore(server.Addr())
 
 	// Prepare the data
 	err := store.SaveInfoLimitData("testKey", LimitData{})
@@ -21,19 +25,13 @@ func TestUpdateLimitDataRedis(t *testing.T) {
 	// Test UpdateLimitData
 	err = store.UpdateLimitData("testKey", LimitDataInput{})
 	assert.NoError(t, err)
-
-	// Clean up
-	err = store.client.Del("info::testKey").Err()
-	assert.NoError(t, err)
+	assert.Equal(t, ErrNotFound, store.UpdateLimitData("missingKey", LimitDataInput{}))
 }
 
 // TestGetAllLimitDataRedis tests the GetAllLimitData function
 func TestGetAllLimitDataRedis(t *testing.T) {
-	redisAddress := os.Getenv("REDIS_ADDRESS")
-	if redisAddress == "" {
-		redisAddress = "localhost:6379"
-	}
-	store := NewRedisStore(redisAddress)
+	server := miniredis.RunT(t)
+	store := NewRedisStore(server.Addr())
 
 	err := store.SaveInfoLimitData("testKey", LimitData{
 		Seconds:       5,
@@ -46,18 +44,12 @@ func TestGetAllLimitDataRedis(t *testing.T) {
 	data, err := store.GetAllLimitData()
 	assert.NoError(t, err)
 	assert.NotNil(t, data)
-
-	err = store.client.Del("info::testKey").Err()
-	assert.NoError(t, err)
 }
 
 // TestSaveInfoLimitDataRedis tests the SaveInfoLimitData function
 func TestSaveInfoLimitDataRedis(t *testing.T) {
-	redisAddress := os.Getenv("REDIS_ADDRESS")
-	if redisAddress == "" {
-		redisAddress = "localhost:6379"
-	}
-	store := NewRedisStore(redisAddress)
+	server := miniredis.RunT(t)
+	store := NewRedisStore(server.Addr())
 
 	err := store.SaveInfoLimitData("testKey", LimitData{
 		Seconds:       5,
@@ -70,7 +62,752 @@ func TestSaveInfoLimitDataRedis(t *testing.T) {
 	data, err := store.GetInfoLimitData("testKey")
 	assert.NoError(t, err)
 	assert.NotNil(t, data)
+}
+
+// TestSlidingWindowLogRedis tests the SlidingWindowLog function
+func TestSlidingWindowLogRedis(t *testing.T) {
+	server := miniredis.RunT(t)
+	store := NewRedisStore(server.Addr())
+
+	now := time.Now()
+	for i := 1; i <= 2; i++ {
+		count, allowed, err := store.SlidingWindowLog("testKey", uuid.New().String(), 2, 1, time.Second, now)
+		assert.NoError(t, err)
+		assert.True(t, allowed)
+		assert.Equal(t, int64(i), count)
+	}
+
+	_, allowed, err := store.SlidingWindowLog("testKey", uuid.New().String(), 2, 1, time.Second, now.Add(999*time.Millisecond))
+	assert.NoError(t, err)
+	assert.False(t, allowed)
+
+	count, allowed, err := store.SlidingWindowLog("testKey", uuid.New().String(), 2, 1, time.Second, now.Add(time.Second))
+	assert.NoError(t, err)
+	assert.True(t, allowed)
+	assert.Equal(t, int64(1), count)
+}
+
+// TestSlidingWindowLogTotalRedis tests that the log keeps the sum of its costs next to it and only reads the trimmed members
+func TestSlidingWindowLogTotalRedis(t *testing.T) {
+	server := miniredis.RunT(t)
+	store := NewRedisStore(server.Addr())
+	now := time.Now()
+
+	_, _, err := store.SlidingWindowLog("testKey", uuid.New().String(), 10, 3, time.Second, now)
+	assert.NoError(t, err)
+	count, allowed, err := store.SlidingWindowLog("testKey", uuid.New().String(), 10, 4, time.Second, now.Add(500*time.Millisecond))
+	assert.NoError(t, err)
+	assert.True(t, allowed)
+	assert.Equal(t, int64(7), count)
+	total, err := store.client.Get("windowcost::{testKey}").Int64()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(7), total)
+
+	// The first request leaves the window and its cost leaves the total.
+	count, allowed, err = store.SlidingWindowLog("testKey", uuid.New().String(), 10, 5, time.Second, now.Add(time.Second))
+	assert.NoError(t, err)
+	assert.True(t, allowed)
+	assert.Equal(t, int64(9), count)
+	total, err = store.client.Get("windowcost::{testKey}").Int64()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(9), total)
+
+	// A log without a total, written before there was one, is summed once.
+	assert.NoError(t, store.client.Del("windowcost::{testKey}").Err())
+	count, allowed, err = store.SlidingWindowLog("testKey", uuid.New().String(), 10, 2, time.Second, now.Add(time.Second))
+	assert.NoError(t, err)
+	assert.False(t, allowed)
+	assert.Equal(t, int64(9), count)
+
+	// The total goes away with the log.
+	server.FastForward(2 * time.Second)
+	assert.False(t, server.Exists("windowcost::{testKey}"))
+}
+
+// TestTakeTokenRedis tests the TakeToken function
+func TestTakeTokenRedis(t *testing.T) {
+	server := miniredis.RunT(t)
+	store := NewRedisStore(server.Addr())
+
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		_, allowed, err := store.TakeToken("testKey", 2, 1, 1, now)
+		assert.NoError(t, err)
+		assert.True(t, allowed)
+	}
+
+	tokens, allowed, err := store.TakeToken("testKey", 2, 1, 1, now.Add(500*time.Millisecond))
+	assert.NoError(t, err)
+	assert.False(t, allowed)
+	assert.InDelta(t, 0.5, tokens, 0.001)
+
+	tokens, allowed, err = store.TakeToken("testKey", 2, 1, 1, now.Add(time.Second))
+	assert.NoError(t, err)
+	assert.True(t, allowed)
+	assert.InDelta(t, 0, tokens, 0.001)
+}
+
+// TestGCRARedis tests the GCRA function
+func TestGCRARedis(t *testing.T) {
+	server := miniredis.RunT(t)
+	store := NewRedisStore(server.Addr())
+
+	now := time.Now()
+	for i := int64(1); i <= 3; i++ {
+		decision, err := store.GCRA("testKey", time.Second, 3, 1, now)
+		assert.NoError(t, err)
+		assert.False(t, decision.Limited)
+		assert.Equal(t, 3-i, decision.Remaining)
+		assert.Equal(t, time.Duration(i)*time.Second, decision.ResetAfter)
+	}
+
+	decision, err := store.GCRA("testKey", time.Second, 3, 1, now.Add(400*time.Millisecond))
+	assert.NoError(t, err)
+	assert.True(t, decision.Limited)
+	assert.Equal(t, int64(0), decision.Remaining)
+	assert.Equal(t, 600*time.Millisecond, decision.RetryAfter)
+
+	decision, err = store.GCRA("testKey", time.Second, 3, 1, now.Add(time.Second))
+	assert.NoError(t, err)
+	assert.False(t, decision.Limited)
+	assert.Equal(t, int64(0), decision.Remaining)
+}
+
+// TestReserveSlotRedis tests the ReserveSlot function
+func TestReserveSlotRedis(t *testing.T) {
+	server := miniredis.RunT(t)
+	store := NewRedisStore(server.Addr())
+
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		wait, allowed, err := store.ReserveSlot("testKey", time.Second, 2, 0, 1, now)
+		assert.NoError(t, err)
+		assert.True(t, allowed)
+		assert.Equal(t, time.Duration(i)*time.Second, wait)
+	}
+
+	_, allowed, err := store.ReserveSlot("testKey", time.Second, 2, 0, 1, now)
+	assert.NoError(t, err)
+	assert.False(t, allowed)
+
+	wait, allowed, err := store.ReserveSlot("testKey", time.Second, 2, 0, 1, now.Add(time.Second))
+	assert.NoError(t, err)
+	assert.True(t, allowed)
+	assert.Equal(t, 2*time.Second, wait)
+}
+
+// TestSlidingWindowCounterRedis tests the SlidingWindowCounter function
+func TestSlidingWindowCounterRedis(t *testing.T) {
+	server := miniredis.RunT(t)
+	store := NewRedisStore(server.Addr())
+
+	previous := time.Unix(1000, 0)
+	for i := int64(1); i <= 3; i++ {
+		count, allowed, err := store.SlidingWindowCounter("testKey", 4, 1, 10*time.Second, previous)
+		assert.NoError(t, err)
+		assert.True(t, allowed)
+		assert.Equal(t, i, count)
+	}
+
+	// 1s into the next window, 90% of the 3 previous requests still count.
+	now := previous.Add(11 * time.Second)
+	count, allowed, err := store.SlidingWindowCounter("testKey", 4, 1, 10*time.Second, now)
+	assert.NoError(t, err)
+	assert.True(t, allowed)
+	assert.Equal(t, int64(3), count)
+	count, allowed, err = store.SlidingWindowCounter("testKey", 4, 2, 10*time.Second, now)
+	assert.NoError(t, err)
+	assert.False(t, allowed)
+	assert.Equal(t, int64(3), count)
+}
+
+// TestSlotsRedis tests the AcquireSlot, RenewSlot and ReleaseSlot functions
+func TestSlotsRedis(t *testing.T) {
+	server := miniredis.RunT(t)
+	store := NewRedisStore(server.Addr())
+
+	now := time.Now()
+	_, acquired, err := store.AcquireSlot("testKey", "first", 1, time.Second, now)
+	assert.NoError(t, err)
+	assert.True(t, acquired)
+
+	_, acquired, err = store.AcquireSlot("testKey", "second", 1, time.Second, now)
+	assert.NoError(t, err)
+	assert.False(t, acquired)
+
+	// A slot whose lease was not renewed is taken back.
+	_, acquired, err = store.AcquireSlot("testKey", "second", 1, time.Second, now.Add(time.Second))
+	assert.NoError(t, err)
+	assert.True(t, acquired)
+
+	err = store.RenewSlot("testKey", "second", time.Second, now.Add(1500*time.Millisecond))
+	assert.NoError(t, err)
+
+	_, acquired, err = store.AcquireSlot("testKey", "third", 1, time.Second, now.Add(2*time.Second))
+	assert.NoError(t, err)
+	assert.False(t, acquired)
+
+	err = store.ReleaseSlot("testKey", "second")
+	assert.NoError(t, err)
+
+	_, acquired, err = store.AcquireSlot("testKey", "third", 1, time.Second, now.Add(2*time.Second))
+	assert.NoError(t, err)
+	assert.True(t, acquired)
+}
+
+// TestCheckAndIncrementRedis tests the CheckAndIncrement function
+func TestCheckAndIncrementRedis(t *testing.T) {
+	server := miniredis.RunT(t)
+	store := NewRedisStore(server.Addr())
+
+	for i := int64(1); i <= 2; i++ {
+		decision, err := store.CheckAndIncrement("testKey", 2, 1, 10*time.Second, 30*time.Second)
+		assert.NoError(t, err)
+		assert.False(t, decision.Limited)
+		assert.Equal(t, 2-i, decision.Remaining)
+	}
+
+	decision, err := store.CheckAndIncrement("testKey", 2, 1, 10*time.Second, 30*time.Second)
+	assert.NoError(t, err)
+	assert.True(t, decision.Limited)
+	assert.Equal(t, 30*time.Second, decision.RetryAfter)
+
+	ttl, err := store.client.PTTL("limit::{testKey}").Result()
+	assert.NoError(t, err)
+	assert.True(t, ttl > 0)
+
+	// Neither the limited request nor the ones made while blocked are counted.
+	decision, err = store.CheckAndIncrement("testKey", 2, 1, 10*time.Second, 30*time.Second)
+	assert.NoError(t, err)
+	assert.True(t, decision.Limited)
+	count, err := store.client.Get("limit::{testKey}").Int64()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), count)
+
+	err = store.client.Del("limit::{testKey}", "blocked:{testKey}").Err()
+	assert.NoError(t, err)
+
+	// A request costing more than what is left is refused without consuming it.
+	decision, err = store.CheckAndIncrement("testKey", 5, 3, 10*time.Second, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), decision.Remaining)
+	decision, err = store.CheckAndIncrement("testKey", 5, 3, 10*time.Second, 0)
+	assert.NoError(t, err)
+	assert.True(t, decision.Limited)
+	decision, err = store.CheckAndIncrement("testKey", 5, 2, 10*time.Second, 0)
+	assert.NoError(t, err)
+	assert.False(t, decision.Limited)
+	assert.Equal(t, int64(0), decision.Remaining)
+}
+
+// TestRequestCostRedis tests that a request costing more than what is left consumes nothing
+func TestRequestCostRedis(t *testing.T) {
+	server := miniredis.RunT(t)
+	store := NewRedisStore(server.Addr())
+	now := time.Now()
+
+	count, allowed, err := store.SlidingWindowLog("testKey", uuid.New().String(), 5, 4, time.Second, now)
+	assert.NoError(t, err)
+	assert.True(t, allowed)
+	assert.Equal(t, int64(4), count)
+	count, allowed, err = store.SlidingWindowLog("testKey", uuid.New().String(), 5, 2, time.Second, now)
+	assert.NoError(t, err)
+	assert.False(t, allowed)
+	assert.Equal(t, int64(4), count)
+
+	_, allowed, err = store.TakeToken("testKey", 5, 1, 4, now)
+	assert.NoError(t, err)
+	assert.True(t, allowed)
+	tokens, allowed, err := store.TakeToken("testKey", 5, 1, 2, now)
+	assert.NoError(t, err)
+	assert.False(t, allowed)
+	assert.Equal(t, 1.0, tokens)
+
+	decision, err := store.GCRA("testKey", time.Second, 5, 4, now)
+	assert.NoError(t, err)
+	assert.False(t, decision.Limited)
+	assert.Equal(t, int64(1), decision.Remaining)
+	decision, err = store.GCRA("testKey", time.Second, 5, 2, now)
+	assert.NoError(t, err)
+	assert.True(t, decision.Limited)
+	assert.Equal(t, time.Second, decision.RetryAfter)
+
+	_, allowed, err = store.ReserveSlot("testKey", time.Second, 2, 0, 3, now)
+	assert.NoError(t, err)
+	assert.True(t, allowed)
+	wait, allowed, err := store.ReserveSlot("testKey", time.Second, 2, 0, 1, now)
+	assert.NoError(t, err)
+	assert.False(t, allowed)
+	assert.Equal(t, 3*time.Second, wait)
+}
+
+// TestRefundRedis tests that a refund gives back what a request was charged
+func TestRefundRedis(t *testing.T) {
+	server := miniredis.RunT(t)
+	store := NewRedisStore(server.Addr())
+	now := time.Now()
+
+	_, err := store.CheckAndIncrement("testKey", 5, 3, 10*time.Second, 0)
+	assert.NoError(t, err)
+	assert.NoError(t, store.RefundCount("testKey", 3))
+	decision, err := store.CheckAndIncrement("testKey", 5, 5, 10*time.Second, 0)
+	assert.NoError(t, err)
+	assert.False(t, decision.Limited)
+
+	_, _, err = store.SlidingWindowCounter("testKey", 5, 3, 10*time.Second, now)
+	assert.NoError(t, err)
+	assert.NoError(t, store.RefundWindowCounter("testKey", 3, 10*time.Second, now))
+	_, allowed, err := store.SlidingWindowCounter("testKey", 5, 5, 10*time.Second, now)
+	assert.NoError(t, err)
+	assert.True(t, allowed)
+
+	_, _, err = store.SlidingWindowLog("testKey", "first", 5, 2, time.Second, now)
+	assert.NoError(t, err)
+	_, _, err = store.SlidingWindowLog("testKey", "second", 5, 2, time.Second, now.Add(500*time.Millisecond))
+	assert.NoError(t, err)
+	members, err := store.client.ZCard("window::{testKey}").Result()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), members)
+	assert.NoError(t, store.RefundWindowLog("testKey", "first", 2))
+	total, err := store.client.Get("windowcost::{testKey}").Int64()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), total)
+	// The second request, of the same cost, is still logged once the first
+	// would have left the window.
+	count, allowed, err := store.SlidingWindowLog("testKey", "third", 5, 3, time.Second, now.Add(time.Second))
+	assert.NoError(t, err)
+	assert.True(t, allowed)
+	assert.Equal(t, int64(5), count)
+
+	_, _, err = store.TakeToken("testKey", 5, 1, 3, now)
+	assert.NoError(t, err)
+	assert.NoError(t, store.RefundTokens("testKey", 5, 4))
+	tokens, allowed, err := store.TakeToken("testKey", 5, 1, 5, now)
+	assert.NoError(t, err)
+	assert.True(t, allowed)
+	assert.Equal(t, 0.0, tokens)
 
-	err = store.client.Del("info::testKey").Err()
+	_, err = store.GCRA("testKey", time.Second, 5, 3, now)
 	assert.NoError(t, err)
+	assert.NoError(t, store.RefundGCRA("testKey", time.Second, 3, now))
+	decision, err = store.GCRA("testKey", time.Second, 5, 5, now)
+	assert.NoError(t, err)
+	assert.False(t, decision.Limited)
+
+	_, _, err = store.ReserveSlot("testKey", time.Second, 2, 0, 3, now)
+	assert.NoError(t, err)
+	assert.NoError(t, store.RefundSlot("testKey", time.Second, 3, now))
+	wait, allowed, err := store.ReserveSlot("testKey", time.Second, 2, 0, 1, now)
+	assert.NoError(t, err)
+	assert.True(t, allowed)
+	assert.Equal(t, time.Duration(0), wait)
+}
+
+// TestOversizedCostRedis tests that a request costing more than the limit is refused without blocking the key
+func TestOversizedCostRedis(t *testing.T) {
+	server := miniredis.RunT(t)
+	store := NewRedisStore(server.Addr())
+
+	decision, err := store.CheckAndIncrement("testKey", 5, 6, 10*time.Second, time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, decision.Limited)
+	assert.False(t, decision.Blocked)
+	assert.False(t, server.Exists("blocked:{testKey}"))
+}
+
+// TestLimitDataIndexRedis tests the id index and DeleteLimitData of the RedisStore
+func TestLimitDataIndexRedis(t *testing.T) {
+	server := miniredis.RunT(t)
+	store := NewRedisStore(server.Addr())
+
+	assert.NoError(t, store.SaveInfoLimitData("testKey", LimitData{Key: "testKey", Seconds: 5, Id: "testId"}))
+	key, err := store.GetKeyByID("testId")
+	assert.NoError(t, err)
+	assert.Equal(t, "testKey", key)
+	assert.True(t, server.Exists("id::{testId}"))
+
+	// The index is not listed as limit data.
+	all, err := store.GetAllLimitData()
+	assert.NoError(t, err)
+	assert.Len(t, all, 1)
+
+	assert.NoError(t, store.DeleteLimitData("testKey"))
+	_, err = store.GetInfoLimitData("testKey")
+	assert.Equal(t, ErrNotFound, err)
+	_, err = store.GetKeyByID("testId")
+	assert.Equal(t, ErrNotFound, err)
+	assert.Equal(t, ErrNotFound, store.DeleteLimitData("testKey"))
+}
+
+// TestCreateLimitDataRedis tests that CreateLimitData refuses a key or an id that is taken
+func TestCreateLimitDataRedis(t *testing.T) {
+	server := miniredis.RunT(t)
+	store := NewRedisStore(server.Addr())
+
+	assert.NoError(t, store.CreateLimitData("testKey", LimitData{Key: "testKey", Seconds: 5, Id: "testId"}))
+	assert.Equal(t, ErrExists, store.CreateLimitData("testKey", LimitData{Key: "testKey", Seconds: 10, Id: "otherId"}))
+	assert.Equal(t, ErrExists, store.CreateLimitData("otherKey", LimitData{Key: "otherKey", Seconds: 10, Id: "testId"}))
+
+	data, err := store.GetInfoLimitData("testKey")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), data.Seconds)
+	assert.False(t, server.Exists("info::{otherKey}"))
+	assert.False(t, server.Exists("id::{otherId}"))
+
+	// A limit without an id is not indexed.
+	assert.NoError(t, store.CreateLimitData("noId", LimitData{Key: "noId", Seconds: 5}))
+	assert.False(t, server.Exists("id::{}"))
+}
+
+// TestMigrateUntaggedKeysRedis tests that the limit data and blocks written before the hash tags are renamed
+func TestMigrateUntaggedKeysRedis(t *testing.T) {
+	server := miniredis.RunT(t)
+	store := NewRedisStore(server.Addr())
+
+	assert.NoError(t, server.Set("info::user:1", `{"key":"user:1","seconds":10,"max_requests":3}`))
+	assert.NoError(t, server.Set("blocked:user:1", "1"))
+	server.SetTTL("blocked:user:1", 30*time.Second)
+	assert.NoError(t, server.Set("info::user:2", `{"key":"user:2","seconds":10,"max_requests":1}`))
+	assert.NoError(t, store.SaveInfoLimitData("user:2", LimitData{Key: "user:2", Seconds: 10, MaxRequests: 5}))
+
+	migrated, err := store.MigrateUntaggedKeys()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), migrated)
+
+	data, err := store.GetInfoLimitData("user:1")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), data.MaxRequests)
+	ttl, blocked, err := store.GetBlockTTL("blocked:user:1")
+	assert.NoError(t, err)
+	assert.True(t, blocked)
+	assert.Equal(t, 30*time.Second, ttl)
+
+	// The limit data saved after the upgrade wins over the untagged one.
+	data, err = store.GetInfoLimitData("user:2")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), data.MaxRequests)
+	assert.False(t, server.Exists("info::user:2"))
+
+	migrated, err = store.MigrateUntaggedKeys()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), migrated)
+}
+
+// TestAccessRulesRedis tests the access rule functions of the RedisStore
+func TestAccessRulesRedis(t *testing.T) {
+	server := miniredis.RunT(t)
+	store := NewRedisStore(server.Addr())
+
+	version, err := store.GetAccessVersion()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), version)
+
+	assert.NoError(t, store.SaveAccessRule(AccessRule{List: AccessDeny, Match: MatchCIDR, Value: "203.0.113.0/24"}))
+	assert.NoError(t, store.SaveAccessRule(AccessRule{List: AccessAllow, Match: MatchKey, Value: "health"}))
+
+	rules, err := store.GetAccessRules()
+	assert.NoError(t, err)
+	assert.Equal(t, []AccessRule{
+		{List: AccessAllow, Match: MatchKey, Value: "health"},
+		{List: AccessDeny, Match: MatchCIDR, Value: "203.0.113.0/24"},
+	}, rules)
+
+	assert.NoError(t, store.DeleteAccessRule(AccessRule{List: AccessAllow, Match: MatchKey, Value: "health"}))
+	assert.Equal(t, ErrNotFound, store.DeleteAccessRule(AccessRule{List: AccessAllow, Match: MatchKey, Value: "health"}))
+
+	version, err = store.GetAccessVersion()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), version)
+}
+
+// TestGetBlockTTLRedis tests that GetBlockTTL reports the time left on a block
+func TestGetBlockTTLRedis(t *testing.T) {
+	server := miniredis.RunT(t)
+	store := NewRedisStore(server.Addr())
+
+	_, blocked, err := store.GetBlockTTL("blocked:testKey")
+	assert.NoError(t, err)
+	assert.False(t, blocked)
+
+	err = store.SetBlockDuration("blocked:testKey", 1, 30*time.Second)
+	assert.NoError(t, err)
+	ttl, blocked, err := store.GetBlockTTL("blocked:testKey")
+	assert.NoError(t, err)
+	assert.True(t, blocked)
+	assert.True(t, ttl > 0 && ttl <= 30*time.Second)
+
+	err = store.SetBlockDuration("blocked:testKey", 1, 0)
+	assert.NoError(t, err)
+	ttl, blocked, err = store.GetBlockTTL("blocked:testKey")
+	assert.NoError(t, err)
+	assert.True(t, blocked)
+	assert.Equal(t, time.Duration(0), ttl)
+}
+
+// TestResetRedis tests that resets delete the counters and blocks of the matching keys
+func TestResetRedis(t *testing.T) {
+	server := miniredis.RunT(t)
+	store := NewRedisStore(server.Addr())
+	now := time.Now()
+
+	for _, key := range []string{"user:1", "user:2", "user:[3]", "admin"} {
+		assert.NoError(t, store.SaveInfoLimitData(key, LimitData{Key: key, Seconds: 10, MaxRequests: 1}))
+		for i := 0; i < 2; i++ {
+			_, err := store.CheckAndIncrement(key, 1, 1, 10*time.Second, 30*time.Second)
+			assert.NoError(t, err)
+		}
+		_, _, err := store.SlidingWindowCounter(key, 10, 1, 10*time.Second, now)
+		assert.NoError(t, err)
+		_, _, err = store.SlidingWindowCounter(key, 10, 1, 10*time.Second, now.Add(-10*time.Second))
+		assert.NoError(t, err)
+	}
+
+	unblocked, err := store.Unblock("admin")
+	assert.NoError(t, err)
+	assert.True(t, unblocked)
+	unblocked, err = store.Unblock("admin")
+	assert.NoError(t, err)
+	assert.False(t, unblocked)
+	assert.True(t, server.Exists("limit::{admin}"))
+
+	count, err := store.UnblockMatching("user:?")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), count)
+	assert.True(t, server.Exists("blocked:{user:[3]}"))
+
+	// The brackets of the key are not read as a class.
+	assert.NoError(t, store.ResetKey("user:[3]"))
+	for _, k := range server.Keys() {
+		assert.False(t, strings.Contains(k, "{user:[3]}") && !strings.HasPrefix(k, "info::"), k)
+	}
+
+	count, err = store.ResetMatching("user:*")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), count)
+	assert.ElementsMatch(t, []string{
+		"info::{user:1}", "info::{user:2}", "info::{user:[3]}", "info::{admin}",
+		"limit::{admin}", "counter::{admin}:" + fmt.Sprint(now.UnixNano()/int64(10*time.Second)),
+		"counter::{admin}:" + fmt.Sprint(now.UnixNano()/int64(10*time.Second)-1),
+	}, server.Keys())
+}
+
+// TestListBlockedRedis tests that blocks are listed with their TTL and info
+func TestListBlockedRedis(t *testing.T) {
+	server := miniredis.RunT(t)
+	store := NewRedisStore(server.Addr())
+	limiter := NewRateLimiter(store)
+
+	data := LimitData{Key: "user:1", Id: "limit-1", Seconds: 10, MaxRequests: 1, BlockDuration: 30}
+	for i := 0; i < 2; i++ {
+		_, err := limiter.Evaluate("user:1", data)
+		assert.NoError(t, err)
+	}
+	assert.True(t, server.Exists("blockinfo::{user:1}"))
+	assert.Equal(t, 30*time.Second, server.TTL("blockinfo::{user:1}"))
+	assert.NoError(t, limiter.Block("user:2", 0))
+	assert.NoError(t, store.SetBlockDuration("blocked:user:3", 1, time.Minute))
+
+	var items []BlockInfo
+	cursor := ""
+	for {
+		page, err := store.ListBlocked(cursor, 1)
+		assert.NoError(t, err)
+		items = append(items, page.Items...)
+		if cursor = page.NextCursor; cursor == "" {
+			break
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Key < items[j].Key })
+	assert.Len(t, items, 3)
+
+	assert.Equal(t, "limit-1", items[0].Policy)
+	assert.NotNil(t, items[0].BlockedAt)
+	assert.WithinDuration(t, time.Now().Add(30*time.Second), *items[0].ExpiresAt, time.Second)
+	assert.NotNil(t, items[1].BlockedAt)
+	assert.Nil(t, items[1].ExpiresAt)
+	assert.Nil(t, items[2].BlockedAt)
+	assert.NotNil(t, items[2].ExpiresAt)
+
+	_, err := store.ListBlocked("x", 1)
+	assert.Equal(t, ErrInvalidCursor, err)
+
+	count, err := store.UnblockMatching("user:*")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), count)
+	// The counter is kept, only the blocks and their info are gone.
+	assert.Equal(t, []string{"limit::{user:1}"}, server.Keys())
+}
+
+// TestClusterModeRedis tests that every entry of a key lands on the same cluster node
+func TestClusterModeRedis(t *testing.T) {
+	first := miniredis.RunT(t)
+	second := miniredis.RunT(t)
+
+	store, err := NewRedisStoreWithOptions(RedisOptions{
+		Mode:  RedisModeCluster,
+		Addrs: []string{first.Addr(), second.Addr()},
+		clusterSlots: func() ([]redis.ClusterSlot, error) {
+			return []redis.ClusterSlot{
+				{Start: 0, End: 8191, Nodes: []redis.ClusterNode{{Addr: first.Addr()}}},
+				{Start: 8192, End: 16383, Nodes: []redis.ClusterNode{{Addr: second.Addr()}}},
+			}, nil
+		},
+	})
+	assert.NoError(t, err)
+
+	keys := []string{"alpha", "bravo", "charlie", "delta", "echo", "foxtrot"}
+	for _, key := range keys {
+		err := store.SaveInfoLimitData(key, LimitData{Key: key, Seconds: 10, MaxRequests: 1})
+		assert.NoError(t, err)
+		for i := 0; i < 2; i++ {
+			_, err := store.CheckAndIncrement(key, 1, 1, 10*time.Second, 30*time.Second)
+			assert.NoError(t, err)
+		}
+		_, _, err = store.SlidingWindowCounter(key, 10, 1, 10*time.Second, time.Now())
+		assert.NoError(t, err)
+	}
+
+	for _, key := range keys {
+		tag := "{" + key + "}"
+		onFirst, onSecond := 0, 0
+		for _, k := range first.Keys() {
+			if strings.Contains(k, tag) {
+				onFirst++
+			}
+		}
+		for _, k := range second.Keys() {
+			if strings.Contains(k, tag) {
+				onSecond++
+			}
+		}
+		assert.Equal(t, 4, onFirst+onSecond, key)
+		assert.True(t, onFirst == 0 || onSecond == 0, "entries of %s are split across nodes", key)
+	}
+	assert.NotEmpty(t, first.Keys())
+	assert.NotEmpty(t, second.Keys())
+
+	all, err := store.GetAllLimitData()
+	assert.NoError(t, err)
+	assert.Len(t, all, len(keys))
+
+	// The bulk reset walks every node and leaves only the limit data behind.
+	reset, err := store.ResetMatching("*")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(keys)), reset)
+	assert.Len(t, append(first.Keys(), second.Keys()...), len(keys))
+}
+
+// TestSentinelModeRedis tests that the store reaches the master a Sentinel points to
+func TestSentinelModeRedis(t *testing.T) {
+	master := miniredis.RunT(t)
+
+	sentinel, err := server.NewServer("127.0.0.1:0")
+	assert.NoError(t, err)
+	defer sentinel.Close()
+	sentinel.Register("SENTINEL", func(c *server.Peer, cmd string, args []string) {
+		switch strings.ToLower(args[0]) {
+		case "get-master-addr-by-name":
+			c.WriteStrings([]string{master.Host(), master.Port()})
+		default:
+			c.WriteLen(0)
+		}
+	})
+	sentinel.Register("SUBSCRIBE", func(c *server.Peer, cmd string, args []string) {
+		c.WriteLen(3)
+		c.WriteBulk("subscribe")
+		c.WriteBulk(args[0])
+		c.WriteInt(1)
+	})
+
+	store, err := NewRedisStoreWithOptions(RedisOptions{
+		Mode:       RedisModeSentinel,
+		Addrs:      []string{sentinel.Addr().String()},
+		MasterName: "mymaster",
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, store.Ping())
+
+	err = store.SaveInfoLimitData("testKey", LimitData{Seconds: 10})
+	assert.NoError(t, err)
+	assert.True(t, master.Exists("info::{testKey}"))
+}
+
+// TestRedisOptionsValidation tests that invalid Redis options are refused
+func TestRedisOptionsValidation(t *testing.T) {
+	_, err := NewRedisStoreWithOptions(RedisOptions{})
+	assert.Error(t, err)
+
+	_, err = NewRedisStoreWithOptions(RedisOptions{Mode: RedisModeSentinel, Addrs: []string{"localhost:26379"}})
+	assert.Error(t, err)
+
+	_, err = NewRedisStoreWithOptions(RedisOptions{Mode: "unknown", Addrs: []string{"localhost:6379"}})
+	assert.Error(t, err)
+}
+
+// TestListLimitDataRedis tests that every key is listed exactly once across pages
+func TestListLimitDataRedis(t *testing.T) {
+	m := miniredis.RunT(t)
+	store := NewRedisStore(m.Addr())
+
+	for i := 0; i < 25; i++ {
+		key := fmt.Sprintf("key%d", i)
+		assert.NoError(t, store.SaveInfoLimitData(key, LimitData{Key: key}))
+	}
+	// A broken entry is skipped instead of failing the listing.
+	assert.NoError(t, m.Set("info::{broken}", "not json"))
+
+	seen := make(map[string]bool)
+	cursor := ""
+	for {
+		page, err := store.ListLimitData(cursor, 10)
+		assert.NoError(t, err)
+		for _, data := range page.Items {
+			assert.False(t, seen[data.Key], data.Key)
+			seen[data.Key] = true
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+	assert.Len(t, seen, 25)
+
+	_, err := store.ListLimitData("nonsense", 10)
+	assert.Equal(t, ErrInvalidCursor, err)
+	_, err = store.ListLimitData("3-0", 10)
+	assert.Equal(t, ErrInvalidCursor, err)
+}
+
+func TestBanRedis(t *testing.T) {
+	server := miniredis.RunT(t)
+	store := NewRedisStore(server.Addr())
+	limiter := NewRateLimiter(store)
+
+	_, err := limiter.Ban("user:1", 0, "abuse", "alice")
+	assert.NoError(t, err)
+	assert.NoError(t, limiter.Block("user:2", time.Hour))
+
+	ban, err := limiter.GetBan("user:1")
+	assert.NoError(t, err)
+	assert.Equal(t, BlockTypeBan, ban.Type)
+	assert.Equal(t, "abuse", ban.Reason)
+	assert.Equal(t, "alice", ban.Actor)
+	assert.Nil(t, ban.ExpiresAt)
+	_, err = limiter.GetBan("user:2")
+	assert.Equal(t, ErrNotFound, err)
+	_, err = limiter.GetBan("user:3")
+	assert.Equal(t, ErrNotFound, err)
+
+	// Resets and unblocks leave the ban in place.
+	assert.NoError(t, limiter.Reset("user:1"))
+	count, err := limiter.ResetMatching("user:*")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+	count, err = limiter.UnblockMatching("user:*")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), count)
+	assert.Equal(t, []string{"blocked:{user:1}", "blockinfo::{user:1}"}, server.Keys())
+
+	assert.NoError(t, limiter.Unban("user:1"))
+	assert.Empty(t, server.Keys())
 }
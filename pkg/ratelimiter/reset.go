@@ -0,0 +1,150 @@
+package ratelimiter
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrInvalidPattern is returned when a bulk reset or unblock has no pattern.
+var ErrInvalidPattern = errors.New("invalid key pattern")
+
+// stateKeyPrefixes are the prefixes of the counters and blocks of a key, which
+// a reset deletes. The in-flight slots and the limit data are not state: the
+// slots belong to requests still running and expire with their lease.
+var stateKeyPrefixes = []string{"limit::", "blocked:", "blockinfo::", "window::", "windowcost::", "bucket::", "gcra::", "queue::", "counter::"}
+
+// isBlockPrefix reports whether prefix holds the block of a key, which a reset
+// keeps when it is a ban.
+func isBlockPrefix(prefix string) bool {
+	return prefix == "blocked:" || prefix == "blockinfo::"
+}
+
+// Reset deletes the counters and the block of key, so its next request starts
+// with a full quota. Its limit data and its ban, if any, are kept.
+func (r *RateLimiter) Reset(key string) error {
+	return r.store.ResetKey(key)
+}
+
+// Unblock lifts the block of key and reports whether it was blocked. The
+// counters are kept, so a key over its limit is blocked again on its next
+// request. A ban is not lifted: it returns ErrBanned.
+func (r *RateLimiter) Unblock(key string) (bool, error) {
+	_, err := r.GetBan(key)
+	if err == nil {
+		return false, ErrBanned
+	}
+	if err != ErrNotFound {
+		return false, err
+	}
+	return r.store.Unblock(key)
+}
+
+// ResetMatching resets every key matching the glob pattern, as understood by
+// Redis SCAN MATCH, and returns how many keys were reset. Bans are kept.
+func (r *RateLimiter) ResetMatching(pattern string) (int64, error) {
+	if pattern == "" {
+		return 0, ErrInvalidPattern
+	}
+	return r.store.ResetMatching(pattern)
+}
+
+// UnblockMatching unblocks every key matching the glob pattern, except the
+// banned ones, and returns how many keys were unblocked.
+func (r *RateLimiter) UnblockMatching(pattern string) (int64, error) {
+	if pattern == "" {
+		return 0, ErrInvalidPattern
+	}
+	return r.store.UnblockMatching(pattern)
+}
+
+// matchGlob reports whether s matches pattern the way Redis SCAN MATCH does:
+// * matches any run of bytes, ? any byte, [...] a class of bytes, negated
+// with ^ and holding ranges such as a-z, and \ escapes the byte after it.
+func matchGlob(pattern string, s string) bool {
+	for pattern != "" {
+		switch pattern[0] {
+		case '*':
+			pattern = strings.TrimLeft(pattern, "*")
+			if pattern == "" {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if matchGlob(pattern, s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if s == "" {
+				return false
+			}
+			pattern, s = pattern[1:], s[1:]
+		case '[':
+			if s == "" {
+				return false
+			}
+			n, matched := matchClass(pattern[1:], s[0])
+			if !matched {
+				return false
+			}
+			pattern, s = pattern[1+n:], s[1:]
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if s == "" || s[0] != pattern[0] {
+				return false
+			}
+			pattern, s = pattern[1:], s[1:]
+		}
+	}
+	return s == ""
+}
+
+// matchClass matches c against the class that class starts with, right after
+// its [, and returns the length of the class up to and including its ].
+func matchClass(class string, c byte) (int, bool) {
+	i := 0
+	negate := false
+	if i < len(class) && class[i] == '^' {
+		negate = true
+		i++
+	}
+
+	matched := false
+	for ; i < len(class) && class[i] != ']'; i++ {
+		switch {
+		case class[i] == '\\' && i+1 < len(class):
+			i++
+			matched = matched || class[i] == c
+		case i+2 < len(class) && class[i+1] == '-' && class[i+2] != ']':
+			lo, hi := class[i], class[i+2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			matched = matched || (lo <= c && c <= hi)
+			i += 2
+		default:
+			matched = matched || class[i] == c
+		}
+	}
+	if i < len(class) {
+		i++
+	}
+	return i, matched != negate
+}
+
+// escapeGlob escapes the bytes of key that are special in a glob pattern.
+func escapeGlob(key string) string {
+	var escaped strings.Builder
+	for i := 0; i < len(key); i++ {
+		switch key[i] {
+		case '*', '?', '[', ']', '\\':
+			escaped.WriteByte('\\')
+		}
+		escaped.WriteByte(key[i])
+	}
+	return escaped.String()
+}
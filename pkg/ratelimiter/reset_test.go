@@ -0,0 +1,54 @@
+package ratelimiter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestMatchGlob tests that patterns are matched like Redis SCAN MATCH does
+func TestMatchGlob(t *testing.T) {
+	for _, c := range []struct {
+		pattern string
+		key     string
+		match   bool
+	}{
+		{"*", "", true},
+		{"user:*", "user:42", true},
+		{"user:*", "admin", false},
+		{"*:42", "user:42", true},
+		{"route:POST /orders:*", "route:POST /orders:203.0.113.9", true},
+		{"user:?", "user:1", true},
+		{"user:?", "user:12", false},
+		{"user:[12]", "user:2", true},
+		{"user:[^12]", "user:2", false},
+		{"user:[0-9]*", "user:42", true},
+		{"user:[a-z]", "user:7", false},
+		{`user:\*`, "user:*", true},
+		{`user:\*`, "user:1", false},
+		{escapeGlob("user:[1]"), "user:[1]", true},
+		{escapeGlob("user:[1]"), "user:1", false},
+	} {
+		assert.Equal(t, c.match, matchGlob(c.pattern, c.key), "%q %q", c.pattern, c.key)
+	}
+}
+
+// TestResetMatching tests that the bulk operations refuse an empty pattern
+func TestResetMatching(t *testing.T) {
+	store := NewMemoryStore()
+	defer store.Close()
+	limiter := NewRateLimiter(store)
+
+	_, err := limiter.ResetMatching("")
+	assert.Equal(t, ErrInvalidPattern, err)
+	_, err = limiter.UnblockMatching("")
+	assert.Equal(t, ErrInvalidPattern, err)
+
+	assert.NoError(t, limiter.Block("user:1", 0))
+	count, err := limiter.UnblockMatching("user:*")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+	blocked, err := limiter.IsBlocked("user:1")
+	assert.NoError(t, err)
+	assert.False(t, blocked)
+}
@@ -0,0 +1,48 @@
+package ratelimiter
+
+import (
+	"time"
+)
+
+// slidingWindowCounter approximates a sliding window with the counters of the
+// current and the previous fixed window: the previous count is weighted by how
+// much of it still overlaps the sliding window. It only keeps two counters per
+// key, yet smooths out the bursts a fixed window allows at its boundary. The
+// estimate and the increment are one atomic store call, so concurrent requests
+// cannot all pass on the same stale counts.
+type slidingWindowCounter struct{}
+
+func (slidingWindowCounter) Limit(r *RateLimiter, key string, data LimitData, cost int64) (Decision, error) {
+	if decision, blocked, err := r.blocked(key, data.MaxRequests); err != nil || blocked {
+		return decision, err
+	}
+
+	window := time.Duration(data.Seconds) * time.Second
+	now := r.now()
+	count, allowed, err := r.store.SlidingWindowCounter(key, data.MaxRequests, cost, window, now)
+	if err != nil {
+		return Decision{}, err
+	}
+
+	elapsed := time.Duration(now.UnixNano() % int64(window))
+	decision := Decision{
+		Limit:      data.MaxRequests,
+		ResetAfter: 2*window - elapsed,
+	}
+	if !allowed {
+		return r.reject(key, data, decision, cost)
+	}
+
+	decision.Remaining = data.MaxRequests - count
+	if decision.Remaining < 0 {
+		decision.Remaining = 0
+	}
+	decision.charged.at = now
+	return decision, nil
+}
+
+// Refund takes the cost off the window the request was counted in, which is
+// not the current one once a window boundary has passed.
+func (slidingWindowCounter) Refund(r *RateLimiter, key string, data LimitData, charged charge) error {
+	return r.store.RefundWindowCounter(key, charged.cost, time.Duration(data.Seconds)*time.Second, charged.at)
+}
@@ -0,0 +1,59 @@
+package ratelimiter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestSlidingWindowCounter tests that the estimate of the store is turned into the remaining quota
+func TestSlidingWindowCounter(t *testing.T) {
+	now := time.Unix(1000, 0).Add(2500 * time.Millisecond)
+	store := &MockStore{
+		GetBlockTTLFunc: func(key string) (time.Duration, bool, error) {
+			return 0, false, nil
+		},
+		SlidingWindowCounterFunc: func(key string, limit int64, cost int64, window time.Duration, at time.Time) (int64, bool, error) {
+			assert.Equal(t, "testKey", key)
+			assert.Equal(t, int64(10), limit)
+			assert.Equal(t, int64(1), cost)
+			assert.Equal(t, 10*time.Second, window)
+			assert.Equal(t, now, at)
+			return 8, true, nil
+		},
+	}
+	rateLimiter := NewRateLimiter(store)
+	rateLimiter.now = func() time.Time { return now }
+
+	decision, err := rateLimiter.Evaluate("testKey", LimitData{
+		Algorithm:   AlgorithmSlidingWindow,
+		Seconds:     10,
+		MaxRequests: 10,
+	})
+	assert.NoError(t, err)
+	assert.False(t, decision.Limited)
+	assert.Equal(t, int64(2), decision.Remaining)
+	assert.Equal(t, 17500*time.Millisecond, decision.ResetAfter)
+}
+
+// TestSlidingWindowCounterRejects tests that a request the store does not count is limited
+func TestSlidingWindowCounterRejects(t *testing.T) {
+	store := &MockStore{
+		GetBlockTTLFunc: func(key string) (time.Duration, bool, error) {
+			return 0, false, nil
+		},
+		SlidingWindowCounterFunc: func(key string, limit int64, cost int64, window time.Duration, at time.Time) (int64, bool, error) {
+			return 10, false, nil
+		},
+	}
+	rateLimiter := NewRateLimiter(store)
+
+	decision, err := rateLimiter.Evaluate("testKey", LimitData{
+		Algorithm:   AlgorithmSlidingWindow,
+		Seconds:     10,
+		MaxRequests: 10,
+	})
+	assert.NoError(t, err)
+	assert.True(t, decision.Limited)
+}
@@ -0,0 +1,42 @@
+package ratelimiter
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// slidingWindowLog keeps the timestamp of every accepted request in the last
+// Seconds, with its cost, and only accepts a new one while the logged costs
+// leave room for it, so a burst across a window boundary can never exceed the
+// limit. Each request is logged under its own id, which a refund removes.
+type slidingWindowLog struct{}
+
+func (slidingWindowLog) Limit(r *RateLimiter, key string, data LimitData, cost int64) (Decision, error) {
+	if decision, blocked, err := r.blocked(key, data.MaxRequests); err != nil || blocked {
+		return decision, err
+	}
+
+	window := time.Duration(data.Seconds) * time.Second
+	id := uuid.New().String()
+	count, allowed, err := r.store.SlidingWindowLog(key, id, data.MaxRequests, cost, window, r.now())
+	if err != nil {
+		return Decision{}, err
+	}
+
+	decision := Decision{
+		Limit:      data.MaxRequests,
+		Remaining:  data.MaxRequests - count,
+		ResetAfter: window,
+	}
+	if !allowed {
+		return r.reject(key, data, decision, cost)
+	}
+
+	decision.charged.id = id
+	return decision, nil
+}
+
+func (slidingWindowLog) Refund(r *RateLimiter, key string, data LimitData, charged charge) error {
+	return r.store.RefundWindowLog(key, charged.id, charged.cost)
+}
@@ -0,0 +1,133 @@
+package ratelimiter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestSlidingWindowLogAllows tests that a request with room in the log is allowed
+func TestSlidingWindowLogAllows(t *testing.T) {
+	store := &MockStore{
+		GetBlockTTLFunc: func(key string) (time.Duration, bool, error) {
+			return 0, false, nil
+		},
+		SlidingWindowLogFunc: func(key string, id string, limit int64, cost int64, window time.Duration, now time.Time) (int64, bool, error) {
+			assert.Equal(t, "testKey", key)
+			assert.Equal(t, int64(3), limit)
+			assert.Equal(t, 10*time.Second, window)
+			return 1, true, nil
+		},
+	}
+	rateLimiter := NewRateLimiter(store)
+
+	decision, err := rateLimiter.Evaluate("testKey", LimitData{
+		Algorithm:   AlgorithmSlidingWindowLog,
+		Seconds:     10,
+		MaxRequests: 3,
+	})
+	assert.NoError(t, err)
+	assert.False(t, decision.Limited)
+	assert.Equal(t, int64(2), decision.Remaining)
+}
+
+// TestSlidingWindowLogRejectsAndBlocks tests that a full log limits and blocks the key
+func TestSlidingWindowLogRejectsAndBlocks(t *testing.T) {
+	blocked := false
+	store := &MockStore{
+		GetBlockTTLFunc: func(key string) (time.Duration, bool, error) {
+			return 0, false, nil
+		},
+		SlidingWindowLogFunc: func(key string, id string, limit int64, cost int64, window time.Duration, now time.Time) (int64, bool, error) {
+			return 3, false, nil
+		},
+		SetBlockDurationFunc: func(key string, value int64, expiration time.Duration) error {
+			assert.Equal(t, "blocked:testKey", key)
+			assert.Equal(t, 30*time.Second, expiration)
+			blocked = true
+			return nil
+		},
+		SaveBlockInfoFunc: func(info BlockInfo, expiration time.Duration) error {
+			assert.Equal(t, AlgorithmSlidingWindowLog, info.Policy)
+			return nil
+		},
+	}
+	rateLimiter := NewRateLimiter(store)
+
+	decision, err := rateLimiter.Evaluate("testKey", LimitData{
+		Algorithm:     AlgorithmSlidingWindowLog,
+		Seconds:       10,
+		MaxRequests:   3,
+		BlockDuration: 30,
+	})
+	assert.NoError(t, err)
+	assert.True(t, decision.Limited)
+	assert.Equal(t, 30*time.Second, decision.RetryAfter)
+	assert.True(t, decision.Blocked)
+	assert.True(t, blocked)
+}
+
+// TestSlidingWindowLogOversizedCost tests that a request costing more than the limit does not block the key
+func TestSlidingWindowLogOversizedCost(t *testing.T) {
+	store := &MockStore{
+		GetBlockTTLFunc: func(key string) (time.Duration, bool, error) {
+			return 0, false, nil
+		},
+		SlidingWindowLogFunc: func(key string, id string, limit int64, cost int64, window time.Duration, now time.Time) (int64, bool, error) {
+			return 0, false, nil
+		},
+	}
+	rateLimiter := NewRateLimiter(store)
+
+	decision, err := rateLimiter.EvaluateCost("testKey", LimitData{
+		Algorithm:     AlgorithmSlidingWindowLog,
+		Seconds:       10,
+		MaxRequests:   3,
+		BlockDuration: 30,
+	}, 4, false)
+	assert.NoError(t, err)
+	assert.True(t, decision.Limited)
+	assert.False(t, decision.Blocked)
+}
+
+// TestSlidingWindowLogWithoutBlockDuration tests that a full log limits without blocking
+func TestSlidingWindowLogWithoutBlockDuration(t *testing.T) {
+	store := &MockStore{
+		GetBlockTTLFunc: func(key string) (time.Duration, bool, error) {
+			return 0, false, nil
+		},
+		SlidingWindowLogFunc: func(key string, id string, limit int64, cost int64, window time.Duration, now time.Time) (int64, bool, error) {
+			return 3, false, nil
+		},
+	}
+	rateLimiter := NewRateLimiter(store)
+
+	decision, err := rateLimiter.Evaluate("testKey", LimitData{
+		Algorithm:   AlgorithmSlidingWindowLog,
+		Seconds:     10,
+		MaxRequests: 3,
+	})
+	assert.NoError(t, err)
+	assert.True(t, decision.Limited)
+}
+
+// TestSlidingWindowLogWhileBlocked tests that a blocked key is limited without touching the log
+func TestSlidingWindowLogWhileBlocked(t *testing.T) {
+	store := &MockStore{
+		GetBlockTTLFunc: func(key string) (time.Duration, bool, error) {
+			assert.Equal(t, "blocked:testKey", key)
+			return 20 * time.Second, true, nil
+		},
+	}
+	rateLimiter := NewRateLimiter(store)
+
+	decision, err := rateLimiter.Evaluate("testKey", LimitData{
+		Algorithm:   AlgorithmSlidingWindowLog,
+		Seconds:     10,
+		MaxRequests: 3,
+	})
+	assert.NoError(t, err)
+	assert.True(t, decision.Limited)
+	assert.Equal(t, 20*time.Second, decision.RetryAfter)
+}
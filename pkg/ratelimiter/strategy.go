@@ -0,0 +1,109 @@
+package ratelimiter
+
+import (
+	"time"
+)
+
+const (
+	AlgorithmFixedWindow      = "fixed_window"
+	AlgorithmSlidingWindowLog = "sliding_window_log"
+	AlgorithmTokenBucket      = "token_bucket"
+	AlgorithmGCRA             = "gcra"
+	AlgorithmLeakyBucket      = "leaky_bucket"
+	AlgorithmSlidingWindow    = "sliding_window"
+)
+
+// Decision is the outcome of evaluating a single request against the limit of
+// a key, with enough detail for callers to build quota headers from it.
+type Decision struct {
+	Limited bool
+	// Limit is the number of requests the key may make in a full quota.
+	Limit int64
+	// Remaining is how many more requests the key can make right now.
+	Remaining int64
+	// RetryAfter is how long the caller must wait before a limited request
+	// would be allowed. It is zero when the request was allowed.
+	RetryAfter time.Duration
+	// ResetAfter is how long until the key is back to its full quota.
+	ResetAfter time.Duration
+	// Delay is how long an allowed request must wait before it is served.
+	Delay time.Duration
+	// Blocked is set when the request got the key blocked.
+	Blocked bool
+	// charged is what an allowed request was charged, for Refund.
+	charged charge
+}
+
+// charge is what a request was charged: its cost and, for the algorithms that
+// tell requests apart, the log entry or the time of the window it was counted
+// in, so that a refund gives back exactly that.
+type charge struct {
+	cost int64
+	id   string
+	at   time.Time
+}
+
+// Strategy is a rate limiting algorithm the RateLimiter dispatches to based on
+// the Algorithm field of a key's LimitData. Limit never blocks the key of a
+// DryRun limit. Refund gives back what a request Limit allowed was charged.
+type Strategy interface {
+	Limit(r *RateLimiter, key string, data LimitData, cost int64) (Decision, error)
+	Refund(r *RateLimiter, key string, data LimitData, charged charge) error
+}
+
+// fixedWindow counts requests in a window that starts with the first request
+// and blocks the key once the counter goes over MaxRequests. The block check,
+// the increment, the window expiry and the block are one atomic store call,
+// and the block info is recorded after it. Dry-run limits count without a
+// block.
+type fixedWindow struct{}
+
+func (fixedWindow) Limit(r *RateLimiter, key string, data LimitData, cost int64) (Decision, error) {
+	window := time.Duration(data.Seconds) * time.Second
+	blockDuration := time.Duration(data.BlockDuration) * time.Second
+	if data.DryRun {
+		blockDuration = 0
+	}
+	decision, err := r.store.CheckAndIncrement(key, data.MaxRequests, cost, window, blockDuration)
+	if err != nil || !decision.Blocked {
+		return decision, err
+	}
+	_, err = r.saveBlockInfo(BlockInfo{Key: key, Type: BlockTypeLimit, Policy: data.policy()}, blockDuration)
+	return decision, err
+}
+
+func (fixedWindow) Refund(r *RateLimiter, key string, data LimitData, charged charge) error {
+	return r.store.RefundCount(key, charged.cost)
+}
+
+// reject limits the request and, when the limit data asks for it, blocks the
+// key for BlockDuration seconds, which then also becomes the retry delay. A
+// request costing more than the whole quota could never be allowed, so it is
+// refused without blocking the key, and so is the request of a dry-run limit.
+func (r *RateLimiter) reject(key string, data LimitData, decision Decision, cost int64) (Decision, error) {
+	decision.Limited = true
+	decision.Remaining = 0
+	if data.BlockDuration > 0 && !data.DryRun && cost <= decision.Limit {
+		blockDuration := time.Duration(data.BlockDuration) * time.Second
+		_, err := r.block(BlockInfo{Key: key, Type: BlockTypeLimit, Policy: data.policy()}, blockDuration)
+		if err != nil {
+			return Decision{}, err
+		}
+		decision.Blocked = true
+		decision.RetryAfter = blockDuration
+		if decision.ResetAfter < blockDuration {
+			decision.ResetAfter = blockDuration
+		}
+	}
+	return decision, nil
+}
+
+// blocked returns the decision for key when it is blocked, with the time left
+// on the block as the retry delay.
+func (r *RateLimiter) blocked(key string, limit int64) (Decision, bool, error) {
+	ttl, blocked, err := r.BlockTTL(key)
+	if err != nil || !blocked {
+		return Decision{}, false, err
+	}
+	return Decision{Limited: true, Limit: limit, RetryAfter: ttl, ResetAfter: ttl}, true, nil
+}
@@ -0,0 +1,42 @@
+package ratelimiter
+
+import (
+	"math"
+	"time"
+)
+
+// tokenBucket lets a key burst up to Capacity requests and then smooths its
+// traffic to RefillPerSecond requests per second.
+type tokenBucket struct{}
+
+func (tokenBucket) Limit(r *RateLimiter, key string, data LimitData, cost int64) (Decision, error) {
+	if decision, blocked, err := r.blocked(key, data.Capacity); err != nil || blocked {
+		return decision, err
+	}
+
+	tokens, allowed, err := r.store.TakeToken(key, data.Capacity, data.RefillPerSecond, cost, r.now())
+	if err != nil {
+		return Decision{}, err
+	}
+
+	decision := Decision{
+		Limit:      data.Capacity,
+		Remaining:  int64(math.Floor(tokens)),
+		ResetAfter: refillTime(float64(data.Capacity)-tokens, data.RefillPerSecond),
+	}
+	if !allowed {
+		decision.RetryAfter = refillTime(float64(cost)-tokens, data.RefillPerSecond)
+		return r.reject(key, data, decision, cost)
+	}
+
+	return decision, nil
+}
+
+func (tokenBucket) Refund(r *RateLimiter, key string, data LimitData, charged charge) error {
+	return r.store.RefundTokens(key, data.Capacity, charged.cost)
+}
+
+// refillTime returns how long it takes to refill the given amount of tokens.
+func refillTime(tokens float64, refillPerSecond float64) time.Duration {
+	return time.Duration(math.Ceil(tokens / refillPerSecond * float64(time.Second)))
+}
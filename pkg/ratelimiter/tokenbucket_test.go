@@ -0,0 +1,56 @@
+package ratelimiter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestTokenBucketAllows tests that a request is allowed while the bucket has tokens
+func TestTokenBucketAllows(t *testing.T) {
+	store := &MockStore{
+		GetBlockTTLFunc: func(key string) (time.Duration, bool, error) {
+			return 0, false, nil
+		},
+		TakeTokenFunc: func(key string, capacity int64, refillPerSecond float64, cost int64, now time.Time) (float64, bool, error) {
+			assert.Equal(t, "testKey", key)
+			assert.Equal(t, int64(10), capacity)
+			assert.Equal(t, 2.5, refillPerSecond)
+			return 9, true, nil
+		},
+	}
+	rateLimiter := NewRateLimiter(store)
+
+	decision, err := rateLimiter.Evaluate("testKey", LimitData{
+		Algorithm:       AlgorithmTokenBucket,
+		Capacity:        10,
+		RefillPerSecond: 2.5,
+	})
+	assert.NoError(t, err)
+	assert.False(t, decision.Limited)
+	assert.Equal(t, int64(9), decision.Remaining)
+	assert.Equal(t, 400*time.Millisecond, decision.ResetAfter)
+}
+
+// TestTokenBucketEmpty tests that an empty bucket limits without blocking by default
+func TestTokenBucketEmpty(t *testing.T) {
+	store := &MockStore{
+		GetBlockTTLFunc: func(key string) (time.Duration, bool, error) {
+			return 0, false, nil
+		},
+		TakeTokenFunc: func(key string, capacity int64, refillPerSecond float64, cost int64, now time.Time) (float64, bool, error) {
+			return 0.4, false, nil
+		},
+	}
+	rateLimiter := NewRateLimiter(store)
+
+	decision, err := rateLimiter.Evaluate("testKey", LimitData{
+		Algorithm:       AlgorithmTokenBucket,
+		Capacity:        10,
+		RefillPerSecond: 2.5,
+	})
+	assert.NoError(t, err)
+	assert.True(t, decision.Limited)
+	assert.Equal(t, 240*time.Millisecond, decision.RetryAfter)
+}
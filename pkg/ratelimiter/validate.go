@@ -0,0 +1,96 @@
+package ratelimiter
+
+import (
+	"strings"
+	"time"
+)
+
+// FieldError says why a field of the limit data is not valid.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists the fields of the limit data that are not valid.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Fields))
+	for i, field := range e.Fields {
+		messages[i] = field.Field + " " + field.Message
+	}
+	return "invalid limit data: " + strings.Join(messages, ", ")
+}
+
+// Validate checks that the limit data has a key, a known algorithm, no
+// negative values and the settings its algorithm needs. It returns a
+// *ValidationError naming every invalid field.
+func (d LimitData) Validate() error {
+	var fields []FieldError
+	add := func(field string, message string) {
+		fields = append(fields, FieldError{Field: field, Message: message})
+	}
+
+	if d.Key == "" {
+		add("key", "is required")
+	}
+
+	for _, value := range []struct {
+		field string
+		value float64
+	}{
+		{"seconds", float64(d.Seconds)},
+		{"block_duration", float64(d.BlockDuration)},
+		{"max_requests", float64(d.MaxRequests)},
+		{"capacity", float64(d.Capacity)},
+		{"refill_per_second", d.RefillPerSecond},
+		{"leak_per_second", d.LeakPerSecond},
+		{"max_queue", float64(d.MaxQueue)},
+		{"max_wait", float64(d.MaxWait)},
+		{"max_in_flight", float64(d.MaxInFlight)},
+		{"lease_seconds", float64(d.LeaseSeconds)},
+	} {
+		if value.value < 0 {
+			add(value.field, "must not be negative")
+		}
+	}
+
+	switch d.Algorithm {
+	case "", AlgorithmFixedWindow, AlgorithmSlidingWindow, AlgorithmSlidingWindowLog, AlgorithmGCRA:
+		if d.Seconds == 0 {
+			add("seconds", "is required")
+		}
+		if d.MaxRequests == 0 {
+			add("max_requests", "is required")
+		}
+		if d.Algorithm == AlgorithmGCRA && d.Seconds > 0 && d.MaxRequests > 0 &&
+			time.Duration(d.Seconds)*time.Second/time.Duration(d.MaxRequests) < time.Microsecond {
+			add("max_requests", "must not exceed one request per microsecond for "+AlgorithmGCRA)
+		}
+	case AlgorithmTokenBucket:
+		if d.Capacity == 0 {
+			add("capacity", "is required for "+AlgorithmTokenBucket)
+		}
+		if d.RefillPerSecond == 0 {
+			add("refill_per_second", "is required for "+AlgorithmTokenBucket)
+		}
+	case AlgorithmLeakyBucket:
+		if d.LeakPerSecond == 0 {
+			add("leak_per_second", "is required for "+AlgorithmLeakyBucket)
+		} else if d.LeakPerSecond > 0 && leakInterval(d.LeakPerSecond) <= 0 {
+			add("leak_per_second", "must not exceed one request per microsecond")
+		}
+	default:
+		add("algorithm", "must be one of "+strings.Join([]string{
+			AlgorithmFixedWindow, AlgorithmSlidingWindow, AlgorithmSlidingWindowLog,
+			AlgorithmTokenBucket, AlgorithmGCRA, AlgorithmLeakyBucket,
+		}, ", "))
+	}
+
+	if len(fields) > 0 {
+		return &ValidationError{Fields: fields}
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package ratelimiter
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestLimitDataValidate tests that Validate names every invalid field
+func TestLimitDataValidate(t *testing.T) {
+	assert.NoError(t, LimitData{Key: "a", Seconds: 10, MaxRequests: 5}.Validate())
+	assert.NoError(t, LimitData{Key: "a", Algorithm: AlgorithmTokenBucket, Capacity: 10, RefillPerSecond: 0.5}.Validate())
+	assert.NoError(t, LimitData{Key: "a", Algorithm: AlgorithmLeakyBucket, LeakPerSecond: 2, MaxQueue: 10}.Validate())
+
+	tests := []struct {
+		data   LimitData
+		fields []string
+	}{
+		{LimitData{Seconds: 10, MaxRequests: 5}, []string{"key"}},
+		{LimitData{Key: "a"}, []string{"seconds", "max_requests"}},
+		{LimitData{Key: "a", Seconds: 10, MaxRequests: -1, BlockDuration: -5}, []string{"block_duration", "max_requests"}},
+		{LimitData{Key: "a", Algorithm: AlgorithmTokenBucket, Capacity: 10}, []string{"refill_per_second"}},
+		{LimitData{Key: "a", Algorithm: "fastest"}, []string{"algorithm"}},
+	}
+	for _, test := range tests {
+		var validationErr *ValidationError
+		err := test.data.Validate()
+		if !assert.True(t, errors.As(err, &validationErr), "%+v", test.data) {
+			continue
+		}
+		var fields []string
+		for _, field := range validationErr.Fields {
+			fields = append(fields, field.Field)
+		}
+		assert.ElementsMatch(t, test.fields, fields, "%+v", test.data)
+	}
+}
@@ -2,9 +2,11 @@ package server
 
 import (
 	"encoding/json"
+	"expvar"
 	"github.com/pkg/browser"
 	"log"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
@@ -16,6 +18,7 @@ import (
 
 type Server struct {
 	rateLimiterMiddleware *middleware.RateLimiterMiddleware
+	adminAuth             *middleware.AdminAuth
 }
 
 type AuthTokenResponse struct {
@@ -26,9 +29,10 @@ type IndexResponse struct {
 	Message string `json:"message"`
 }
 
-func NewServer(rateLimiterMiddleware *middleware.RateLimiterMiddleware) *Server {
+func NewServer(rateLimiterMiddleware *middleware.RateLimiterMiddleware, adminAuth *middleware.AdminAuth) *Server {
 	return &Server{
 		rateLimiterMiddleware: rateLimiterMiddleware,
+		adminAuth:             adminAuth,
 	}
 }
 
@@ -42,9 +46,15 @@ func (s *Server) Start() {
 	})
 
 	mux.Handle("/home", s.rateLimiterMiddleware.Middleware(http.HandlerFunc(s.Index)))
-	//update-rate-limiter/${id}
-	mux.HandleFunc("/update-rate-limiter/", s.rateLimiterMiddleware.UpdateRateLimiter)
-	mux.HandleFunc("/get-all-rate-limiter", s.rateLimiterMiddleware.GetAllRateLimiter)
+	// Rotas de administração, protegidas pelo AdminAuth
+	//update-rate-limiter/${key}
+	mux.Handle("/update-rate-limiter/", s.adminAuth.Require(middleware.RoleWrite, http.HandlerFunc(s.rateLimiterMiddleware.UpdateRateLimiter)))
+	mux.Handle("/get-all-rate-limiter", s.adminAuth.Require(middleware.RoleRead, http.HandlerFunc(s.rateLimiterMiddleware.GetAllRateLimiter)))
+	mux.HandleFunc("/access-rules", s.AccessRules)
+	mux.HandleFunc(middleware.LimitsPath, s.Limits)
+	mux.HandleFunc(middleware.LimitsPath+"/", s.Limits)
+	mux.HandleFunc(middleware.KeysPath+"/", s.Keys)
+	mux.Handle("/debug/vars", s.adminAuth.Require(middleware.RoleRead, expvar.Handler()))
 	// atualizar dados do rate limiter do ip ou token
 	log.Println("Starting server on :8080")
 
@@ -57,6 +67,106 @@ func (s *Server) Start() {
 
 }
 
+// AccessRules dispatches the access rule admin endpoints on the method,
+// reading with the read role and changing the rules with the write role.
+func (s *Server) AccessRules(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.adminAuth.Require(middleware.RoleRead, http.HandlerFunc(s.rateLimiterMiddleware.GetAccessRules)).ServeHTTP(w, r)
+	case http.MethodPost:
+		s.adminAuth.Require(middleware.RoleWrite, http.HandlerFunc(s.rateLimiterMiddleware.AddAccessRule)).ServeHTTP(w, r)
+	case http.MethodDelete:
+		s.adminAuth.Require(middleware.RoleWrite, http.HandlerFunc(s.rateLimiterMiddleware.DeleteAccessRule)).ServeHTTP(w, r)
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+// Limits dispatches the /v1/limits collection and its items on the method,
+// reading with the read role and changing the limits with the write role.
+func (s *Server) Limits(w http.ResponseWriter, r *http.Request) {
+	m := s.rateLimiterMiddleware
+	collection := r.URL.Path == middleware.LimitsPath
+	var role string
+	var handler http.HandlerFunc
+	switch {
+	case collection && r.Method == http.MethodGet:
+		role, handler = middleware.RoleRead, m.ListLimits
+	case collection && r.Method == http.MethodPost:
+		role, handler = middleware.RoleWrite, m.CreateLimit
+	case !collection && r.Method == http.MethodGet:
+		role, handler = middleware.RoleRead, m.GetLimit
+	case !collection && r.Method == http.MethodPut:
+		role, handler = middleware.RoleWrite, m.ReplaceLimit
+	case !collection && r.Method == http.MethodPatch:
+		role, handler = middleware.RoleWrite, m.PatchLimit
+	case !collection && r.Method == http.MethodDelete:
+		role, handler = middleware.RoleWrite, m.DeleteLimit
+	default:
+		if collection {
+			w.Header().Set("Allow", "GET, POST")
+		} else {
+			w.Header().Set("Allow", "GET, PUT, PATCH, DELETE")
+		}
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	s.adminAuth.Require(role, handler).ServeHTTP(w, r)
+}
+
+// Keys dispatches the endpoints of /v1/keys: the listing of the blocked keys
+// with the read role, and the resets, unblocks and bans with the write role.
+// The bulk variants are the ones without a key in the path.
+func (s *Server) Keys(w http.ResponseWriter, r *http.Request) {
+	m := s.rateLimiterMiddleware
+	if r.URL.Path == middleware.KeysPath+"/blocked" {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		s.adminAuth.Require(middleware.RoleRead, http.HandlerFunc(m.ListBlocked)).ServeHTTP(w, r)
+		return
+	}
+	if strings.HasSuffix(r.URL.Path, "/ban") {
+		var handler http.HandlerFunc
+		switch r.Method {
+		case http.MethodPost:
+			handler = m.BanKey
+		case http.MethodDelete:
+			handler = m.UnbanKey
+		default:
+			w.Header().Set("Allow", "POST, DELETE")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		s.adminAuth.Require(middleware.RoleWrite, handler).ServeHTTP(w, r)
+		return
+	}
+
+	var handler http.HandlerFunc
+	switch path := r.URL.Path; {
+	case path == middleware.KeysPath+"/reset":
+		handler = m.ResetMatching
+	case path == middleware.KeysPath+"/unblock":
+		handler = m.UnblockMatching
+	case strings.HasSuffix(path, "/reset"):
+		handler = m.ResetKey
+	case strings.HasSuffix(path, "/unblock"):
+		handler = m.UnblockKey
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	s.adminAuth.Require(middleware.RoleWrite, handler).ServeHTTP(w, r)
+}
+
 // Index godoc
 // @Summary Welcome to the rate limited index page!
 // @Description get index